@@ -3,15 +3,31 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/blob"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/holiday"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/leader"
 	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/logger"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/ratelimit"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/sender"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/server/admin"
+	internalgrpc "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/server/grpc"
 	internalhttp "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/server/http"
 	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	redisstorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/redis"
+	sqlstorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/sql"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 )
 
 var configFile string
@@ -28,19 +44,87 @@ func main() {
 		return
 	}
 
-	config := NewConfig()
+	config, err := NewConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	logg := logger.New(config.Logger.Level)
 
-	storage := memorystorage.New()
-	calendar := app.New(logg, storage)
+	storage, err := newStorage(config.Storage)
+	if err != nil {
+		logg.Error("failed to open storage: " + err.Error())
+		os.Exit(1)
+	}
+	tracer, err := newTracer(config.Tracing, logg)
+	if err != nil {
+		logg.Error("failed to create tracer: " + err.Error())
+		os.Exit(1)
+	}
+
+	opts := []app.Option{
+		app.WithDeletedRetention(config.Storage.DeletedRetention),
+		app.WithTracer(tracer),
+		app.WithAdmins(config.Auth.Admins...),
+		app.WithRetries(config.Storage.Retry.Attempts, config.Storage.Retry.Backoff),
+	}
+	if config.Storage.Breaker.Failures > 0 {
+		opts = append(opts, app.WithCircuitBreaker(config.Storage.Breaker.Failures, config.Storage.Breaker.Cooldown))
+	}
+	if config.Audit.Log {
+		opts = append(opts, app.WithAuditLog())
+	}
+	if config.Attachments.Dir != "" {
+		store, err := blob.NewLocal(config.Attachments.Dir)
+		if err != nil {
+			logg.Error("failed to open attachments store: " + err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, app.WithBlobStore(store))
+	}
+	holidays, err := loadHolidays(config.Holidays)
+	if err != nil {
+		logg.Error("failed to load holidays: " + err.Error())
+		os.Exit(1)
+	}
+	senders, err := newSenders(config.Sender, logg)
+	if err != nil {
+		logg.Error("failed to create senders: " + err.Error())
+		os.Exit(1)
+	}
+	opts = append(opts, app.WithSenders(senders...))
+	opts = append(opts, app.WithHolidays(holidays...),
+		app.WithTenants(newTenantStorages(storage), tenants(config.Tenants)))
+	calendar := app.New(logg, storage, opts...)
+
+	var limiter internalhttp.RateLimiter
+	if config.RateLimit.RPS > 0 {
+		limiter = ratelimit.New(config.RateLimit.RPS, config.RateLimit.Burst)
+	}
+
+	authenticator := newAuthenticator(config.Auth)
+	api := internalgrpc.NewService(logg, calendar)
 
-	server := internalhttp.NewServer(logg, calendar)
+	server, err := internalhttp.NewServer(logg, api, authenticator, limiter, tracer, calendar,
+		config.HTTP.Host, config.HTTP.Port)
+	if err != nil {
+		logg.Error("failed to create http server: " + err.Error())
+		os.Exit(1)
+	}
+	grpcServer := internalgrpc.NewServer(logg, api, authenticator, limiter, tracer,
+		config.GRPC.Host, config.GRPC.Port)
+	var adminServer *admin.Server
+	if config.Admin.Token != "" {
+		adminServer = admin.NewServer(logg, calendar, config.Admin.Token, config.Admin.Host, config.Admin.Port)
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(),
 		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	defer cancel()
 
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		<-ctx.Done()
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
@@ -49,13 +133,260 @@ func main() {
 		if err := server.Stop(ctx); err != nil {
 			logg.Error("failed to stop http server: " + err.Error())
 		}
+		if err := grpcServer.Stop(ctx); err != nil {
+			logg.Error("failed to stop grpc server: " + err.Error())
+		}
+		if adminServer != nil {
+			if err := adminServer.Stop(ctx); err != nil {
+				logg.Error("failed to stop admin server: " + err.Error())
+			}
+		}
+		if s, ok := storage.(interface{ Close(context.Context) error }); ok {
+			if err := s.Close(ctx); err != nil {
+				logg.Error("failed to close storage: " + err.Error())
+			}
+		}
 	}()
 
 	logg.Info("calendar is running...")
 
+	go runReminders(ctx, logg, calendar, storage, config.Reminders)
+	if s, ok := storage.(*memorystorage.Storage); ok && config.Storage.Memory.Dir != "" && config.Storage.Memory.SnapshotInterval > 0 {
+		go runSnapshots(ctx, logg, s, config.Storage.Memory.SnapshotInterval)
+	}
+
+	go func() {
+		if err := grpcServer.Start(ctx); err != nil {
+			logg.Error("failed to start grpc server: " + err.Error())
+			cancel()
+		}
+	}()
+
+	if adminServer != nil {
+		go func() {
+			if err := adminServer.Start(ctx); err != nil {
+				logg.Error("failed to start admin server: " + err.Error())
+				cancel()
+			}
+		}()
+	}
+
 	if err := server.Start(ctx); err != nil {
 		logg.Error("failed to start http server: " + err.Error())
 		cancel()
 		os.Exit(1) //nolint:gocritic
 	}
+	<-stopped
+}
+
+func newStorage(conf StorageConf) (app.Storage, error) {
+	switch conf.Type {
+	case "memory":
+		if conf.Memory.Dir == "" {
+			return memorystorage.New(), nil
+		}
+		storage, err := memorystorage.Open(conf.Memory.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("open memory storage in %s: %w", conf.Memory.Dir, err)
+		}
+		return storage, nil
+	case "redis":
+		storage := redisstorage.New(&redis.Options{
+			Addr:     conf.Redis.Addr,
+			Password: conf.Redis.Password,
+			DB:       conf.Redis.DB,
+		}, conf.Redis.Prefix)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		if err := storage.Connect(ctx); err != nil {
+			return nil, fmt.Errorf("connect to redis %s: %w", conf.Redis.Addr, err)
+		}
+		return storage, nil
+	case "sql":
+		storage := sqlstorage.New(conf.SQL.DSN)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		if err := storage.Connect(ctx); err != nil {
+			return nil, fmt.Errorf("connect to sql storage: %w", err)
+		}
+		return storage, nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", conf.Type)
+	}
+}
+
+// runReminders sends reminders, from a single replica elected through the storage
+// if it supports leases and the lease is configured. Without a reminder outbox in the storage
+// the reminders due while another replica takes over are not sent.
+func runReminders(ctx context.Context, logg *logger.Logger, calendar *app.App, storage app.Storage,
+	conf RemindersConf,
+) {
+	lease, ok := storage.(leader.Lease)
+	if !ok || conf.Lease <= 0 {
+		calendar.RunReminders(ctx, conf.Interval)
+		return
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "calendar"
+	}
+	holder := fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8])
+	leader.New(logg, lease, "reminders", holder, conf.Lease).Run(ctx, func(ctx context.Context) {
+		calendar.RunReminders(ctx, conf.Interval)
+	})
+}
+
+// runSnapshots periodically compacts the journal of the memory storage into a snapshot.
+func runSnapshots(ctx context.Context, logg *logger.Logger, storage *memorystorage.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := storage.Snapshot(); err != nil {
+			logg.Error("failed to snapshot storage: " + err.Error())
+		}
+	}
+}
+
+// newTracer returns the tracer configured by conf or nil if tracing is disabled.
+func newTracer(conf TracingConf, logg *logger.Logger) (*tracing.Tracer, error) {
+	switch conf.Exporter {
+	case "":
+		return nil, nil
+	case "log":
+		return tracing.New(tracing.NewLogExporter(logg)), nil
+	case "file":
+		exporter, err := tracing.NewFileExporter(conf.File, func(err error) {
+			logg.Error(err.Error())
+		})
+		if err != nil {
+			return nil, err
+		}
+		return tracing.New(exporter), nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", conf.Exporter)
+	}
+}
+
+// loadHolidays reads the holiday calendars listed in conf.
+func loadHolidays(conf HolidaysConf) ([]*holiday.Calendar, error) {
+	calendars := make([]*holiday.Calendar, 0, len(conf.Files))
+	for name, path := range conf.Files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		holidays, err := holiday.ParseICS(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		calendars = append(calendars, holiday.New(name, holidays))
+	}
+	return calendars, nil
+}
+
+// newSenders creates the backends reminders are delivered through, the configured ones only.
+func newSenders(conf SenderConf, logg *logger.Logger) ([]sender.Backend, error) {
+	retry := sender.RetryPolicy{
+		MaxAttempts: conf.Retry.Attempts,
+		BaseBackoff: conf.Retry.Backoff,
+		MaxBackoff:  conf.Retry.MaxBackoff,
+	}
+	dlq := logDeadLetters{logg}
+
+	var senders []sender.Backend
+	if len(conf.Webhook.URLs) > 0 {
+		if conf.Webhook.Secret == "" {
+			return nil, fmt.Errorf("webhooks need a secret to sign requests")
+		}
+		client := &http.Client{Timeout: conf.Webhook.Timeout}
+		senders = append(senders,
+			sender.NewWebhook(client, sender.WebhookURLs(conf.Webhook.URLs), conf.Webhook.Secret, retry, dlq))
+	}
+	if len(conf.SMTP.Addresses) > 0 {
+		smtp, err := sender.NewSMTP(sender.SMTPConfig{
+			Host:               conf.SMTP.Host,
+			Port:               conf.SMTP.Port,
+			Username:           conf.SMTP.Username,
+			Password:           conf.SMTP.Password,
+			From:               conf.SMTP.From,
+			TLS:                conf.SMTP.TLS,
+			CAFile:             conf.SMTP.CAFile,
+			InsecureSkipVerify: conf.SMTP.InsecureSkipVerify,
+			SubjectTemplate:    conf.SMTP.Subject,
+			BodyTemplate:       conf.SMTP.Body,
+			Timeout:            conf.SMTP.Timeout,
+		}, sender.EmailAddresses(conf.SMTP.Addresses), retry, dlq)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, smtp)
+	}
+	return senders, nil
+}
+
+// logDeadLetters writes undeliverable reminders to the log, where operators can find them.
+type logDeadLetters struct {
+	logg *logger.Logger
+}
+
+func (q logDeadLetters) Put(_ context.Context, letter sender.DeadLetter) error {
+	q.logg.Error(fmt.Sprintf("dead letter: %s reminder of event %s to user %q after %d attempts: %s",
+		letter.Backend, letter.Notification.EventID, letter.Notification.UserID, letter.Attempts, letter.Err))
+	return nil
+}
+
+// newTenantStorages returns the storages of tenants, which are of the same backend as storage.
+func newTenantStorages(storage app.Storage) app.TenantStorages {
+	return app.TenantFunc(func(id string) (app.Storage, error) {
+		switch s := storage.(type) {
+		case *memorystorage.Storage:
+			return s.Tenant(id)
+		case *redisstorage.Storage:
+			return s.Tenant(id), nil
+		case *sqlstorage.Storage:
+			return s.Tenant(id), nil
+		default:
+			return nil, fmt.Errorf("storage %T does not support tenants", storage)
+		}
+	})
+}
+
+func tenants(conf map[string]TenantConf) map[string]app.Tenant {
+	tenants := make(map[string]app.Tenant, len(conf))
+	for id, tenant := range conf {
+		tenants[id] = app.Tenant{MaxEvents: tenant.MaxEvents}
+	}
+	return tenants
+}
+
+func newAuthenticator(conf AuthConf) auth.Schemes {
+	schemes := auth.Schemes{}
+	if conf.JWTSecret != "" {
+		schemes[auth.SchemeBearer] = auth.NewJWT([]byte(conf.JWTSecret))
+	}
+	if len(conf.APIKeys) > 0 {
+		keys := make(auth.APIKeys, len(conf.APIKeys))
+		for key, user := range conf.APIKeys {
+			keys[key] = parseIdentity(user)
+		}
+		schemes[auth.SchemeAPIKey] = keys
+		schemes[auth.SchemeBasic] = auth.BasicAPIKeys(keys)
+	}
+	return schemes
+}
+
+// parseIdentity reads "<user ID>" or "<tenant ID>/<user ID>".
+func parseIdentity(user string) auth.Identity {
+	if i := strings.Index(user, "/"); i >= 0 {
+		return auth.Identity{TenantID: user[:i], UserID: user[i+1:]}
+	}
+	return auth.Identity{UserID: user}
 }
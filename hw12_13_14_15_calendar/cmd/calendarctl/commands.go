@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/export"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type command func(ctx context.Context, client eventpb.EventServiceClient, args []string, p printer) error
+
+var commands = map[string]command{
+	"create": create,
+	"update": update,
+	"delete": deleteEvent,
+	"list":   list,
+	"export": exportEvents,
+}
+
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04"}
+
+func create(ctx context.Context, client eventpb.EventServiceClient, args []string, p printer) error {
+	flags := newFlagSet("create")
+	event := eventFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	e, err := event()
+	if err != nil {
+		return err
+	}
+	created, err := client.CreateEvent(ctx, &eventpb.CreateEventRequest{Event: e})
+	if err != nil {
+		return err
+	}
+	return p.event(created)
+}
+
+func update(ctx context.Context, client eventpb.EventServiceClient, args []string, p printer) error {
+	flags := newFlagSet("update")
+	id := flags.String("id", "", "ID of the event to replace")
+	event := eventFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	e, err := event()
+	if err != nil {
+		return err
+	}
+	updated, err := client.UpdateEvent(ctx, &eventpb.UpdateEventRequest{Id: *id, Event: e})
+	if err != nil {
+		return err
+	}
+	return p.event(updated)
+}
+
+func deleteEvent(ctx context.Context, client eventpb.EventServiceClient, args []string, p printer) error {
+	flags := newFlagSet("delete")
+	id := flags.String("id", "", "ID of the event to delete")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	if _, err := client.DeleteEvent(ctx, &eventpb.DeleteEventRequest{Id: *id}); err != nil {
+		return err
+	}
+	return p.deleted(*id)
+}
+
+func list(ctx context.Context, client eventpb.EventServiceClient, args []string, p printer) error {
+	flags := newFlagSet("list")
+	period := flags.String("period", "day", "day, week or month")
+	date := flags.String("date", time.Now().Format("2006-01-02"), "first day of the period")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	value, ok := eventpb.Period_value[strings.ToUpper(*period)]
+	if !ok || value == int32(eventpb.Period_PERIOD_UNSPECIFIED) {
+		return fmt.Errorf("unknown period %q", *period)
+	}
+	from, err := time.ParseInLocation("2006-01-02", *date, time.Local)
+	if err != nil {
+		return fmt.Errorf("bad date: %w", err)
+	}
+
+	resp, err := client.ListEvents(ctx, &eventpb.ListEventsRequest{
+		Period: eventpb.Period(value),
+		Date:   timestamppb.New(from),
+	})
+	if err != nil {
+		return err
+	}
+	return p.events(resp)
+}
+
+func exportEvents(ctx context.Context, client eventpb.EventServiceClient, args []string, p printer) error {
+	flags := newFlagSet("export")
+	from := flags.String("from", "", "start of the period")
+	to := flags.String("to", "", "end of the period")
+	all := flags.Bool("all", false, "export the events of all users, for admins")
+	format := flags.String("format", export.FormatCSV, "csv or jsonl")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fromAt, err := parseTime(*from)
+	if err != nil {
+		return fmt.Errorf("bad -from: %w", err)
+	}
+	toAt, err := parseTime(*to)
+	if err != nil {
+		return fmt.Errorf("bad -to: %w", err)
+	}
+	w, err := export.NewWriter(p.writer(), *format)
+	if err != nil {
+		return err
+	}
+
+	stream, err := client.ExportEvents(ctx, &eventpb.ExportEventsRequest{
+		From:     timestamppb.New(fromAt),
+		To:       timestamppb.New(toAt),
+		AllUsers: *all,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		event, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.Write(event); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	return flags
+}
+
+// eventFlags defines the flags describing an event and returns a function building it once they are parsed.
+func eventFlags(flags *flag.FlagSet) func() (*eventpb.Event, error) {
+	title := flags.String("title", "", "title of the event")
+	start := flags.String("start", "", "start time")
+	end := flags.String("end", "", "end time")
+	description := flags.String("description", "", "description of the event")
+	notify := flags.Duration("notify", 0, "how long before the start to send a notification")
+
+	return func() (*eventpb.Event, error) {
+		startAt, err := parseTime(*start)
+		if err != nil {
+			return nil, fmt.Errorf("bad -start: %w", err)
+		}
+		endAt, err := parseTime(*end)
+		if err != nil {
+			return nil, fmt.Errorf("bad -end: %w", err)
+		}
+
+		event := &eventpb.Event{
+			Title:       *title,
+			StartAt:     timestamppb.New(startAt),
+			EndAt:       timestamppb.New(endAt),
+			Description: *description,
+		}
+		if *notify > 0 {
+			event.NotifyBefore = durationpb.New(*notify)
+		}
+		return event, nil
+	}
+}
+
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time like 2006-01-02 15:04", value)
+}
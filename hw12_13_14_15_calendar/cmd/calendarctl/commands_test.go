@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type fakeClient struct {
+	eventpb.EventServiceClient
+	created  *eventpb.Event
+	listed   *eventpb.ListEventsRequest
+	deleted  string
+	exported *eventpb.ExportEventsRequest
+}
+
+func (c *fakeClient) CreateEvent(_ context.Context, req *eventpb.CreateEventRequest,
+	_ ...grpc.CallOption,
+) (*eventpb.Event, error) {
+	c.created = req.GetEvent()
+	event := proto.Clone(req.GetEvent()).(*eventpb.Event)
+	event.Id = "1"
+	return event, nil
+}
+
+func (c *fakeClient) ListEvents(_ context.Context, req *eventpb.ListEventsRequest,
+	_ ...grpc.CallOption,
+) (*eventpb.ListEventsResponse, error) {
+	c.listed = req
+	return &eventpb.ListEventsResponse{Events: []*eventpb.Event{c.created}}, nil
+}
+
+func (c *fakeClient) DeleteEvent(_ context.Context, req *eventpb.DeleteEventRequest,
+	_ ...grpc.CallOption,
+) (*emptypb.Empty, error) {
+	c.deleted = req.GetId()
+	return &emptypb.Empty{}, nil
+}
+
+func (c *fakeClient) ExportEvents(_ context.Context, req *eventpb.ExportEventsRequest,
+	_ ...grpc.CallOption,
+) (eventpb.EventService_ExportEventsClient, error) {
+	c.exported = req
+	return &fakeExportStream{events: []*eventpb.Event{c.created}}, nil
+}
+
+type fakeExportStream struct {
+	grpc.ClientStream
+	events []*eventpb.Event
+}
+
+func (s *fakeExportStream) Recv() (*eventpb.Event, error) {
+	if len(s.events) == 0 {
+		return nil, io.EOF
+	}
+	event := s.events[0]
+	s.events = s.events[1:]
+	return event, nil
+}
+
+func TestCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create and list", func(t *testing.T) {
+		client := &fakeClient{}
+		out := &bytes.Buffer{}
+
+		err := create(ctx, client, []string{
+			"-title", "standup", "-start", "2021-06-01 10:00", "-end", "2021-06-01T11:00:00Z", "-notify", "15m",
+		}, tablePrinter{out: out})
+		require.NoError(t, err)
+		require.Equal(t, "standup", client.created.GetTitle())
+		require.Equal(t, time.Date(2021, 6, 1, 10, 0, 0, 0, time.Local), client.created.GetStartAt().AsTime().In(time.Local))
+		require.Equal(t, 15*time.Minute, client.created.GetNotifyBefore().AsDuration())
+		require.Contains(t, out.String(), "ID  TITLE")
+		require.Contains(t, out.String(), "1   standup  2021-06-01 10:00")
+
+		out.Reset()
+		err = list(ctx, client, []string{"-period", "week", "-date", "2021-06-01"}, jsonPrinter{out: out})
+		require.NoError(t, err)
+		require.Equal(t, eventpb.Period_WEEK, client.listed.GetPeriod())
+		// protojson randomizes whitespace, so compare the compacted output.
+		compact := &bytes.Buffer{}
+		require.NoError(t, json.Compact(compact, out.Bytes()))
+		require.Contains(t, compact.String(), `"title":"standup"`)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		client := &fakeClient{}
+		out := &bytes.Buffer{}
+
+		require.Error(t, deleteEvent(ctx, client, nil, tablePrinter{out: out}))
+		require.NoError(t, deleteEvent(ctx, client, []string{"-id", "1"}, tablePrinter{out: out}))
+		require.Equal(t, "1", client.deleted)
+		require.Equal(t, "deleted 1\n", out.String())
+	})
+
+	t.Run("export", func(t *testing.T) {
+		client := &fakeClient{created: &eventpb.Event{Id: "1", Title: "standup"}}
+		out := &bytes.Buffer{}
+
+		err := exportEvents(ctx, client, []string{
+			"-from", "2021-06-01T00:00:00Z", "-to", "2021-07-01T00:00:00Z", "-all", "-format", "jsonl",
+		}, tablePrinter{out: out})
+		require.NoError(t, err)
+		require.True(t, client.exported.GetAllUsers())
+		require.Equal(t, time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), client.exported.GetTo().AsTime())
+		require.Equal(t, 1, strings.Count(out.String(), "\n"))
+		var exported struct{ Title string }
+		require.NoError(t, json.Unmarshal(out.Bytes(), &exported))
+		require.Equal(t, "standup", exported.Title)
+
+		require.Error(t, exportEvents(ctx, client, []string{"-from", "2021-06-01T00:00:00Z"}, tablePrinter{out: out}))
+	})
+
+	t.Run("bad arguments", func(t *testing.T) {
+		client := &fakeClient{}
+		p := tablePrinter{out: &bytes.Buffer{}}
+
+		require.Error(t, create(ctx, client, []string{"-title", "standup", "-start", "tomorrow"}, p))
+		require.Error(t, list(ctx, client, []string{"-period", "year"}, p))
+		require.Error(t, update(ctx, client, []string{"-title", "standup"}, p))
+		require.Error(t, run(options{output: "yaml"}, []string{"list"}, p.out))
+		require.Error(t, run(options{}, []string{"purge"}, p.out))
+		require.ErrorIs(t, run(options{output: "table"}, []string{"create", "-h"}, p.out), flag.ErrHelp,
+			"usage is printed without credentials")
+	})
+
+	t.Run("credential", func(t *testing.T) {
+		credential, err := newCredential(options{apiKey: "secret", debugUserID: "alice", debugJWTSecret: "secret"})
+		require.NoError(t, err)
+		require.Equal(t, "ApiKey secret", credential)
+
+		credential, err = newCredential(options{token: "Bearer token"})
+		require.NoError(t, err)
+		require.Equal(t, "Bearer token", credential)
+
+		credential, err = newCredential(options{debugUserID: "alice", debugTenantID: "acme", debugJWTSecret: "secret"})
+		require.NoError(t, err)
+		identity, err := auth.Schemes{auth.SchemeBearer: auth.NewJWT([]byte("secret"))}.
+			Authenticate(ctx, credential)
+		require.NoError(t, err)
+		require.Equal(t, auth.Identity{UserID: "alice", TenantID: "acme"}, identity)
+
+		_, err = newCredential(options{debugUserID: "alice"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "-debug-jwt-secret")
+
+		_, err = newCredential(options{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "-api-key")
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tokenTTL is the lifetime of tokens issued for a single invocation in the debug mode.
+const tokenTTL = time.Minute
+
+const usage = `usage: calendarctl [flags] <command> [command flags]
+
+Commands:
+  create -title TITLE -start TIME -end TIME [-description TEXT] [-notify DURATION]
+  update -id ID -title TITLE -start TIME -end TIME [-description TEXT] [-notify DURATION]
+  delete -id ID
+  list [-period day|week|month] [-date DATE]
+  export -from TIME -to TIME [-all] [-format csv|jsonl]
+
+TIME is RFC 3339 or "2006-01-02 15:04" in the local time zone, DATE is "2006-01-02".
+Large exports may need a longer -timeout.
+
+Commands act as the user of the API key from -api-key, or of the credential from -token.
+-debug-user signs a token with the server's own JWT secret instead; it is meant for
+operators debugging a deployment, as anyone holding the secret can act as any user.
+
+Flags:
+`
+
+type options struct {
+	addr    string
+	apiKey  string
+	token   string
+	output  string
+	timeout time.Duration
+
+	// debugUserID, debugTenantID and debugJWTSecret make calendarctl sign its own token.
+	debugUserID    string
+	debugTenantID  string
+	debugJWTSecret string
+}
+
+func main() {
+	var opts options
+	flags := flag.NewFlagSet("calendarctl", flag.ExitOnError)
+	flags.StringVar(&opts.addr, "addr", envOr("CALENDAR_ADDR", "localhost:50051"),
+		"gRPC address of the calendar, $CALENDAR_ADDR")
+	flags.StringVar(&opts.apiKey, "api-key", os.Getenv("CALENDAR_API_KEY"),
+		"API key of the user to act as, $CALENDAR_API_KEY")
+	flags.StringVar(&opts.token, "token", os.Getenv("CALENDAR_TOKEN"),
+		`credential sent as is, e.g. "Bearer <jwt>", $CALENDAR_TOKEN`)
+	flags.StringVar(&opts.debugUserID, "debug-user", os.Getenv("CALENDAR_DEBUG_USER"),
+		"operators only: user ID to sign a token for, requires -debug-jwt-secret, $CALENDAR_DEBUG_USER")
+	flags.StringVar(&opts.debugTenantID, "debug-tenant", os.Getenv("CALENDAR_DEBUG_TENANT"),
+		"operators only: tenant of -debug-user, the default tenant if empty, $CALENDAR_DEBUG_TENANT")
+	flags.StringVar(&opts.debugJWTSecret, "debug-jwt-secret", os.Getenv("CALENDAR_DEBUG_JWT_SECRET"),
+		"operators only: the server's jwt_secret to sign the token with, $CALENDAR_DEBUG_JWT_SECRET")
+	flags.StringVar(&opts.output, "o", "table", "output format: table or json")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "request timeout")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	err := run(opts, flags.Args(), os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "calendarctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts options, args []string, out io.Writer) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	printer, err := newPrinter(opts.output, out)
+	if err != nil {
+		return err
+	}
+	if helpRequested(args[1:]) {
+		// The command prints its usage while parsing the flags, before it needs a connection.
+		return cmd(context.Background(), nil, args[1:], printer)
+	}
+	credential, err := newCredential(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", credential)
+
+	conn, err := grpc.DialContext(ctx, opts.addr, grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", opts.addr, err)
+	}
+	defer conn.Close()
+
+	return cmd(ctx, eventpb.NewEventServiceClient(conn), args[1:], printer)
+}
+
+// newCredential returns the value of the "authorization" metadata. The debug mode signing
+// its own token is only used when neither an API key nor a token is given.
+func newCredential(opts options) (string, error) {
+	switch {
+	case opts.apiKey != "":
+		return auth.SchemeAPIKey + " " + opts.apiKey, nil
+	case opts.token != "":
+		return opts.token, nil
+	case opts.debugUserID != "" && opts.debugJWTSecret != "":
+		identity := auth.Identity{UserID: opts.debugUserID, TenantID: opts.debugTenantID}
+		token, err := auth.NewJWT([]byte(opts.debugJWTSecret)).Issue(identity, tokenTTL)
+		if err != nil {
+			return "", err
+		}
+		return auth.SchemeBearer + " " + token, nil
+	case opts.debugUserID != "":
+		return "", errors.New("-debug-user requires -debug-jwt-secret")
+	default:
+		return "", errors.New("-api-key or -token is required")
+	}
+}
+
+// helpRequested tells whether the flags of a command ask for its usage.
+func helpRequested(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "-help", "--h", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const tableTimeLayout = "2006-01-02 15:04"
+
+type printer interface {
+	event(e *eventpb.Event) error
+	events(resp *eventpb.ListEventsResponse) error
+	deleted(id string) error
+	// writer is where commands with an output format of their own, like export, write to.
+	writer() io.Writer
+}
+
+func newPrinter(format string, out io.Writer) (printer, error) {
+	switch format {
+	case "table":
+		return tablePrinter{out: out}, nil
+	case "json":
+		return jsonPrinter{out: out}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+type tablePrinter struct {
+	out io.Writer
+}
+
+func (p tablePrinter) event(e *eventpb.Event) error {
+	return p.events(&eventpb.ListEventsResponse{Events: []*eventpb.Event{e}})
+}
+
+func (p tablePrinter) events(resp *eventpb.ListEventsResponse) error {
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tSTART\tEND\tNOTIFY")
+	for _, e := range resp.GetEvents() {
+		notify := "-"
+		if e.GetNotifyBefore() != nil {
+			notify = e.GetNotifyBefore().AsDuration().String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.GetId(), e.GetTitle(), formatTime(e.GetStartAt().AsTime()), formatTime(e.GetEndAt().AsTime()), notify)
+	}
+	return w.Flush()
+}
+
+func (p tablePrinter) deleted(id string) error {
+	_, err := fmt.Fprintf(p.out, "deleted %s\n", id)
+	return err
+}
+
+func (p tablePrinter) writer() io.Writer {
+	return p.out
+}
+
+type jsonPrinter struct {
+	out io.Writer
+}
+
+func (p jsonPrinter) event(e *eventpb.Event) error {
+	return p.print(e)
+}
+
+func (p jsonPrinter) events(resp *eventpb.ListEventsResponse) error {
+	return p.print(resp)
+}
+
+func (p jsonPrinter) deleted(id string) error {
+	return p.print(&eventpb.DeleteEventRequest{Id: id})
+}
+
+func (p jsonPrinter) writer() io.Writer {
+	return p.out
+}
+
+func (p jsonPrinter) print(m proto.Message) error {
+	data, err := protojson.MarshalOptions{Multiline: true, EmitUnpopulated: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.out, string(data))
+	return err
+}
+
+func formatTime(t time.Time) string {
+	return t.In(time.Local).Format(tableTimeLayout)
+}
@@ -2,25 +2,541 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/blob"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/breaker"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/holiday"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/sender"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoUser       = errors.New("no authenticated user")
+	ErrInvalidEvent = errors.New("invalid event")
+)
+
+// clientEventID limits the IDs of events chosen by clients to characters safe in paths and URLs.
+var clientEventID = regexp.MustCompile(`^[A-Za-z0-9_@-][A-Za-z0-9._@-]{0,199}$`)
+
+const (
+	// DefaultDeletedRetention is how long deleted events can be restored unless configured otherwise.
+	DefaultDeletedRetention = 30 * 24 * time.Hour
+	// eventRetention is how long events are kept after they end.
+	eventRetention = 365 * 24 * time.Hour
 )
 
-type App struct { // TODO
+type App struct {
+	logger           Logger
+	storage          Storage
+	auditLog         bool
+	deletedRetention time.Duration
+	feed             *feed
+	outbox           ReminderOutbox
+	senders          []sender.Backend
+	blobs            blob.Store
+	holidays         map[string]*holiday.Calendar
+	admins           map[string]bool
+	tenants          map[string]Tenant
+	tenantStorages   TenantStorages
+	tenantStorage    tenantStorage
+	retryAttempts    int
+	retryBackoff     time.Duration
+	breaker          *breaker.Breaker
+	jobs             jobs
+	tracer           *tracing.Tracer
+	now              func() time.Time
+}
+
+type Option func(a *App)
+
+// WithAuditLog makes the application also write every audit record to the logger.
+func WithAuditLog() Option {
+	return func(a *App) {
+		a.auditLog = true
+	}
+}
+
+// WithDeletedRetention sets how long deleted events are kept and can be restored.
+func WithDeletedRetention(d time.Duration) Option {
+	return func(a *App) {
+		a.deletedRetention = d
+	}
 }
 
-type Logger interface { // TODO
+// WithTracer makes background jobs of the application, such as reminders, start traces.
+// Requests are traced when their context carries a span.
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(a *App) {
+		a.tracer = tracer
+	}
 }
 
-type Storage interface { // TODO
+type Logger interface {
+	Info(msg string)
+	Error(msg string)
 }
 
-func New(logger Logger, storage Storage) *App {
-	return &App{}
+type Storage interface {
+	CreateEvent(ctx context.Context, event storage.Event) error
+	UpdateEvent(ctx context.Context, event storage.Event) error
+	DeleteEvent(ctx context.Context, id string, at time.Time) error
+	RestoreEvent(ctx context.Context, id string) error
+	PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error)
+	GetEvent(ctx context.Context, id string) (storage.Event, error)
+	ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error)
+	ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error)
+	ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error)
+	ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error)
+	ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error)
+	AddAuditRecord(ctx context.Context, record storage.AuditRecord) error
+	ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error)
+	CreateCalendar(ctx context.Context, calendar storage.Calendar) error
+	UpdateCalendar(ctx context.Context, calendar storage.Calendar) error
+	DeleteCalendar(ctx context.Context, id string) error
+	GetCalendar(ctx context.Context, id string) (storage.Calendar, error)
+	ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error)
+	SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error
+	GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error)
+	ExportEvents(ctx context.Context, userID string, from, to time.Time, fn func(event storage.Event) error) error
+	Stats(ctx context.Context, now time.Time) (storage.Stats, error)
 }
 
-func (a *App) CreateEvent(ctx context.Context, id, title string) error {
-	// TODO
+// ReminderOutbox is implemented by storages that write reminder jobs in the same transaction
+// as the changes of events. Reminders are then consumed from it instead of scanning all events.
+type ReminderOutbox interface {
+	ConsumeReminders(ctx context.Context, until time.Time, limit int, fn func(events []storage.Event) error) (int, error)
+}
+
+func New(logger Logger, storage Storage, opts ...Option) *App {
+	a := &App{
+		logger:           logger,
+		deletedRetention: DefaultDeletedRetention,
+		feed:             newFeed(),
+		holidays:         make(map[string]*holiday.Calendar),
+		admins:           make(map[string]bool),
+		tenants:          make(map[string]Tenant),
+		now:              time.Now,
+	}
+	a.tenantStorage = tenantStorage{storage: storage, app: a}
+	resilient := resilientStorage{storage: a.tenantStorage, app: a}
+	a.storage = tracedStorage{resilient}
+	if _, ok := storage.(ReminderOutbox); ok {
+		a.outbox = resilient
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// CreateEvent stores a new event in the given calendar, the default calendar of the user from ctx
+// if none is set, and returns it with the assigned ID. The event is owned by the owner of the calendar.
+func (a *App) CreateEvent(ctx context.Context, event storage.Event) (storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "app.CreateEvent")
+	defer span.End()
+
+	return a.createEvent(ctx, uuid.New().String(), event)
+}
+
+func (a *App) createEvent(ctx context.Context, id string, event storage.Event) (storage.Event, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Event{}, ErrNoUser
+	}
+
+	event.ID = id
+	// Files are attached to existing events with AddAttachment.
+	event.Attachments = nil
+	if err := a.place(ctx, userID, &event, event.CalendarID); err != nil {
+		return storage.Event{}, err
+	}
+	if err := validate(event); err != nil {
+		return storage.Event{}, err
+	}
+
+	if err := a.storage.CreateEvent(ctx, event); err != nil {
+		return storage.Event{}, err
+	}
+	event.Version = 1
+	a.changed(ctx, storage.AuditCreated, storage.Event{}, event)
+	return event, nil
+}
+
+// AbsentVersion is the version of an event PutEvent may only create, not update.
+const AbsentVersion int64 = -1
+
+// PutEvent stores the event under an ID chosen by the client, such as the name of a CalDAV resource:
+// it updates the event with the ID as UpdateEvent does or, if there is none, creates it
+// as CreateEvent does. It reports whether the event was created. If event.Version is set,
+// the event is only stored if the stored one still has that version or, for AbsentVersion,
+// if there is none; otherwise storage.ErrEventChanged is returned.
+func (a *App) PutEvent(ctx context.Context, id string, event storage.Event) (storage.Event, bool, error) {
+	ctx, span := tracing.Start(ctx, "app.PutEvent")
+	defer span.End()
+
+	if !clientEventID.MatchString(id) {
+		return storage.Event{}, false, fmt.Errorf("%w: bad ID %q", ErrInvalidEvent, id)
+	}
+	if event.Version != AbsentVersion {
+		updated, err := a.UpdateEvent(ctx, id, event)
+		switch {
+		case !errors.Is(err, storage.ErrEventNotFound):
+			return updated, false, err
+		case event.Version != 0:
+			return storage.Event{}, false, fmt.Errorf("%w: the event has been deleted", storage.ErrEventChanged)
+		}
+	}
+	// The ID may also be taken by an event the user may not see, then storage.ErrEventExists is returned.
+	created, err := a.createEvent(ctx, id, event)
+	if errors.Is(err, storage.ErrEventExists) && event.Version == AbsentVersion {
+		return storage.Event{}, false, fmt.Errorf("%w: the event has been created", storage.ErrEventChanged)
+	}
+	return created, err == nil, err
+}
+
+// GetEvent returns the event with the given ID if the user from ctx may read its calendar.
+func (a *App) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "app.GetEvent")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Event{}, ErrNoUser
+	}
+
+	event, err := a.storage.GetEvent(ctx, id)
+	if err != nil {
+		return storage.Event{}, err
+	}
+	if event.IsDeleted() {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	if err := a.checkAccess(ctx, userID, event, false); err != nil {
+		return storage.Event{}, err
+	}
+	return event, nil
+}
+
+// UpdateEvent replaces the event with the given ID if the user from ctx may write to its calendar.
+// The event stays in its calendar unless another one is set and keeps its attachments.
+// If event.Version is set, it fails with storage.ErrEventChanged unless the event still has that version.
+func (a *App) UpdateEvent(ctx context.Context, id string, event storage.Event) (storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "app.UpdateEvent")
+	defer span.End()
+
+	userID, _ := UserIDFromContext(ctx)
+	before, after, err := a.modifyEvent(ctx, id, func(before storage.Event) (storage.Event, error) {
+		if event.Version != 0 && event.Version != before.Version {
+			return storage.Event{}, storage.ErrEventChanged
+		}
+		after := event
+		after.ID = id
+		after.Attachments = before.Attachments
+		if err := a.place(ctx, userID, &after, calendarOrCurrent(after, before)); err != nil {
+			return storage.Event{}, err
+		}
+		return after, validate(after)
+	})
+	if err != nil {
+		return storage.Event{}, err
+	}
+	a.changed(ctx, storage.AuditUpdated, before, after)
+	return after, nil
+}
+
+// DeleteEvent deletes the event with the given ID if the user from ctx may write to its calendar.
+// The event can be restored during the deleted events retention period.
+func (a *App) DeleteEvent(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "app.DeleteEvent")
+	defer span.End()
+
+	return a.deleteEvent(ctx, id, 0)
+}
+
+// DeleteEventVersion deletes the event as DeleteEvent does if it still has the given version
+// and fails with storage.ErrEventChanged otherwise.
+func (a *App) DeleteEventVersion(ctx context.Context, id string, version int64) error {
+	ctx, span := tracing.Start(ctx, "app.DeleteEventVersion")
+	defer span.End()
+
+	return a.deleteEvent(ctx, id, version)
+}
+
+func (a *App) deleteEvent(ctx context.Context, id string, version int64) error {
+	before, err := a.getWritableEvent(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		err = a.storage.DeleteEvent(ctx, id, a.now().UTC())
+	} else {
+		// A delete operation of a batch checks the version together with the deletion.
+		deleted := storage.Event{ID: id, DeletedAt: a.now().UTC(), Version: version}
+		var errs []error
+		errs, err = a.storage.ApplyBatch(ctx, []storage.BatchOp{{Kind: storage.BatchDelete, Event: deleted}}, true)
+		if err == nil {
+			err = errs[0]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	a.changed(ctx, storage.AuditDeleted, before, storage.Event{})
 	return nil
-	// return a.storage.CreateEvent(storage.Event{ID: id, Title: title})
 }
 
-// TODO
+// RestoreEvent undoes the deletion of an event in a calendar the user from ctx may write to.
+func (a *App) RestoreEvent(ctx context.Context, id string) (storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "app.RestoreEvent")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Event{}, ErrNoUser
+	}
+
+	event, err := a.storage.GetEvent(ctx, id)
+	if err != nil {
+		return storage.Event{}, err
+	}
+	if !event.IsDeleted() || a.isExpired(event) {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	if err := a.checkAccess(ctx, userID, event, true); err != nil {
+		return storage.Event{}, err
+	}
+
+	if err := a.storage.RestoreEvent(ctx, id); err != nil {
+		return storage.Event{}, err
+	}
+	event.DeletedAt = time.Time{}
+	event.Version++
+	a.changed(ctx, storage.AuditRestored, storage.Event{}, event)
+	return event, nil
+}
+
+// ListDeletedEvents returns the events of the user from ctx that can still be restored.
+func (a *App) ListDeletedEvents(ctx context.Context) ([]storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "app.ListDeletedEvents")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoUser
+	}
+
+	events, err := a.storage.ListDeletedEvents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	restorable := events[:0]
+	for _, event := range events {
+		if !a.isExpired(event) {
+			restorable = append(restorable, event)
+		}
+	}
+	return restorable, nil
+}
+
+// CleanupEvents permanently removes events of all tenants that ended more than a year ago
+// and deleted events whose retention period is over, together with their attachments.
+func (a *App) CleanupEvents(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "app.CleanupEvents")
+	defer span.End()
+
+	now := a.now().UTC()
+	total := 0
+	var err error
+	for _, tenantID := range a.tenantIDs() {
+		tenantCtx := ContextWithTenantID(ctx, tenantID)
+		var purged []storage.Event
+		purged, err = a.storage.PurgeEvents(tenantCtx, now.Add(-eventRetention), now.Add(-a.deletedRetention))
+		total += len(purged)
+		if err != nil {
+			err = fmt.Errorf("tenant %q: %w", tenantID, err)
+			break
+		}
+		a.deleteAttachmentBlobs(tenantCtx, purged)
+	}
+	a.jobs.finished(&a.jobs.cleanup, a.now().UTC(), err)
+	return total, err
+}
+
+// ListDayEvents lists the events of the day in the given calendars, or in all calendars
+// the user from ctx owns if none are given. So do ListWeekEvents and ListMonthEvents.
+func (a *App) ListDayEvents(ctx context.Context, date time.Time, calendarIDs ...string) ([]storage.Event, error) {
+	return a.listEvents(ctx, date, date.AddDate(0, 0, 1), calendarIDs)
+}
+
+func (a *App) ListWeekEvents(ctx context.Context, weekStart time.Time, calendarIDs ...string) ([]storage.Event, error) {
+	return a.listEvents(ctx, weekStart, weekStart.AddDate(0, 0, 7), calendarIDs)
+}
+
+func (a *App) ListMonthEvents(
+	ctx context.Context, monthStart time.Time, calendarIDs ...string,
+) ([]storage.Event, error) {
+	return a.listEvents(ctx, monthStart, monthStart.AddDate(0, 1, 0), calendarIDs)
+}
+
+// ListEvents lists the events overlapping the period in the given calendars, or in all calendars
+// the user from ctx owns if none are given.
+func (a *App) ListEvents(ctx context.Context, from, to time.Time, calendarIDs ...string) ([]storage.Event, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("%w: end of period must be after its start", ErrInvalidEvent)
+	}
+	return a.listEvents(ctx, from, to, calendarIDs)
+}
+
+func (a *App) listEvents(ctx context.Context, from, to time.Time, calendarIDs []string) ([]storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "app.ListEvents")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoUser
+	}
+	if len(calendarIDs) == 0 {
+		return a.storage.ListEvents(ctx, userID, from, to)
+	}
+
+	// Events are indexed by owner, so list the events of every owner once and pick
+	// the ones in the requested calendars.
+	wanted := make(map[string]bool, len(calendarIDs))
+	owners := make([]string, 0, len(calendarIDs))
+	for _, id := range calendarIDs {
+		calendar, err := a.calendar(ctx, userID, id, false)
+		if err != nil {
+			return nil, err
+		}
+		if !wanted[calendar.ID] {
+			wanted[calendar.ID] = true
+			owners = appendUnique(owners, calendar.OwnerID)
+		}
+	}
+
+	events := make([]storage.Event, 0)
+	for _, owner := range owners {
+		owned, err := a.storage.ListEvents(ctx, owner, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range owned {
+			if wanted[event.Calendar()] {
+				events = append(events, event)
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].StartAt.Before(events[j].StartAt)
+	})
+	return events, nil
+}
+
+// getWritableEvent returns an event the user from ctx may change. Deleted events and events
+// in calendars the user may not read are hidden behind storage.ErrEventNotFound.
+func (a *App) getWritableEvent(ctx context.Context, id string) (storage.Event, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Event{}, ErrNoUser
+	}
+
+	event, err := a.storage.GetEvent(ctx, id)
+	if err != nil {
+		return storage.Event{}, err
+	}
+	if event.IsDeleted() {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	if err := a.checkAccess(ctx, userID, event, true); err != nil {
+		return storage.Event{}, err
+	}
+	return event, nil
+}
+
+// maxEventRetries is how many times modifyEvent starts over when the event is changed concurrently.
+const maxEventRetries = 3
+
+// modifyEvent stores the event with the given ID the user from ctx may change as change makes it
+// from the stored one, conditionally on the version read. If the event is changed in between,
+// change is applied again to the new state, so that concurrent changes are not lost.
+// It returns the event before and after the change.
+func (a *App) modifyEvent(ctx context.Context, id string,
+	change func(before storage.Event) (storage.Event, error),
+) (before, after storage.Event, err error) {
+	for attempt := 0; ; attempt++ {
+		before, err = a.getWritableEvent(ctx, id)
+		if err != nil {
+			return storage.Event{}, storage.Event{}, err
+		}
+		after, err = change(before)
+		if err != nil {
+			return storage.Event{}, storage.Event{}, err
+		}
+
+		after.Version = before.Version
+		err = a.storage.UpdateEvent(ctx, after)
+		if errors.Is(err, storage.ErrEventChanged) && attempt < maxEventRetries {
+			continue
+		}
+		if err != nil {
+			return storage.Event{}, storage.Event{}, err
+		}
+		after.Version++
+		return before, after, nil
+	}
+}
+
+// checkAccess tells whether the user may read or, if write is set, change the event.
+// Events in calendars the user may not read are hidden behind storage.ErrEventNotFound.
+func (a *App) checkAccess(ctx context.Context, userID string, event storage.Event, write bool) error {
+	_, err := a.calendar(ctx, userID, event.Calendar(), write)
+	if errors.Is(err, storage.ErrCalendarNotFound) {
+		return storage.ErrEventNotFound
+	}
+	return err
+}
+
+// calendarOrCurrent is the calendar an updated event goes to: the one set in it or,
+// if none is, the calendar the event is in.
+func calendarOrCurrent(event, current storage.Event) string {
+	if event.CalendarID != "" {
+		return event.CalendarID
+	}
+	return current.Calendar()
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func (a *App) isExpired(event storage.Event) bool {
+	return event.IsDeleted() && !a.now().Before(event.DeletedAt.Add(a.deletedRetention))
+}
+
+func validate(event storage.Event) error {
+	switch {
+	case event.Title == "":
+		return fmt.Errorf("%w: empty title", ErrInvalidEvent)
+	case event.StartAt.IsZero():
+		return fmt.Errorf("%w: empty start time", ErrInvalidEvent)
+	case !event.EndAt.After(event.StartAt):
+		return fmt.Errorf("%w: end time must be after start time", ErrInvalidEvent)
+	case event.NotifyBefore < 0:
+		return fmt.Errorf("%w: negative notification offset", ErrInvalidEvent)
+	}
+	return validateLinks(event.Links)
+}
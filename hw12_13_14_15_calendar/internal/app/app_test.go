@@ -0,0 +1,1143 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/blob"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/holiday"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/sender"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+
+type recordingLogger struct {
+	nopLogger
+	infos []string
+}
+
+func (l *recordingLogger) Info(msg string) {
+	l.infos = append(l.infos, msg)
+}
+
+var day = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func newEvent(title string, startHour int) storage.Event {
+	start := day.Add(time.Duration(startHour) * time.Hour)
+	return storage.Event{Title: title, StartAt: start, EndAt: start.Add(time.Hour)}
+}
+
+func TestApp(t *testing.T) {
+	alice := ContextWithUserID(context.Background(), "alice")
+	bob := ContextWithUserID(context.Background(), "bob")
+
+	t.Run("create and list", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		created, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		require.NotEmpty(t, created.ID)
+		require.Equal(t, "alice", created.UserID)
+
+		_, err = a.CreateEvent(alice, newEvent("retro", 24*8))
+		require.NoError(t, err)
+
+		events, err := a.ListDayEvents(alice, day)
+		require.NoError(t, err)
+		require.Equal(t, []storage.Event{created}, events)
+
+		events, err = a.ListWeekEvents(alice, day)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+
+		events, err = a.ListMonthEvents(alice, day)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		events, err = a.ListMonthEvents(bob, day)
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("no user", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		_, err := a.CreateEvent(context.Background(), newEvent("standup", 10))
+		require.ErrorIs(t, err, ErrNoUser)
+		_, err = a.ListDayEvents(context.Background(), day)
+		require.ErrorIs(t, err, ErrNoUser)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		_, err := a.CreateEvent(alice, newEvent("", 10))
+		require.ErrorIs(t, err, ErrInvalidEvent)
+
+		e := newEvent("standup", 10)
+		e.EndAt = e.StartAt
+		_, err = a.CreateEvent(alice, e)
+		require.ErrorIs(t, err, ErrInvalidEvent)
+	})
+
+	t.Run("foreign events", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		created, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+
+		_, err = a.UpdateEvent(bob, created.ID, newEvent("mine now", 10))
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+		require.ErrorIs(t, a.DeleteEvent(bob, created.ID), storage.ErrEventNotFound)
+
+		updated, err := a.UpdateEvent(alice, created.ID, newEvent("daily", 11))
+		require.NoError(t, err)
+		require.Equal(t, created.ID, updated.ID)
+		require.Equal(t, "alice", updated.UserID)
+
+		require.NoError(t, a.DeleteEvent(alice, created.ID))
+	})
+
+	t.Run("calendars", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+		carol := ContextWithUserID(context.Background(), "carol")
+
+		team, err := a.CreateCalendar(alice, storage.Calendar{
+			Name:   "Team",
+			Shares: map[string]storage.Role{"bob": storage.RoleWriter, "carol": storage.RoleReader},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "alice", team.OwnerID)
+		work, err := a.CreateCalendar(alice, storage.Calendar{Name: "Work"})
+		require.NoError(t, err)
+
+		calendars, err := a.ListCalendars(bob)
+		require.NoError(t, err)
+		require.Equal(t, []storage.Calendar{{ID: "bob", Name: DefaultCalendarName, OwnerID: "bob"}, team}, calendars)
+
+		standup, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		require.Empty(t, standup.CalendarID)
+		planning := newEvent("planning", 10)
+		planning.CalendarID = team.ID
+		planning, err = a.CreateEvent(bob, planning)
+		require.NoError(t, err, "events in different calendars may overlap")
+		require.Equal(t, "alice", planning.UserID, "events belong to the owner of the calendar")
+		_, err = a.CreateEvent(carol, planning)
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		planning.CalendarID = work.ID
+		_, err = a.CreateEvent(bob, planning)
+		require.ErrorIs(t, err, storage.ErrCalendarNotFound)
+
+		events, err := a.ListDayEvents(carol, day, team.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"planning"}, titles(events))
+		_, err = a.ListDayEvents(carol, day, team.ID, work.ID)
+		require.ErrorIs(t, err, storage.ErrCalendarNotFound)
+		events, err = a.ListDayEvents(alice, day)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		events, err = a.ListDayEvents(alice, day, "alice")
+		require.NoError(t, err)
+		require.Equal(t, []string{"standup"}, titles(events))
+
+		_, err = a.UpdateEvent(carol, planning.ID, newEvent("mine now", 10))
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		require.ErrorIs(t, a.DeleteEvent(carol, planning.ID), ErrPermissionDenied)
+		updated, err := a.UpdateEvent(bob, planning.ID, newEvent("planning", 11))
+		require.NoError(t, err)
+		require.Equal(t, team.ID, updated.CalendarID, "updated event stays in its calendar")
+		_, err = a.UpdateEvent(bob, standup.ID, newEvent("mine now", 10))
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+
+		_, err = a.UpdateCalendar(bob, team.ID, storage.Calendar{Name: "Mine"})
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		_, err = a.UpdateCalendar(alice, "alice", storage.Calendar{Name: "Personal"})
+		require.ErrorIs(t, err, ErrInvalidCalendar)
+		_, err = a.UpdateCalendar(alice, team.ID, storage.Calendar{
+			Name:   "Team",
+			Shares: map[string]storage.Role{"alice": storage.RoleReader},
+		})
+		require.ErrorIs(t, err, ErrInvalidCalendar)
+		_, err = a.UpdateCalendar(alice, team.ID, storage.Calendar{
+			Name:   "Team",
+			Shares: map[string]storage.Role{"bob": "owner"},
+		})
+		require.ErrorIs(t, err, ErrInvalidCalendar)
+		team, err = a.UpdateCalendar(alice, team.ID, storage.Calendar{
+			Name:   "Squad",
+			Shares: map[string]storage.Role{"bob": storage.RoleWriter},
+		})
+		require.NoError(t, err)
+		_, err = a.ListDayEvents(carol, day, team.ID)
+		require.ErrorIs(t, err, storage.ErrCalendarNotFound, "unshared calendar is hidden")
+
+		require.ErrorIs(t, a.DeleteCalendar(alice, team.ID), storage.ErrCalendarNotEmpty)
+		require.NoError(t, a.DeleteEvent(bob, planning.ID))
+		require.ErrorIs(t, a.DeleteCalendar(alice, team.ID), storage.ErrCalendarNotEmpty,
+			"deleted events keep their calendar to be restored into")
+		planning, err = a.RestoreEvent(alice, planning.ID)
+		require.NoError(t, err)
+		planning.CalendarID = "alice"
+		_, err = a.UpdateEvent(alice, planning.ID, planning)
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteCalendar(alice, team.ID))
+	})
+
+	t.Run("free busy", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		_, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		_, err = a.CreateEvent(alice, newEvent("review", 11))
+		require.NoError(t, err)
+		secret := newEvent("secret", 11)
+		secret.EndAt = day.Add(13 * time.Hour)
+		_, err = a.CreateEvent(bob, secret)
+		require.NoError(t, err)
+
+		freeBusy, err := a.GetFreeBusy(alice, []string{"bob", "alice", "bob", "carol"}, day, day.Add(12*time.Hour))
+		require.NoError(t, err)
+		interval := func(from, to int) Interval {
+			return Interval{StartAt: day.Add(time.Duration(from) * time.Hour), EndAt: day.Add(time.Duration(to) * time.Hour)}
+		}
+		require.Equal(t, FreeBusy{
+			Users: []UserBusy{
+				{UserID: "bob", Busy: []Interval{interval(11, 12)}},
+				{UserID: "alice", Busy: []Interval{interval(10, 12)}},
+				{UserID: "carol", Busy: []Interval{}},
+			},
+			Busy: []Interval{interval(10, 12)},
+		}, freeBusy)
+
+		_, err = a.GetFreeBusy(context.Background(), []string{"bob"}, day, day.Add(time.Hour))
+		require.ErrorIs(t, err, ErrNoUser)
+		_, err = a.GetFreeBusy(alice, nil, day, day.Add(time.Hour))
+		require.ErrorIs(t, err, ErrInvalidFreeBusy)
+		_, err = a.GetFreeBusy(alice, []string{"bob"}, day, day)
+		require.ErrorIs(t, err, ErrInvalidFreeBusy)
+		_, err = a.GetFreeBusy(alice, []string{"bob"}, day, day.Add(MaxFreeBusyRange+time.Hour))
+		require.ErrorIs(t, err, ErrInvalidFreeBusy)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		logg := &recordingLogger{}
+		a := New(logg, memorystorage.New(), WithAuditLog())
+
+		created, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		updated := newEvent("daily", 10)
+		updated.Description = "every day"
+		_, err = a.UpdateEvent(alice, created.ID, updated)
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteEvent(alice, created.ID))
+
+		history, err := a.GetEventHistory(alice, created.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+
+		require.Equal(t, storage.AuditCreated, history[0].Action)
+		require.Equal(t, "alice", history[0].Actor)
+		require.Contains(t, history[0].Changes, storage.FieldChange{Field: "title", After: "standup"})
+
+		require.Equal(t, storage.AuditUpdated, history[1].Action)
+		require.Equal(t, []storage.FieldChange{
+			{Field: "title", Before: "standup", After: "daily"},
+			{Field: "description", After: "every day"},
+		}, history[1].Changes)
+
+		require.Equal(t, storage.AuditDeleted, history[2].Action)
+		require.Equal(t, created.ID, history[2].EventID)
+		require.Contains(t, history[2].Changes, storage.FieldChange{Field: "title", Before: "daily"})
+
+		_, err = a.GetEventHistory(bob, created.ID)
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+
+		require.Len(t, logg.infos, 3)
+		require.Contains(t, logg.infos[1], `alice updated event `+created.ID)
+		require.Contains(t, logg.infos[1], `title: "standup" -> "daily"`)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		now := day
+		a := New(nopLogger{}, memorystorage.New(), WithDeletedRetention(time.Hour))
+		a.now = func() time.Time { return now }
+
+		created, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteEvent(alice, created.ID))
+
+		events, err := a.ListDayEvents(alice, day)
+		require.NoError(t, err)
+		require.Empty(t, events)
+		_, err = a.UpdateEvent(alice, created.ID, newEvent("standup", 10))
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+		require.ErrorIs(t, a.DeleteEvent(alice, created.ID), storage.ErrEventNotFound)
+
+		deleted, err := a.ListDeletedEvents(alice)
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+		_, err = a.RestoreEvent(bob, created.ID)
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+
+		restored, err := a.RestoreEvent(alice, created.ID)
+		require.NoError(t, err)
+		created.Version += 2 // deleted and restored
+		require.Equal(t, created, restored)
+
+		history, err := a.GetEventHistory(alice, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, storage.AuditRestored, history[len(history)-1].Action)
+
+		require.NoError(t, a.DeleteEvent(alice, created.ID))
+		now = now.Add(time.Hour)
+		deleted, err = a.ListDeletedEvents(alice)
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+		_, err = a.RestoreEvent(alice, created.ID)
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+	})
+
+	t.Run("conditional changes", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		absent := newEvent("standup", 10)
+		absent.Version = AbsentVersion
+		created, ok, err := a.PutEvent(alice, "standup@example.com", absent)
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, _, err = a.PutEvent(alice, "standup@example.com", absent)
+		require.ErrorIs(t, err, storage.ErrEventChanged, "the event has been created")
+
+		stale := created
+		updated, err := a.UpdateEvent(alice, created.ID, newEvent("daily", 10))
+		require.NoError(t, err)
+		stale.Title = "lost"
+		_, err = a.UpdateEvent(alice, created.ID, stale)
+		require.ErrorIs(t, err, storage.ErrEventChanged)
+		_, _, err = a.PutEvent(alice, created.ID, stale)
+		require.ErrorIs(t, err, storage.ErrEventChanged)
+		require.ErrorIs(t, a.DeleteEventVersion(alice, created.ID, stale.Version), storage.ErrEventChanged)
+
+		got, err := a.GetEvent(alice, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, updated, got)
+
+		require.NoError(t, a.DeleteEventVersion(alice, created.ID, updated.Version))
+		_, _, err = a.PutEvent(alice, created.ID, updated)
+		require.ErrorIs(t, err, storage.ErrEventChanged, "the event has been deleted")
+		history, err := a.GetEventHistory(alice, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, storage.AuditDeleted, history[len(history)-1].Action)
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		now := day
+		a := New(nopLogger{}, memorystorage.New(), WithDeletedRetention(time.Hour))
+		a.now = func() time.Time { return now }
+
+		old, err := a.CreateEvent(alice, newEvent("old", 10))
+		require.NoError(t, err)
+		deleted, err := a.CreateEvent(alice, newEvent("deleted", 12))
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteEvent(alice, deleted.ID))
+
+		now = now.Add(2 * time.Hour)
+		purged, err := a.CleanupEvents(alice)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		now = day.AddDate(1, 0, 1)
+		purged, err = a.CleanupEvents(alice)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		events, err := a.ListDayEvents(alice, old.StartAt.Truncate(24*time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("reminders clean up", func(t *testing.T) {
+		s := memorystorage.New()
+		a := New(nopLogger{}, s, WithDeletedRetention(time.Hour))
+		a.now = func() time.Time { return day }
+		deleted, err := a.CreateEvent(alice, newEvent("deleted", 12))
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteEvent(alice, deleted.ID))
+		_, err = s.GetEvent(context.Background(), deleted.ID)
+		require.NoError(t, err, "the deleted event is kept to be restored")
+
+		a.now = func() time.Time { return day.Add(2 * time.Hour) }
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.RunReminders(ctx, time.Millisecond)
+
+		require.Eventually(t, func() bool {
+			_, err := s.GetEvent(context.Background(), deleted.ID)
+			return errors.Is(err, storage.ErrEventNotFound)
+		}, time.Second, time.Millisecond, "the expired deleted event is purged without an admin call")
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		standup, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		foreign, err := a.CreateEvent(bob, newEvent("foreign", 10))
+		require.NoError(t, err)
+
+		ops := []BatchOp{
+			{Kind: storage.BatchCreate, Event: newEvent("retro", 12)},
+			{Kind: storage.BatchUpdate, ID: standup.ID, Event: newEvent("daily", 9)},
+			{Kind: storage.BatchDelete, ID: foreign.ID},
+			{Kind: storage.BatchCreate, Event: newEvent("", 14)},
+		}
+
+		results, err := a.ApplyBatch(alice, ops, true)
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		require.ErrorIs(t, results[0].Err, ErrBatchAborted)
+		require.ErrorIs(t, results[1].Err, ErrBatchAborted)
+		require.ErrorIs(t, results[2].Err, storage.ErrEventNotFound)
+		require.ErrorIs(t, results[3].Err, ErrInvalidEvent)
+		events, err := a.ListDayEvents(alice, day)
+		require.NoError(t, err)
+		require.Equal(t, []storage.Event{standup}, events)
+
+		ops = append(ops[:2], BatchOp{Kind: storage.BatchCreate, Event: newEvent("overlapping", 12)})
+		results, err = a.ApplyBatch(alice, ops, true)
+		require.NoError(t, err)
+		require.ErrorIs(t, results[0].Err, ErrBatchAborted)
+		require.ErrorIs(t, results[2].Err, storage.ErrDateBusy)
+
+		results, err = a.ApplyBatch(alice, ops, false)
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		require.Equal(t, "alice", results[0].Event.UserID)
+		require.NoError(t, results[1].Err)
+		require.Equal(t, "daily", results[1].Event.Title)
+		require.ErrorIs(t, results[2].Err, storage.ErrDateBusy)
+		events, err = a.ListDayEvents(alice, day)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		history, err := a.GetEventHistory(alice, standup.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, storage.AuditUpdated, history[1].Action)
+
+		ops = []BatchOp{
+			{Kind: storage.BatchUpdate, ID: standup.ID, Event: newEvent("sync", 9)},
+			{Kind: storage.BatchUpdate, ID: standup.ID, Event: newEvent("sync", 8)},
+			{Kind: storage.BatchDelete, ID: standup.ID},
+			{Kind: storage.BatchUpdate, ID: standup.ID, Event: newEvent("gone", 8)},
+		}
+		results, err = a.ApplyBatch(alice, ops, false)
+		require.NoError(t, err)
+		require.ErrorIs(t, results[3].Err, storage.ErrEventNotFound)
+		history, err = a.GetEventHistory(alice, standup.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 5)
+		require.Equal(t, []storage.FieldChange{{Field: "title", Before: "daily", After: "sync"}},
+			history[2].Changes)
+		require.Equal(t, []storage.FieldChange{
+			{Field: "start_at", Before: "2021-06-01T09:00:00Z", After: "2021-06-01T08:00:00Z"},
+			{Field: "end_at", Before: "2021-06-01T10:00:00Z", After: "2021-06-01T09:00:00Z"},
+		}, history[3].Changes, "the second update is audited against the first one")
+		require.Equal(t, storage.AuditDeleted, history[4].Action)
+
+		_, err = a.ApplyBatch(alice, make([]BatchOp, MaxBatchSize+1), false)
+		require.ErrorIs(t, err, ErrInvalidBatch)
+	})
+
+	t.Run("feed", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		_, err := a.Subscribe(context.Background())
+		require.ErrorIs(t, err, ErrNoUser)
+
+		ctx, cancel := context.WithCancel(alice)
+		changes, err := a.Subscribe(ctx)
+		require.NoError(t, err)
+		bobChanges, err := a.Subscribe(bob)
+		require.NoError(t, err)
+
+		created, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		_, err = a.UpdateEvent(alice, created.ID, newEvent("daily", 10))
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteEvent(alice, created.ID))
+
+		change := <-changes
+		require.Equal(t, ChangeCreated, change.Kind)
+		require.Equal(t, created, change.Event)
+		change = <-changes
+		require.Equal(t, ChangeUpdated, change.Kind)
+		require.Equal(t, "daily", change.Event.Title)
+		change = <-changes
+		require.Equal(t, ChangeDeleted, change.Kind)
+		require.True(t, change.Event.IsDeleted())
+		require.Empty(t, bobChanges)
+
+		cancel()
+		require.Eventually(t, func() bool {
+			_, ok := <-changes
+			return !ok
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("slow subscriber", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+
+		changes, err := a.Subscribe(alice)
+		require.NoError(t, err)
+		for i := 0; i <= feedBuffer; i++ {
+			_, err := a.CreateEvent(alice, newEvent("standup", i))
+			require.NoError(t, err)
+		}
+
+		for i := 0; i < feedBuffer; i++ {
+			<-changes
+		}
+		_, ok := <-changes
+		require.False(t, ok)
+	})
+
+	t.Run("reminders", func(t *testing.T) {
+		var mu sync.Mutex
+		now := day.Add(9*time.Hour + 30*time.Minute)
+		a := New(nopLogger{}, memorystorage.New())
+		a.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+
+		event := newEvent("standup", 10)
+		event.NotifyBefore = 15 * time.Minute
+		created, err := a.CreateEvent(alice, event)
+		require.NoError(t, err)
+		_, err = a.CreateEvent(alice, newEvent("silent", 12))
+		require.NoError(t, err)
+
+		changes, err := a.Subscribe(alice)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.RunReminders(ctx, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, changes)
+
+		mu.Lock()
+		now = now.Add(20 * time.Minute)
+		mu.Unlock()
+
+		change := <-changes
+		require.Equal(t, ChangeReminder, change.Kind)
+		require.Equal(t, created, change.Event)
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, changes, "a reminder is sent once")
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		store, err := blob.NewLocal(t.TempDir())
+		require.NoError(t, err)
+		a := New(nopLogger{}, memorystorage.New(), WithBlobStore(store))
+		team, err := a.CreateCalendar(alice, storage.Calendar{
+			Name:   "Team",
+			Shares: map[string]storage.Role{"bob": storage.RoleReader},
+		})
+		require.NoError(t, err)
+		event := newEvent("planning", 10)
+		event.CalendarID = team.ID
+		event, err = a.CreateEvent(alice, event)
+		require.NoError(t, err)
+
+		attachment, err := a.AddAttachment(alice, event.ID, "notes.txt", "", strings.NewReader("hello"))
+		require.NoError(t, err)
+		require.Equal(t, "application/octet-stream", attachment.ContentType)
+		require.EqualValues(t, 5, attachment.Size)
+		_, err = a.AddAttachment(bob, event.ID, "notes.txt", "", strings.NewReader("hi"))
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		_, err = a.AddAttachment(alice, event.ID, "../notes.txt", "", strings.NewReader("hi"))
+		require.ErrorIs(t, err, ErrInvalidAttachment)
+		_, err = a.AddAttachment(alice, event.ID, "big.bin", "", strings.NewReader(strings.Repeat("x", MaxAttachmentSize+1)))
+		require.ErrorIs(t, err, ErrAttachmentTooLarge)
+
+		event.Title = "renamed"
+		event.Attachments = nil
+		event.Version = 0
+		updated, err := a.UpdateEvent(alice, event.ID, event)
+		require.NoError(t, err)
+		require.Equal(t, []storage.Attachment{attachment}, updated.Attachments, "updates keep attachments")
+
+		got, content, err := a.GetAttachment(bob, event.ID, attachment.ID)
+		require.NoError(t, err, "readers of the calendar get attachments")
+		data, err := io.ReadAll(content)
+		require.NoError(t, err)
+		require.NoError(t, content.Close())
+		require.Equal(t, attachment, got)
+		require.Equal(t, "hello", string(data))
+
+		require.ErrorIs(t, a.DeleteAttachment(bob, event.ID, attachment.ID), ErrPermissionDenied)
+		require.NoError(t, a.DeleteAttachment(alice, event.ID, attachment.ID))
+		require.ErrorIs(t, a.DeleteAttachment(alice, event.ID, attachment.ID), ErrAttachmentNotFound)
+		_, _, err = a.GetAttachment(alice, event.ID, attachment.ID)
+		require.ErrorIs(t, err, ErrAttachmentNotFound)
+
+		records, err := a.GetEventHistory(alice, event.ID)
+		require.NoError(t, err)
+		require.Equal(t, []storage.FieldChange{{Field: "attachments", After: "notes.txt"}}, records[1].Changes)
+
+		synced, _, err := a.PutEvent(alice, "standup@example.com", newEvent("synced", 14))
+		require.NoError(t, err)
+		attachment, err = a.AddAttachment(alice, synced.ID, "notes.txt", "", strings.NewReader("hello"))
+		require.NoError(t, err, "events created over CalDAV may have IDs blob keys cannot hold")
+		_, content, err = a.GetAttachment(alice, synced.ID, attachment.ID)
+		require.NoError(t, err)
+		require.NoError(t, content.Close())
+		require.NoError(t, a.DeleteAttachment(alice, synced.ID, attachment.ID))
+	})
+
+	t.Run("concurrent attachments", func(t *testing.T) {
+		store, err := blob.NewLocal(t.TempDir())
+		require.NoError(t, err)
+		a := New(nopLogger{}, memorystorage.New(), WithBlobStore(store))
+		event, err := a.CreateEvent(alice, newEvent("planning", 10))
+		require.NoError(t, err)
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			added []storage.Attachment
+		)
+		for i := 0; i < MaxAttachments+5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				attachment, err := a.AddAttachment(alice, event.ID, fmt.Sprintf("%d.txt", i), "", strings.NewReader("hi"))
+				if errors.Is(err, ErrInvalidAttachment) || errors.Is(err, storage.ErrEventChanged) {
+					return
+				}
+				require.NoError(t, err)
+				mu.Lock()
+				added = append(added, attachment)
+				mu.Unlock()
+			}(i)
+		}
+		wg.Wait()
+
+		got, err := a.GetEvent(alice, event.ID)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(got.Attachments), MaxAttachments)
+		require.ElementsMatch(t, added, got.Attachments, "no added attachment is lost")
+	})
+
+	t.Run("purged attachments", func(t *testing.T) {
+		store, err := blob.NewLocal(t.TempDir())
+		require.NoError(t, err)
+		now := day
+		a := New(nopLogger{}, memorystorage.New(), WithBlobStore(store), WithDeletedRetention(time.Hour))
+		a.now = func() time.Time { return now }
+		event, err := a.CreateEvent(alice, newEvent("planning", 10))
+		require.NoError(t, err)
+		attachment, err := a.AddAttachment(alice, event.ID, "notes.txt", "", strings.NewReader("hello"))
+		require.NoError(t, err)
+		require.NoError(t, a.DeleteEvent(alice, event.ID))
+
+		now = now.Add(2 * time.Hour)
+		purged, err := a.CleanupEvents(alice)
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+		_, err = store.Get(alice, attachmentKey(alice, event.ID, attachment.ID))
+		require.ErrorIs(t, err, blob.ErrNotFound)
+	})
+
+	t.Run("reminders from outbox", func(t *testing.T) {
+		now := day.Add(9 * time.Hour)
+		due := newEvent("standup", 10)
+		due.ID, due.UserID = "due", "alice"
+		outbox := &fakeOutbox{Storage: memorystorage.New(), jobs: []storage.Event{due}, failures: 1}
+		a := New(nopLogger{}, outbox)
+		a.now = func() time.Time { return now }
+
+		changes, err := a.Subscribe(alice)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.RunReminders(ctx, time.Millisecond)
+
+		change := <-changes
+		require.Equal(t, ChangeReminder, change.Kind)
+		require.Equal(t, due, change.Event, "a reminder due before the start is kept until sent")
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, changes, "a reminder is sent once")
+	})
+
+	t.Run("reminder senders", func(t *testing.T) {
+		due := newEvent("standup", 10)
+		due.ID, due.UserID = "due", "alice"
+		outbox := &fakeOutbox{Storage: memorystorage.New(), jobs: []storage.Event{due}}
+		delivered := make(chan sender.Notification, 1)
+		logger := &errorLogger{}
+		a := New(logger, outbox, WithSenders(
+			backendFunc(func(context.Context, sender.Notification) error { return sender.ErrNoRecipient }),
+			backendFunc(func(_ context.Context, n sender.Notification) error {
+				delivered <- n
+				return nil
+			}),
+		))
+		a.now = func() time.Time { return day.Add(9 * time.Hour) }
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.RunReminders(ctx, time.Millisecond)
+
+		require.Equal(t, sender.Notification{EventID: "due", Title: "standup", StartAt: due.StartAt, UserID: "alice"},
+			<-delivered)
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, delivered, "a reminder is sent once")
+		require.Empty(t, logger.errors(), "users without a recipient are skipped")
+	})
+
+	t.Run("reminders continue traces", func(t *testing.T) {
+		traces := make(chan tracing.SpanContext, 1)
+		a := New(nopLogger{}, memorystorage.New(), WithTracer(tracing.New(nil)), WithSenders(
+			backendFunc(func(ctx context.Context, _ sender.Notification) error {
+				traces <- tracing.SpanContextFromContext(ctx)
+				return nil
+			}),
+		))
+		request, span := tracing.New(nil).Start(alice, "request")
+		event := newEvent("standup", 10)
+		event.NotifyBefore = time.Hour
+		_, err := a.CreateEvent(request, event)
+		require.NoError(t, err)
+		span.End()
+
+		require.NoError(t, a.remind(context.Background(), day.Add(8*time.Hour), day.Add(10*time.Hour)))
+		require.Equal(t, span.Context().TraceID, (<-traces).TraceID, "the reminder continues the trace of the creation")
+	})
+
+	t.Run("failed reminder is sent again", func(t *testing.T) {
+		due := newEvent("standup", 10)
+		due.ID, due.UserID = "due", "alice"
+		outbox := &fakeOutbox{Storage: memorystorage.New(), jobs: []storage.Event{due}}
+		attempts := make(chan sender.Notification, 2)
+		dead := make(chan sender.Notification, 2)
+		a := New(&errorLogger{}, outbox, WithSenders(
+			backendFunc(func(_ context.Context, n sender.Notification) error {
+				attempts <- n
+				if len(attempts) == 1 {
+					return errors.New("connection refused")
+				}
+				return nil
+			}),
+			backendFunc(func(_ context.Context, n sender.Notification) error {
+				dead <- n
+				return fmt.Errorf("gone: %w", sender.ErrDeadLettered)
+			}),
+		))
+		a.now = func() time.Time { return day.Add(9 * time.Hour) }
+
+		changes, err := a.Subscribe(alice)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.RunReminders(ctx, time.Millisecond)
+
+		change := <-changes
+		require.Equal(t, due, change.Event, "the reminder is published once delivered")
+		require.Len(t, attempts, 2, "the failed notification is sent again")
+		require.Len(t, dead, 2, "the reminder is sent again to all backends")
+		outbox.mu.Lock()
+		require.Empty(t, outbox.jobs, "dead-lettered notifications need no redelivery")
+		outbox.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		require.Len(t, attempts, 2, "a delivered reminder is not sent again")
+		require.Empty(t, changes)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New())
+		now := day.Add(9 * time.Hour)
+		a.now = func() time.Time { return now }
+
+		_, err := a.Subscribe(alice)
+		require.NoError(t, err)
+		_, err = a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+
+		stats, err := a.Stats(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, stats.Storage.Events)
+		require.Equal(t, 1, stats.Subscribers)
+		require.Equal(t, 1, stats.QueuedChanges, "the creation waits for the subscriber")
+		require.True(t, stats.Reminders.LastRun.IsZero())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			a.RunReminders(ctx, time.Millisecond)
+		}()
+		require.Eventually(t, func() bool {
+			stats, err := a.Stats(context.Background())
+			return err == nil && !stats.Reminders.LastRun.IsZero()
+		}, time.Second, time.Millisecond)
+		cancel()
+		<-done
+
+		stats, err = a.Stats(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, JobStatus{LastRun: now}, stats.Reminders)
+		require.Zero(t, stats.ReminderLag)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		a := New(nopLogger{}, memorystorage.New(), WithAdmins("bob"))
+
+		_, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		_, err = a.CreateEvent(bob, newEvent("review", 11))
+		require.NoError(t, err)
+
+		export := func(ctx context.Context, allUsers bool) ([]string, error) {
+			var exported []string
+			err := a.ExportEvents(ctx, allUsers, day, day.Add(24*time.Hour), func(event storage.Event) error {
+				exported = append(exported, event.Title)
+				return nil
+			})
+			sort.Strings(exported)
+			return exported, err
+		}
+		exported, err := export(alice, false)
+		require.NoError(t, err)
+		require.Equal(t, []string{"standup"}, exported)
+		_, err = export(alice, true)
+		require.ErrorIs(t, err, ErrPermissionDenied)
+		exported, err = export(bob, true)
+		require.NoError(t, err)
+		require.Equal(t, []string{"review", "standup"}, exported)
+		_, err = export(context.Background(), false)
+		require.ErrorIs(t, err, ErrNoUser)
+		err = a.ExportEvents(alice, false, day, day, func(storage.Event) error { return nil })
+		require.ErrorIs(t, err, ErrInvalidExport)
+	})
+
+	t.Run("working hours", func(t *testing.T) {
+		holidays := holiday.New("de", []holiday.Holiday{{Date: day.AddDate(0, 0, 1), Name: "Day Off"}})
+		a := New(nopLogger{}, memorystorage.New(), WithHolidays(holidays))
+
+		_, err := a.GetWorkingHours(alice)
+		require.ErrorIs(t, err, storage.ErrWorkingHoursNotFound)
+
+		// 9:00-17:00 in Berlin is 7:00-15:00 UTC in summer.
+		hours := storage.WorkingHours{
+			TimeZone: "Europe/Berlin",
+			Days:     []time.Weekday{time.Friday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Monday},
+			Start:    9 * time.Hour,
+			End:      17 * time.Hour,
+			Holidays: "de",
+		}
+		saved, err := a.SetWorkingHours(alice, hours)
+		require.NoError(t, err)
+		require.Equal(t, "alice", saved.UserID)
+		require.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, saved.Days)
+		got, err := a.GetWorkingHours(alice)
+		require.NoError(t, err)
+		require.Equal(t, saved, got)
+
+		for name, change := range map[string]func(h *storage.WorkingHours){
+			"time zone": func(h *storage.WorkingHours) { h.TimeZone = "Mars/Olympus" },
+			"holidays":  func(h *storage.WorkingHours) { h.Holidays = "fr" },
+			"reversed":  func(h *storage.WorkingHours) { h.End = h.Start },
+			"weekday":   func(h *storage.WorkingHours) { h.Days = []time.Weekday{7} },
+		} {
+			invalid := hours
+			change(&invalid)
+			_, err := a.SetWorkingHours(alice, invalid)
+			require.ErrorIs(t, err, ErrInvalidWorkingHours, name)
+		}
+
+		_, err = a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err)
+		_, err = a.CreateEvent(bob, newEvent("lunch", 12))
+		require.NoError(t, err)
+
+		interval := func(from, to int) Interval {
+			return Interval{StartAt: day.Add(time.Duration(from) * time.Hour), EndAt: day.Add(time.Duration(to) * time.Hour)}
+		}
+		slots, err := a.FindFreeSlots(alice, []string{"alice", "bob"}, day, day.Add(48*time.Hour), time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, []Interval{interval(7, 10), interval(11, 12), interval(13, 15)}, slots,
+			"bob without working hours is available any time and alice is on holiday the next day")
+		slots, err = a.FindFreeSlots(alice, []string{"alice", "bob"}, day, day.Add(48*time.Hour), 90*time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []Interval{interval(7, 10), interval(13, 15)}, slots)
+		_, err = a.FindFreeSlots(alice, []string{"alice"}, day, day.Add(time.Hour), 0)
+		require.ErrorIs(t, err, ErrInvalidFreeBusy)
+
+		warnings, err := a.WorkingHoursWarnings(alice, storage.Event{UserID: "alice", StartAt: day.Add(14 * time.Hour),
+			EndAt: day.Add(16 * time.Hour)})
+		require.NoError(t, err)
+		require.Equal(t, []string{"the event is outside the working hours of alice"}, warnings)
+		warnings, err = a.WorkingHoursWarnings(alice, storage.Event{UserID: "alice", StartAt: day.Add(34 * time.Hour),
+			EndAt: day.Add(35 * time.Hour)})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			`the event is on the holiday "Day Off" of alice`,
+			"the event is outside the working hours of alice",
+		}, warnings)
+		warnings, err = a.WorkingHoursWarnings(alice, storage.Event{UserID: "alice", StartAt: day.Add(10 * time.Hour),
+			EndAt: day.Add(11 * time.Hour)})
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+		warnings, err = a.WorkingHoursWarnings(bob, storage.Event{UserID: "bob", StartAt: day.Add(22 * time.Hour),
+			EndAt: day.Add(23 * time.Hour)})
+		require.NoError(t, err)
+		require.Empty(t, warnings, "users without working hours may work any time")
+	})
+
+	t.Run("retries and circuit breaker", func(t *testing.T) {
+		s := &flakyStorage{Storage: memorystorage.New()}
+		a := New(nopLogger{}, s, WithRetries(3, time.Millisecond), WithCircuitBreaker(2, 20*time.Millisecond))
+
+		s.failures = 2
+		created, err := a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err, "transient failures are retried")
+		require.Equal(t, 3, s.calls)
+
+		_, err = a.CreateEvent(alice, newEvent("retro", 10))
+		require.ErrorIs(t, err, storage.ErrDateBusy, "other errors are not retried")
+		require.Equal(t, 4, s.calls)
+
+		for i := 0; i < 2; i++ {
+			require.NoError(t, a.Ready())
+			s.failures = 3
+			_, err = a.ListDayEvents(alice, day)
+			require.ErrorIs(t, err, ErrUnavailable)
+		}
+		require.Equal(t, 10, s.calls)
+		require.ErrorIs(t, a.Ready(), ErrUnavailable)
+
+		_, err = a.ListDayEvents(alice, day)
+		require.ErrorIs(t, err, ErrUnavailable)
+		require.Equal(t, 10, s.calls, "calls fail fast while the breaker is open")
+
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, a.Ready(), "half-open replicas get requests to probe the storage")
+		events, err := a.ListDayEvents(alice, day)
+		require.NoError(t, err)
+		require.Equal(t, []storage.Event{created}, events)
+		require.NoError(t, a.Ready())
+
+		ctx, cancel := context.WithCancel(alice)
+		cancel()
+		s.failures = 3
+		_, err = a.ListDayEvents(ctx, day)
+		require.ErrorIs(t, err, ErrUnavailable)
+		require.Equal(t, 12, s.calls, "calls are not retried once the context is done")
+		s.failures = 0
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		var mu sync.Mutex
+		now := day.Add(9 * time.Hour)
+		s := memorystorage.New()
+		a := New(nopLogger{}, s, WithAdmins("acme/alice"), WithTenants(TenantFunc(func(id string) (Storage, error) {
+			return s.Tenant(id)
+		}), map[string]Tenant{"acme": {MaxEvents: 2}}))
+		a.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+		acmeAlice := ContextWithTenantID(alice, "acme")
+
+		changes, err := a.Subscribe(alice)
+		require.NoError(t, err)
+		acmeChanges, err := a.Subscribe(acmeAlice)
+		require.NoError(t, err)
+
+		event := newEvent("standup", 10)
+		event.NotifyBefore = 30 * time.Minute
+		created, err := a.CreateEvent(acmeAlice, event)
+		require.NoError(t, err)
+		require.Equal(t, ChangeCreated, (<-acmeChanges).Kind)
+		require.Empty(t, changes, "changes are only sent to users of the tenant")
+		_, err = a.CreateEvent(alice, newEvent("standup", 10))
+		require.NoError(t, err, "the time is only taken in the other tenant")
+		<-changes
+
+		_, err = a.UpdateEvent(alice, created.ID, newEvent("hijacked", 11))
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+		events, err := a.ListDayEvents(acmeAlice, day)
+		require.NoError(t, err)
+		require.Equal(t, []storage.Event{created}, events)
+
+		_, err = a.CreateEvent(ContextWithTenantID(alice, "globex"), newEvent("standup", 10))
+		require.ErrorIs(t, err, ErrUnknownTenant)
+		_, err = a.Subscribe(ContextWithTenantID(alice, "globex"))
+		require.ErrorIs(t, err, ErrUnknownTenant)
+
+		_, err = a.CreateEvent(acmeAlice, newEvent("retro", 12))
+		require.NoError(t, err)
+		<-acmeChanges
+		_, err = a.CreateEvent(acmeAlice, newEvent("planning", 14))
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+		_, err = a.ApplyBatch(acmeAlice, []BatchOp{{Kind: storage.BatchCreate, Event: newEvent("planning", 14)}}, false)
+		require.ErrorIs(t, err, ErrQuotaExceeded)
+		_, err = a.CreateEvent(alice, newEvent("planning", 14))
+		require.NoError(t, err, "the quota is per tenant")
+		<-changes
+
+		err = a.ExportEvents(alice, true, day, day.Add(24*time.Hour), func(storage.Event) error { return nil })
+		require.ErrorIs(t, err, ErrPermissionDenied, "admins are admins of their tenant only")
+		exported := 0
+		err = a.ExportEvents(acmeAlice, true, day, day.Add(24*time.Hour), func(storage.Event) error {
+			exported++
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, exported)
+
+		stats, err := a.Stats(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, storage.Stats{Events: 4, Reminders: 1}, stats.Storage)
+		require.Equal(t, map[string]storage.Stats{"": {Events: 2}, "acme": {Events: 2, Reminders: 1}}, stats.Tenants)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go a.RunReminders(ctx, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		now = now.Add(45 * time.Minute)
+		mu.Unlock()
+		change := <-acmeChanges
+		require.Equal(t, ChangeReminder, change.Kind, "reminders are sent for every tenant")
+		require.Equal(t, created, change.Event)
+		require.Empty(t, changes)
+	})
+}
+
+var errTransient = errors.New("connection refused")
+
+// flakyStorage is a storage whose event calls fail transiently the given number of times.
+type flakyStorage struct {
+	*memorystorage.Storage
+	mu       sync.Mutex
+	calls    int
+	failures int
+}
+
+func (s *flakyStorage) fail() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return errTransient
+	}
+	return nil
+}
+
+func (s *flakyStorage) CreateEvent(ctx context.Context, event storage.Event) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Storage.CreateEvent(ctx, event)
+}
+
+func (s *flakyStorage) ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListEvents(ctx, userID, from, to)
+}
+
+func (s *flakyStorage) IsTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+type backendFunc func(ctx context.Context, n sender.Notification) error
+
+func (f backendFunc) Send(ctx context.Context, n sender.Notification) error {
+	return f(ctx, n)
+}
+
+type errorLogger struct {
+	nopLogger
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *errorLogger) Error(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, msg)
+}
+
+func (l *errorLogger) errors() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+// fakeOutbox is a storage with a reminder outbox that fails the first few consumers.
+type fakeOutbox struct {
+	*memorystorage.Storage
+	mu       sync.Mutex
+	jobs     []storage.Event
+	failures int
+}
+
+func (o *fakeOutbox) ConsumeReminders(_ context.Context, _ time.Time, limit int,
+	fn func(events []storage.Event) error,
+) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.failures > 0 {
+		o.failures--
+		return 0, errors.New("unavailable")
+	}
+	jobs := o.jobs
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+	if err := fn(jobs); err != nil {
+		return 0, err
+	}
+	o.jobs = o.jobs[len(jobs):]
+	return len(jobs), nil
+}
+
+func titles(events []storage.Event) []string {
+	titles := make([]string, 0, len(events))
+	for _, event := range events {
+		titles = append(titles, event.Title)
+	}
+	return titles
+}
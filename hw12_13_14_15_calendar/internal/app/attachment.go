@@ -0,0 +1,262 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/blob"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/markdown"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxAttachmentSize limits the size of one attached file.
+	MaxAttachmentSize = 1 << 20
+	// MaxAttachments limits the number of files attached to an event.
+	MaxAttachments = 10
+	// MaxLinks limits the number of links of an event.
+	MaxLinks = 20
+	// maxAttachmentName limits the length of file names of attachments and titles of links.
+	maxAttachmentName = 255
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrInvalidAttachment  = errors.New("invalid attachment")
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	// ErrAttachmentsDisabled is returned if no blob store is configured.
+	ErrAttachmentsDisabled = errors.New("attachments are disabled")
+)
+
+// WithBlobStore keeps the contents of attachments in store; attachments are disabled without it.
+func WithBlobStore(store blob.Store) Option {
+	return func(a *App) {
+		a.blobs = store
+	}
+}
+
+// AddAttachment attaches the file read from r to the event if the user from ctx may change it.
+// The file must not be larger than MaxAttachmentSize.
+func (a *App) AddAttachment(
+	ctx context.Context, eventID, name, contentType string, r io.Reader,
+) (storage.Attachment, error) {
+	ctx, span := tracing.Start(ctx, "app.AddAttachment")
+	defer span.End()
+
+	if a.blobs == nil {
+		return storage.Attachment{}, ErrAttachmentsDisabled
+	}
+	current, err := a.getWritableEvent(ctx, eventID)
+	if err != nil {
+		return storage.Attachment{}, err
+	}
+	if err := checkAttachmentCount(current); err != nil {
+		return storage.Attachment{}, err
+	}
+
+	attachment := storage.Attachment{
+		ID:          uuid.New().String(),
+		Name:        name,
+		ContentType: contentType,
+	}
+	if attachment.ContentType == "" {
+		attachment.ContentType = "application/octet-stream"
+	}
+	if err := validateAttachment(attachment); err != nil {
+		return storage.Attachment{}, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxAttachmentSize+1))
+	if err != nil {
+		return storage.Attachment{}, err
+	}
+	if len(data) > MaxAttachmentSize {
+		return storage.Attachment{}, fmt.Errorf("%w: more than %d bytes", ErrAttachmentTooLarge, MaxAttachmentSize)
+	}
+	attachment.Size = int64(len(data))
+
+	key := attachmentKey(ctx, eventID, attachment.ID)
+	if err := a.blobs.Put(ctx, key, bytes.NewReader(data)); err != nil {
+		return storage.Attachment{}, err
+	}
+	// The limit is checked again against the event the attachment is added to, which
+	// concurrent uploads may have changed since.
+	before, after, err := a.modifyEvent(ctx, eventID, func(before storage.Event) (storage.Event, error) {
+		if err := checkAttachmentCount(before); err != nil {
+			return storage.Event{}, err
+		}
+		after := before
+		after.Attachments = append(append(make([]storage.Attachment, 0, len(before.Attachments)+1),
+			before.Attachments...), attachment)
+		return after, nil
+	})
+	if err != nil {
+		a.deleteBlob(ctx, key)
+		return storage.Attachment{}, err
+	}
+	a.changed(ctx, storage.AuditUpdated, before, after)
+	return attachment, nil
+}
+
+// GetAttachment returns an attachment of an event the user from ctx may read together with
+// its content, which the caller must close.
+func (a *App) GetAttachment(ctx context.Context, eventID, id string) (storage.Attachment, io.ReadCloser, error) {
+	ctx, span := tracing.Start(ctx, "app.GetAttachment")
+	defer span.End()
+
+	if a.blobs == nil {
+		return storage.Attachment{}, nil, ErrAttachmentsDisabled
+	}
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Attachment{}, nil, ErrNoUser
+	}
+	event, err := a.storage.GetEvent(ctx, eventID)
+	if err != nil {
+		return storage.Attachment{}, nil, err
+	}
+	if event.IsDeleted() {
+		return storage.Attachment{}, nil, storage.ErrEventNotFound
+	}
+	if err := a.checkAccess(ctx, userID, event, false); err != nil {
+		return storage.Attachment{}, nil, err
+	}
+
+	i := findAttachment(event.Attachments, id)
+	if i < 0 {
+		return storage.Attachment{}, nil, ErrAttachmentNotFound
+	}
+	content, err := a.blobs.Get(ctx, attachmentKey(ctx, eventID, id))
+	if errors.Is(err, blob.ErrNotFound) {
+		return storage.Attachment{}, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return storage.Attachment{}, nil, err
+	}
+	return event.Attachments[i], content, nil
+}
+
+// DeleteAttachment removes an attachment from an event the user from ctx may change.
+func (a *App) DeleteAttachment(ctx context.Context, eventID, id string) error {
+	ctx, span := tracing.Start(ctx, "app.DeleteAttachment")
+	defer span.End()
+
+	if a.blobs == nil {
+		return ErrAttachmentsDisabled
+	}
+	before, after, err := a.modifyEvent(ctx, eventID, func(before storage.Event) (storage.Event, error) {
+		i := findAttachment(before.Attachments, id)
+		if i < 0 {
+			return storage.Event{}, ErrAttachmentNotFound
+		}
+		after := before
+		after.Attachments = append(append(make([]storage.Attachment, 0, len(before.Attachments)-1),
+			before.Attachments[:i]...), before.Attachments[i+1:]...)
+		return after, nil
+	})
+	if err != nil {
+		return err
+	}
+	a.deleteBlob(ctx, attachmentKey(ctx, eventID, id))
+	a.changed(ctx, storage.AuditUpdated, before, after)
+	return nil
+}
+
+// deleteBlob removes the content of an attachment that is no longer referenced.
+// A failure only leaves an orphaned blob behind, so it is logged.
+func (a *App) deleteBlob(ctx context.Context, key string) {
+	if err := a.blobs.Delete(ctx, key); err != nil {
+		a.logger.Error(fmt.Sprintf("failed to delete blob %s: %s", key, err))
+	}
+}
+
+// deleteAttachmentBlobs removes the contents of the attachments of the events of the tenant
+// from ctx that have been purged.
+func (a *App) deleteAttachmentBlobs(ctx context.Context, events []storage.Event) {
+	if a.blobs == nil {
+		return
+	}
+	for _, event := range events {
+		for _, attachment := range event.Attachments {
+			a.deleteBlob(ctx, attachmentKey(ctx, event.ID, attachment.ID))
+		}
+	}
+}
+
+// DescriptionHTML renders the Markdown description of the event to sanitized HTML.
+func DescriptionHTML(event storage.Event) string {
+	return markdown.ToHTML(event.Description)
+}
+
+// blobSafeID matches the event IDs that are valid segments of blob keys as they are,
+// such as the generated ones.
+var blobSafeID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// attachmentKey is where the attachment is kept in the blob store; blobs of tenants other
+// than the default one are kept under tenants/<tenant ID>. Events with IDs chosen by clients
+// that blob keys cannot hold, such as "abc@example.com", keep their blobs under
+// hashed/<SHA-256 of the ID>; the extra segment keeps the keys apart from the others.
+func attachmentKey(ctx context.Context, eventID, id string) string {
+	if !blobSafeID.MatchString(eventID) {
+		sum := sha256.Sum256([]byte(eventID))
+		eventID = "hashed/" + hex.EncodeToString(sum[:])
+	}
+	if tenantID := TenantIDFromContext(ctx); tenantID != "" {
+		return "tenants/" + tenantID + "/" + eventID + "/" + id
+	}
+	return eventID + "/" + id
+}
+
+func findAttachment(attachments []storage.Attachment, id string) int {
+	for i, attachment := range attachments {
+		if attachment.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkAttachmentCount fails if no more files may be attached to the event.
+func checkAttachmentCount(event storage.Event) error {
+	if len(event.Attachments) >= MaxAttachments {
+		return fmt.Errorf("%w: more than %d attachments", ErrInvalidAttachment, MaxAttachments)
+	}
+	return nil
+}
+
+func validateAttachment(attachment storage.Attachment) error {
+	switch {
+	case strings.TrimSpace(attachment.Name) == "":
+		return fmt.Errorf("%w: empty name", ErrInvalidAttachment)
+	case len(attachment.Name) > maxAttachmentName || strings.ContainsAny(attachment.Name, "/\\\x00\r\n"):
+		return fmt.Errorf("%w: bad name", ErrInvalidAttachment)
+	}
+	if _, _, err := mime.ParseMediaType(attachment.ContentType); err != nil {
+		return fmt.Errorf("%w: bad content type", ErrInvalidAttachment)
+	}
+	return nil
+}
+
+func validateLinks(links []storage.Link) error {
+	if len(links) > MaxLinks {
+		return fmt.Errorf("%w: more than %d links", ErrInvalidEvent, MaxLinks)
+	}
+	for _, link := range links {
+		if len(link.Title) > maxAttachmentName {
+			return fmt.Errorf("%w: link title is too long", ErrInvalidEvent)
+		}
+		if !markdown.SafeURL(link.URL) {
+			return fmt.Errorf("%w: link %q is not an http, https or mailto URL", ErrInvalidEvent, link.URL)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+// GetEventHistory returns the audit trail of an event owned by the user from ctx,
+// including events that have already been deleted.
+func (a *App) GetEventHistory(ctx context.Context, id string) ([]storage.AuditRecord, error) {
+	ctx, span := tracing.Start(ctx, "app.GetEventHistory")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoUser
+	}
+
+	records, err := a.storage.ListAuditRecords(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || records[0].UserID != userID {
+		return nil, storage.ErrEventNotFound
+	}
+	return records, nil
+}
+
+// audit stores the change of an event from before to after. The change itself has
+// already been applied, so a failure is only logged.
+func (a *App) audit(ctx context.Context, action storage.AuditAction, before, after storage.Event) {
+	record := storage.AuditRecord{
+		EventID: after.ID,
+		UserID:  after.UserID,
+		Action:  action,
+		At:      a.now().UTC(),
+		Changes: diff(before, after),
+	}
+	if action == storage.AuditDeleted {
+		record.EventID, record.UserID = before.ID, before.UserID
+	}
+	record.Actor, _ = UserIDFromContext(ctx)
+
+	if err := a.storage.AddAuditRecord(ctx, record); err != nil {
+		a.logger.Error(fmt.Sprintf("failed to write audit record for event %s: %s", record.EventID, err))
+	}
+	if a.auditLog {
+		a.logger.Info(formatAuditRecord(record))
+	}
+}
+
+func diff(before, after storage.Event) []storage.FieldChange {
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"title", before.Title, after.Title},
+		{"start_at", formatTime(before.StartAt), formatTime(after.StartAt)},
+		{"end_at", formatTime(before.EndAt), formatTime(after.EndAt)},
+		{"description", before.Description, after.Description},
+		{"notify_before", formatDuration(before.NotifyBefore), formatDuration(after.NotifyBefore)},
+		{"links", formatLinks(before.Links), formatLinks(after.Links)},
+		{"attachments", formatAttachments(before.Attachments), formatAttachments(after.Attachments)},
+	}
+
+	changes := make([]storage.FieldChange, 0, len(fields))
+	for _, f := range fields {
+		if f.before != f.after {
+			changes = append(changes, storage.FieldChange{Field: f.name, Before: f.before, After: f.after})
+		}
+	}
+	return changes
+}
+
+func formatAuditRecord(record storage.AuditRecord) string {
+	changes := make([]string, 0, len(record.Changes))
+	for _, c := range record.Changes {
+		changes = append(changes, fmt.Sprintf("%s: %q -> %q", c.Field, c.Before, c.After))
+	}
+	return fmt.Sprintf("audit: %s %s event %s at %s: %s",
+		record.Actor, record.Action, record.EventID, record.At.Format(time.RFC3339), strings.Join(changes, ", "))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func formatLinks(links []storage.Link) string {
+	urls := make([]string, 0, len(links))
+	for _, link := range links {
+		urls = append(urls, link.URL)
+	}
+	return strings.Join(urls, ", ")
+}
+
+func formatAttachments(attachments []storage.Attachment) string {
+	names := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		names = append(names, attachment.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/google/uuid"
+)
+
+// MaxBatchSize limits the number of operations in one batch.
+const MaxBatchSize = 1000
+
+var (
+	ErrInvalidBatch = errors.New("invalid batch")
+	// ErrBatchAborted is the result of valid operations of an atomic batch in which another operation failed.
+	ErrBatchAborted = errors.New("batch aborted by a failed operation")
+)
+
+// BatchOp creates an event, or updates or deletes the event with ID.
+type BatchOp struct {
+	Kind  storage.BatchOpKind
+	ID    string
+	Event storage.Event
+}
+
+// BatchResult is the outcome of one operation of a batch: the created, updated
+// or deleted event, or the error that prevented the operation.
+type BatchResult struct {
+	Event storage.Event
+	Err   error
+}
+
+// ApplyBatch applies the operations in order on behalf of the user from ctx and returns
+// their results in the same order. Operations are independent unless atomic is set,
+// in which case either all of them are applied or none.
+func (a *App) ApplyBatch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	ctx, span := tracing.Start(ctx, "app.ApplyBatch")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoUser
+	}
+	if len(ops) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: more than %d operations", ErrInvalidBatch, MaxBatchSize)
+	}
+
+	results := make([]BatchResult, len(ops))
+	before := make([]storage.Event, len(ops))
+	// latest is the state of the events changed so far by the batch, so an event changed
+	// more than once is checked and audited against its state after the previous operation.
+	latest := make(map[string]storage.Event)
+	pending := make([]storage.BatchOp, 0, len(ops))
+	indexes := make([]int, 0, len(ops))
+	failed := false
+	now := a.now().UTC()
+	for i, op := range ops {
+		event, err := a.prepareBatchOp(ctx, userID, op, now, latest, &before[i])
+		if err != nil {
+			results[i].Err = err
+			failed = true
+			continue
+		}
+		latest[event.ID] = event
+		pending = append(pending, storage.BatchOp{Kind: op.Kind, Event: event})
+		indexes = append(indexes, i)
+		results[i].Event = event
+	}
+
+	if !(atomic && failed) {
+		errs, err := a.storage.ApplyBatch(ctx, pending, atomic)
+		if err != nil {
+			return nil, err
+		}
+		for j, err := range errs {
+			if err != nil {
+				results[indexes[j]] = BatchResult{Err: err}
+				failed = true
+			}
+		}
+	}
+
+	for i, op := range ops {
+		switch {
+		case results[i].Err != nil:
+			continue
+		case atomic && failed:
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		case op.Kind == storage.BatchDelete:
+			a.changed(ctx, storage.AuditDeleted, before[i], storage.Event{})
+		case op.Kind == storage.BatchUpdate:
+			a.changed(ctx, storage.AuditUpdated, before[i], results[i].Event)
+		default:
+			a.changed(ctx, storage.AuditCreated, storage.Event{}, results[i].Event)
+		}
+	}
+	return results, nil
+}
+
+// prepareBatchOp checks the operation the same way as the single event methods do and
+// returns the event to store. The current state of an updated or deleted event, taken from latest
+// if the batch has changed it already, is put into before.
+func (a *App) prepareBatchOp(
+	ctx context.Context, userID string, op BatchOp, now time.Time, latest map[string]storage.Event,
+	before *storage.Event,
+) (storage.Event, error) {
+	event := op.Event
+	calendarID := event.CalendarID
+	switch op.Kind {
+	case storage.BatchCreate:
+		event.ID = uuid.New().String()
+		event.Attachments = nil
+	case storage.BatchUpdate, storage.BatchDelete:
+		current, ok := latest[op.ID]
+		if ok && current.IsDeleted() {
+			return storage.Event{}, storage.ErrEventNotFound
+		}
+		if !ok {
+			var err error
+			if current, err = a.getWritableEvent(ctx, op.ID); err != nil {
+				return storage.Event{}, err
+			}
+		}
+		*before = current
+		if op.Kind == storage.BatchDelete {
+			current.DeletedAt = now
+			return current, nil
+		}
+		event.ID = op.ID
+		event.Attachments = current.Attachments
+		calendarID = calendarOrCurrent(event, current)
+	default:
+		return storage.Event{}, fmt.Errorf("%w: unknown operation", ErrInvalidBatch)
+	}
+
+	if err := a.place(ctx, userID, &event, calendarID); err != nil {
+		return storage.Event{}, err
+	}
+	event.DeletedAt = time.Time{}
+	if err := validate(event); err != nil {
+		return storage.Event{}, err
+	}
+	return event, nil
+}
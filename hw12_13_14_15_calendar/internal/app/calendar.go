@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultCalendarName is the name of the calendar every user has. Its ID is the ID of the user.
+	DefaultCalendarName = "Default"
+	// MaxCalendarShares limits the number of users a calendar can be shared with.
+	MaxCalendarShares = 100
+)
+
+var (
+	ErrInvalidCalendar  = errors.New("invalid calendar")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// CreateCalendar stores a new calendar owned by the user from ctx and returns it with the assigned ID.
+func (a *App) CreateCalendar(ctx context.Context, calendar storage.Calendar) (storage.Calendar, error) {
+	ctx, span := tracing.Start(ctx, "app.CreateCalendar")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Calendar{}, ErrNoUser
+	}
+
+	calendar.ID = uuid.New().String()
+	calendar.OwnerID = userID
+	if err := validateCalendar(calendar); err != nil {
+		return storage.Calendar{}, err
+	}
+
+	if err := a.storage.CreateCalendar(ctx, calendar); err != nil {
+		return storage.Calendar{}, err
+	}
+	return calendar, nil
+}
+
+// UpdateCalendar renames the calendar with the given ID and replaces the users it is shared with.
+// Only the owner may change a calendar.
+func (a *App) UpdateCalendar(ctx context.Context, id string, calendar storage.Calendar) (storage.Calendar, error) {
+	ctx, span := tracing.Start(ctx, "app.UpdateCalendar")
+	defer span.End()
+
+	current, err := a.getOwnCalendar(ctx, id)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+
+	calendar.ID, calendar.OwnerID = current.ID, current.OwnerID
+	if err := validateCalendar(calendar); err != nil {
+		return storage.Calendar{}, err
+	}
+
+	if err := a.storage.UpdateCalendar(ctx, calendar); err != nil {
+		return storage.Calendar{}, err
+	}
+	return calendar, nil
+}
+
+// DeleteCalendar deletes a calendar of the user from ctx. Its events have to be moved to other
+// calendars first, otherwise it fails with storage.ErrCalendarNotEmpty; so it does while deleted
+// events of the calendar can still be restored.
+func (a *App) DeleteCalendar(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "app.DeleteCalendar")
+	defer span.End()
+
+	if _, err := a.getOwnCalendar(ctx, id); err != nil {
+		return err
+	}
+	return a.storage.DeleteCalendar(ctx, id)
+}
+
+// ListCalendars returns the default calendar of the user from ctx followed by the calendars
+// the user owns or has been given access to.
+func (a *App) ListCalendars(ctx context.Context) ([]storage.Calendar, error) {
+	ctx, span := tracing.Start(ctx, "app.ListCalendars")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoUser
+	}
+
+	calendars, err := a.storage.ListCalendars(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]storage.Calendar{defaultCalendar(userID)}, calendars...), nil
+}
+
+// calendar returns the calendar with the given ID if the user may read it or, if write is set,
+// add and change its events. A calendar the user may not read is not found. The empty ID
+// stands for the default calendar of the user.
+func (a *App) calendar(ctx context.Context, userID, id string, write bool) (storage.Calendar, error) {
+	if id == "" || id == userID {
+		return defaultCalendar(userID), nil
+	}
+
+	calendar, err := a.storage.GetCalendar(ctx, id)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+	switch {
+	case !calendar.CanRead(userID):
+		return storage.Calendar{}, storage.ErrCalendarNotFound
+	case write && !calendar.CanWrite(userID):
+		return storage.Calendar{}, ErrPermissionDenied
+	}
+	return calendar, nil
+}
+
+// getOwnCalendar returns a calendar the user from ctx may change. The default calendar
+// cannot be changed.
+func (a *App) getOwnCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.Calendar{}, ErrNoUser
+	}
+	if id == "" || id == userID {
+		return storage.Calendar{}, fmt.Errorf("%w: the default calendar cannot be changed", ErrInvalidCalendar)
+	}
+
+	calendar, err := a.calendar(ctx, userID, id, false)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+	if calendar.OwnerID != userID {
+		return storage.Calendar{}, ErrPermissionDenied
+	}
+	return calendar, nil
+}
+
+// place puts the event into the calendar with the given ID if the user may write to it.
+// The event belongs to the owner of the calendar.
+func (a *App) place(ctx context.Context, userID string, event *storage.Event, calendarID string) error {
+	calendar, err := a.calendar(ctx, userID, calendarID, true)
+	if err != nil {
+		return err
+	}
+
+	event.UserID = calendar.OwnerID
+	event.CalendarID = calendar.ID
+	if calendar.ID == calendar.OwnerID {
+		event.CalendarID = ""
+	}
+	return nil
+}
+
+func defaultCalendar(userID string) storage.Calendar {
+	return storage.Calendar{ID: userID, Name: DefaultCalendarName, OwnerID: userID}
+}
+
+func validateCalendar(calendar storage.Calendar) error {
+	switch {
+	case calendar.Name == "":
+		return fmt.Errorf("%w: empty name", ErrInvalidCalendar)
+	case len(calendar.Shares) > MaxCalendarShares:
+		return fmt.Errorf("%w: shared with more than %d users", ErrInvalidCalendar, MaxCalendarShares)
+	}
+
+	for userID, role := range calendar.Shares {
+		switch {
+		case userID == "":
+			return fmt.Errorf("%w: empty user ID", ErrInvalidCalendar)
+		case userID == calendar.OwnerID:
+			return fmt.Errorf("%w: shared with its owner", ErrInvalidCalendar)
+		case role != storage.RoleReader && role != storage.RoleWriter:
+			return fmt.Errorf("%w: unknown role %q", ErrInvalidCalendar, role)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package app
+
+import "context"
+
+type ctxKey int
+
+const (
+	userIDKey ctxKey = iota
+	tenantIDKey
+)
+
+// ContextWithUserID returns a copy of ctx carrying the ID of the authenticated user.
+func ContextWithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// UserIDFromContext returns the ID of the authenticated user stored in ctx.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey).(string)
+	return userID, ok && userID != ""
+}
+
+// ContextWithTenantID returns a copy of ctx carrying the tenant of the authenticated user.
+// Every storage call made with ctx only sees the data of that tenant.
+func ContextWithTenantID(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantIDKey, tenantID)
+}
+
+// TenantIDFromContext returns the tenant stored in ctx, the default tenant "" if there is none.
+func TenantIDFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantIDKey).(string)
+	return tenantID
+}
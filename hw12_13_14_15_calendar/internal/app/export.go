@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+var ErrInvalidExport = errors.New("invalid export")
+
+// WithAdmins lets the given users export the events of all users of their tenant.
+// Users of tenants other than the default one are given as "<tenant ID>/<user ID>".
+func WithAdmins(userIDs ...string) Option {
+	return func(a *App) {
+		for _, userID := range userIDs {
+			a.admins[userID] = true
+		}
+	}
+}
+
+// ExportEvents passes the live events intersecting [from, to) of the user from ctx, or of all users
+// of the tenant if allUsers is set and the user is an admin, to fn one by one without loading them all.
+// The events come in no particular order and an export is not a consistent snapshot.
+func (a *App) ExportEvents(ctx context.Context, allUsers bool, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	ctx, span := tracing.Start(ctx, "app.ExportEvents")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return ErrNoUser
+	}
+	switch {
+	case from.IsZero() || to.IsZero():
+		return fmt.Errorf("%w: empty period", ErrInvalidExport)
+	case !to.After(from):
+		return fmt.Errorf("%w: end of period must be after its start", ErrInvalidExport)
+	}
+	if allUsers {
+		if !a.admins[tenantKey(ctx, userID)] {
+			return fmt.Errorf("%w: only admins may export events of all users", ErrPermissionDenied)
+		}
+		userID = ""
+	}
+
+	return a.storage.ExportEvents(ctx, userID, from, to, fn)
+}
@@ -0,0 +1,322 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/sender"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+// feedBuffer is how many changes may wait for a subscriber before it is considered too slow.
+const feedBuffer = 64
+
+type ChangeKind string
+
+const (
+	ChangeCreated  = ChangeKind(storage.AuditCreated)
+	ChangeUpdated  = ChangeKind(storage.AuditUpdated)
+	ChangeDeleted  = ChangeKind(storage.AuditDeleted)
+	ChangeRestored = ChangeKind(storage.AuditRestored)
+	// ChangeReminder means the notification time of the event has come.
+	ChangeReminder ChangeKind = "reminder"
+)
+
+// Change is a modification of an event or a due reminder delivered to subscribers.
+type Change struct {
+	Kind  ChangeKind
+	Event storage.Event
+	At    time.Time
+}
+
+// feed fans changes out to subscribers by user, keyed by tenantKey.
+type feed struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Change]struct{}
+}
+
+func newFeed() *feed {
+	return &feed{subs: make(map[string]map[chan Change]struct{})}
+}
+
+func (f *feed) subscribe(userID string) chan Change {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan Change, feedBuffer)
+	if f.subs[userID] == nil {
+		f.subs[userID] = make(map[chan Change]struct{})
+	}
+	f.subs[userID][ch] = struct{}{}
+	return ch
+}
+
+func (f *feed) unsubscribe(userID string, ch chan Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subs[userID][ch]; ok {
+		f.remove(userID, ch)
+	}
+}
+
+// publish never blocks: a subscriber that does not keep up is dropped and its channel closed.
+func (f *feed) publish(userID string, change Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs[userID] {
+		select {
+		case ch <- change:
+		default:
+			f.remove(userID, ch)
+		}
+	}
+}
+
+// stats returns the number of subscribers and of the changes waiting for them.
+func (f *feed) stats() (subscribers, queued int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, chans := range f.subs {
+		for ch := range chans {
+			subscribers++
+			queued += len(ch)
+		}
+	}
+	return subscribers, queued
+}
+
+func (f *feed) remove(userID string, ch chan Change) {
+	delete(f.subs[userID], ch)
+	if len(f.subs[userID]) == 0 {
+		delete(f.subs, userID)
+	}
+	close(ch)
+}
+
+// Subscribe streams changes of the events of the user from ctx, including due reminders,
+// until ctx is done. The channel is also closed if the subscriber falls too far behind,
+// in which case it should resubscribe and reload the events it shows.
+func (a *App) Subscribe(ctx context.Context) (<-chan Change, error) {
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoUser
+	}
+	if err := a.checkTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	key := tenantKey(ctx, userID)
+	ch := a.feed.subscribe(key)
+	go func() {
+		<-ctx.Done()
+		a.feed.unsubscribe(key, ch)
+	}()
+	return ch, nil
+}
+
+// WithSenders makes the application also deliver reminders through the backends, such as
+// webhooks and email. Notifications carry the user ID the way tenantKey builds it,
+// "<tenant ID>/<user ID>" for users of tenants other than the default one.
+// Up to reminderWorkers notifications are sent at once by the replica sending reminders.
+// A reminder whose notification failed without being dead-lettered is sent again on the next
+// run, to all backends, so backends may get a notification more than once.
+func WithSenders(backends ...sender.Backend) Option {
+	return func(a *App) {
+		a.senders = append(a.senders, backends...)
+	}
+}
+
+// reminderBatch is how many reminders are consumed from the outbox at once.
+const reminderBatch = 100
+
+// reminderWorkers is how many notifications are sent through the senders at once.
+const reminderWorkers = 16
+
+// cleanupInterval is how often RunReminders purges old and expired deleted events.
+const cleanupInterval = 24 * time.Hour
+
+// RunReminders publishes a reminder change for every event of every tenant whose notification
+// time has come, checking storage every interval until ctx is done. If the storage has
+// a reminder outbox, reminders due before the start are sent too. On the first check and
+// then once every cleanupInterval it also runs CleanupEvents, so only one replica does.
+func (a *App) RunReminders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := a.now().UTC()
+	var cleaned time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := a.now().UTC()
+		err := a.remind(ctx, last, now)
+		a.jobs.finished(&a.jobs.reminders, a.now().UTC(), err)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("failed to send reminders: %s", err))
+		} else {
+			last = now
+			a.jobs.reminded(now)
+		}
+
+		if now.Sub(cleaned) < cleanupInterval {
+			continue
+		}
+		purged, err := a.CleanupEvents(ctx)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("failed to clean up events: %s", err))
+			continue
+		}
+		cleaned = now
+		if purged > 0 {
+			a.logger.Info(fmt.Sprintf("purged %d old and deleted events", purged))
+		}
+	}
+}
+
+// remind publishes reminders of the events of all tenants, failing with the first tenant
+// whose reminders could not be sent; the others are still sent.
+func (a *App) remind(ctx context.Context, from, now time.Time) error {
+	var first error
+	for _, tenantID := range a.tenantIDs() {
+		if err := a.remindTenant(ContextWithTenantID(ctx, tenantID), from, now); err != nil && first == nil {
+			first = fmt.Errorf("tenant %q: %w", tenantID, err)
+		}
+	}
+	return first
+}
+
+// remindTenant publishes reminders of events of the tenant from ctx with a notification time
+// in [from, now), or all the due ones from the outbox if there is any.
+func (a *App) remindTenant(ctx context.Context, from, now time.Time) error {
+	ctx, span := a.tracer.Start(ctx, "app.RunReminders")
+	defer span.End()
+	span.SetAttribute("tenant", TenantIDFromContext(ctx))
+
+	if a.outbox != nil {
+		total, err := a.consumeReminders(ctx, now)
+		span.SetAttribute("reminders", strconv.Itoa(total))
+		if err != nil {
+			span.SetError(err)
+		}
+		return err
+	}
+
+	events, err := a.storage.ListEventsToNotify(ctx, from, now)
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	span.SetAttribute("reminders", strconv.Itoa(len(events)))
+	if err := a.deliverReminders(ctx, events); err != nil {
+		span.SetError(err)
+		return err
+	}
+	a.publishReminders(ctx, events, now)
+	return nil
+}
+
+// consumeReminders sends reminders from the outbox due at now in batches and returns
+// how many were sent. A batch is delivered through the senders while it is being consumed,
+// so its jobs stay in the outbox if the delivery fails, and published to subscribers after.
+func (a *App) consumeReminders(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		var consumed []storage.Event
+		n, err := a.outbox.ConsumeReminders(ctx, now, reminderBatch, func(events []storage.Event) error {
+			if err := a.deliverReminders(ctx, events); err != nil {
+				return err
+			}
+			consumed = append(consumed[:0], events...)
+			return nil
+		})
+		if err == nil {
+			a.publishReminders(ctx, consumed, now)
+		}
+		total += n
+		if err != nil || n < reminderBatch {
+			return total, err
+		}
+	}
+}
+
+// publishReminders publishes reminders of the events of the tenant from ctx to subscribers.
+func (a *App) publishReminders(ctx context.Context, events []storage.Event, now time.Time) {
+	for _, event := range events {
+		a.feed.publish(tenantKey(ctx, event.UserID), Change{Kind: ChangeReminder, Event: event, At: now})
+	}
+}
+
+// deliverReminders sends reminders of the events of the tenant from ctx through the senders
+// and fails with the first notification that should be sent again. Users without a recipient
+// configured for a backend are skipped, dead-lettered notifications are only logged.
+// Every notification is sent in a span continuing the trace of the last change of its event.
+func (a *App) deliverReminders(ctx context.Context, events []storage.Event) error {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		first   error
+		workers = make(chan struct{}, reminderWorkers)
+	)
+	for _, event := range events {
+		n := sender.Notification{
+			EventID: event.ID, Title: event.Title, StartAt: event.StartAt, UserID: tenantKey(ctx, event.UserID),
+		}
+		trace := tracing.MapCarrier(event.Trace)
+		for _, backend := range a.senders {
+			workers <- struct{}{}
+			wg.Add(1)
+			go func(backend sender.Backend) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+
+				ctx, span := a.tracer.Start(tracing.Extract(ctx, trace), "app.SendReminder")
+				defer span.End()
+				span.SetAttribute("event", n.EventID)
+
+				err := backend.Send(ctx, n)
+				span.SetError(err)
+				if err == nil || errors.Is(err, sender.ErrNoRecipient) {
+					return
+				}
+				a.logger.Error(fmt.Sprintf("failed to send reminder of event %s: %s", n.EventID, err))
+				if errors.Is(err, sender.ErrDeadLettered) {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if first == nil {
+					first = fmt.Errorf("reminder of event %s: %w", n.EventID, err)
+				}
+			}(backend)
+		}
+	}
+	wg.Wait()
+	return first
+}
+
+// changed records a change of an event in the audit trail and notifies subscribers of its owner.
+func (a *App) changed(ctx context.Context, action storage.AuditAction, before, after storage.Event) {
+	a.audit(ctx, action, before, after)
+
+	now := a.now().UTC()
+	event := after
+	if action == storage.AuditDeleted {
+		event = before
+		event.DeletedAt = now
+	}
+	a.feed.publish(tenantKey(ctx, event.UserID), Change{Kind: ChangeKind(action), Event: event, At: now})
+}
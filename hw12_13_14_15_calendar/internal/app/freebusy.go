@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+const (
+	// MaxFreeBusyUsers limits the number of users in one free-busy query.
+	MaxFreeBusyUsers = 100
+	// MaxFreeBusyRange limits the length of the period of a free-busy query.
+	MaxFreeBusyRange = 92 * 24 * time.Hour
+)
+
+var ErrInvalidFreeBusy = errors.New("invalid free-busy query")
+
+// Interval is a half-open period of time [StartAt, EndAt).
+type Interval struct {
+	StartAt time.Time
+	EndAt   time.Time
+}
+
+// UserBusy is the time in which a user has events.
+type UserBusy struct {
+	UserID string
+	Busy   []Interval
+}
+
+// FreeBusy tells when users are busy without revealing anything else about their events.
+type FreeBusy struct {
+	// Users holds the busy time of each requested user in the order of the query.
+	Users []UserBusy
+	// Busy is the time in which any of the users is busy.
+	Busy []Interval
+}
+
+// GetFreeBusy returns the busy time of the users within [from, to). Overlapping and
+// adjacent events are merged into one interval and intervals are clipped to the period.
+// Any authenticated user may query the free-busy time of other users.
+func (a *App) GetFreeBusy(ctx context.Context, userIDs []string, from, to time.Time) (FreeBusy, error) {
+	ctx, span := tracing.Start(ctx, "app.GetFreeBusy")
+	defer span.End()
+
+	if _, ok := UserIDFromContext(ctx); !ok {
+		return FreeBusy{}, ErrNoUser
+	}
+	users, err := validateFreeBusy(userIDs, from, to)
+	if err != nil {
+		return FreeBusy{}, err
+	}
+
+	busy, err := a.storage.ListBusy(ctx, users, from, to)
+	if err != nil {
+		return FreeBusy{}, err
+	}
+
+	byUser := make(map[string][]Interval, len(users))
+	all := make([]Interval, 0, len(busy))
+	for _, b := range busy {
+		interval := Interval{StartAt: b.StartAt, EndAt: b.EndAt}
+		if interval.StartAt.Before(from) {
+			interval.StartAt = from
+		}
+		if interval.EndAt.After(to) {
+			interval.EndAt = to
+		}
+		byUser[b.UserID] = append(byUser[b.UserID], interval)
+		all = append(all, interval)
+	}
+
+	result := FreeBusy{Users: make([]UserBusy, 0, len(users)), Busy: merge(all)}
+	for _, userID := range users {
+		result.Users = append(result.Users, UserBusy{UserID: userID, Busy: merge(byUser[userID])})
+	}
+	return result, nil
+}
+
+// validateFreeBusy returns the requested users without duplicates.
+func validateFreeBusy(userIDs []string, from, to time.Time) ([]string, error) {
+	switch {
+	case len(userIDs) == 0:
+		return nil, fmt.Errorf("%w: no users", ErrInvalidFreeBusy)
+	case len(userIDs) > MaxFreeBusyUsers:
+		return nil, fmt.Errorf("%w: more than %d users", ErrInvalidFreeBusy, MaxFreeBusyUsers)
+	case from.IsZero() || to.IsZero():
+		return nil, fmt.Errorf("%w: empty period", ErrInvalidFreeBusy)
+	case !to.After(from):
+		return nil, fmt.Errorf("%w: end of period must be after its start", ErrInvalidFreeBusy)
+	case to.Sub(from) > MaxFreeBusyRange:
+		return nil, fmt.Errorf("%w: period longer than %s", ErrInvalidFreeBusy, MaxFreeBusyRange)
+	}
+
+	seen := make(map[string]bool, len(userIDs))
+	users := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == "" {
+			return nil, fmt.Errorf("%w: empty user ID", ErrInvalidFreeBusy)
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			users = append(users, userID)
+		}
+	}
+	return users, nil
+}
+
+// merge sorts intervals and joins the ones that overlap or touch.
+func merge(intervals []Interval) []Interval {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].StartAt.Before(intervals[j].StartAt)
+	})
+
+	merged := make([]Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		if last := len(merged) - 1; last >= 0 && !interval.StartAt.After(merged[last].EndAt) {
+			if interval.EndAt.After(merged[last].EndAt) {
+				merged[last].EndAt = interval.EndAt
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
@@ -0,0 +1,271 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/holiday"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+var ErrInvalidWorkingHours = errors.New("invalid working hours")
+
+// WithHolidays makes the holiday calendars available for users to follow in their working hours.
+func WithHolidays(calendars ...*holiday.Calendar) Option {
+	return func(a *App) {
+		for _, calendar := range calendars {
+			a.holidays[calendar.Name] = calendar
+		}
+	}
+}
+
+// SetWorkingHours replaces the working hours of the user from ctx.
+func (a *App) SetWorkingHours(ctx context.Context, hours storage.WorkingHours) (storage.WorkingHours, error) {
+	ctx, span := tracing.Start(ctx, "app.SetWorkingHours")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.WorkingHours{}, ErrNoUser
+	}
+	hours.UserID = userID
+	if err := a.validateWorkingHours(&hours); err != nil {
+		return storage.WorkingHours{}, err
+	}
+
+	if err := a.storage.SetWorkingHours(ctx, hours); err != nil {
+		return storage.WorkingHours{}, err
+	}
+	return hours, nil
+}
+
+// GetWorkingHours returns the working hours of the user from ctx.
+func (a *App) GetWorkingHours(ctx context.Context) (storage.WorkingHours, error) {
+	ctx, span := tracing.Start(ctx, "app.GetWorkingHours")
+	defer span.End()
+
+	userID, ok := UserIDFromContext(ctx)
+	if !ok {
+		return storage.WorkingHours{}, ErrNoUser
+	}
+	return a.storage.GetWorkingHours(ctx, userID)
+}
+
+// ListHolidayCalendars returns the holiday calendars users may follow, ordered by name.
+func (a *App) ListHolidayCalendars() []*holiday.Calendar {
+	calendars := make([]*holiday.Calendar, 0, len(a.holidays))
+	for _, calendar := range a.holidays {
+		calendars = append(calendars, calendar)
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		return calendars[i].Name < calendars[j].Name
+	})
+	return calendars
+}
+
+// FindFreeSlots returns the periods within [from, to) of at least the given duration in which
+// all the users work and none of them has events. Users without working hours may work any time.
+func (a *App) FindFreeSlots(
+	ctx context.Context, userIDs []string, from, to time.Time, duration time.Duration,
+) ([]Interval, error) {
+	ctx, span := tracing.Start(ctx, "app.FindFreeSlots")
+	defer span.End()
+
+	if _, ok := UserIDFromContext(ctx); !ok {
+		return nil, ErrNoUser
+	}
+	users, err := validateFreeBusy(userIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("%w: duration must be positive", ErrInvalidFreeBusy)
+	}
+
+	free := []Interval{{StartAt: from, EndAt: to}}
+	for _, userID := range users {
+		working, err := a.workingIntervals(ctx, userID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		free = intersect(free, working)
+	}
+
+	busy, err := a.storage.ListBusy(ctx, users, from, to)
+	if err != nil {
+		return nil, err
+	}
+	taken := make([]Interval, 0, len(busy))
+	for _, b := range busy {
+		taken = append(taken, Interval{StartAt: b.StartAt, EndAt: b.EndAt})
+	}
+	free = subtract(free, merge(taken))
+
+	slots := make([]Interval, 0, len(free))
+	for _, interval := range free {
+		if interval.EndAt.Sub(interval.StartAt) >= duration {
+			slots = append(slots, interval)
+		}
+	}
+	return slots, nil
+}
+
+// WorkingHoursWarnings tells why the event is outside the working hours of its owner, if it is.
+func (a *App) WorkingHoursWarnings(ctx context.Context, event storage.Event) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "app.WorkingHoursWarnings")
+	defer span.End()
+
+	hours, err := a.storage.GetWorkingHours(ctx, event.UserID)
+	if errors.Is(err, storage.ErrWorkingHoursNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := make([]string, 0)
+	loc, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	if calendar := a.holidays[hours.Holidays]; calendar != nil {
+		if name, ok := calendar.Lookup(event.StartAt.In(loc)); ok {
+			warnings = append(warnings, fmt.Sprintf("the event is on the holiday %q of %s", name, event.UserID))
+		}
+	}
+	working := a.working(hours, loc, event.StartAt, event.EndAt)
+	if len(working) != 1 || !working[0].StartAt.Equal(event.StartAt) || !working[0].EndAt.Equal(event.EndAt) {
+		warnings = append(warnings, fmt.Sprintf("the event is outside the working hours of %s", event.UserID))
+	}
+	return warnings, nil
+}
+
+// workingIntervals returns when the user works within [from, to).
+func (a *App) workingIntervals(ctx context.Context, userID string, from, to time.Time) ([]Interval, error) {
+	hours, err := a.storage.GetWorkingHours(ctx, userID)
+	if errors.Is(err, storage.ErrWorkingHoursNotFound) {
+		return []Interval{{StartAt: from, EndAt: to}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	return a.working(hours, loc, from, to), nil
+}
+
+// working returns the working periods of the hours within [from, to).
+func (a *App) working(hours storage.WorkingHours, loc *time.Location, from, to time.Time) []Interval {
+	calendar := a.holidays[hours.Holidays]
+	intervals := make([]Interval, 0)
+	year, month, day := from.In(loc).Date()
+	for date := time.Date(year, month, day, 0, 0, 0, 0, loc); date.Before(to); date = date.AddDate(0, 0, 1) {
+		if !hours.Works(date.Weekday()) {
+			continue
+		}
+		if calendar != nil {
+			if _, ok := calendar.Lookup(date); ok {
+				continue
+			}
+		}
+
+		// Times of day are wall clock times, which keeps them right on days of DST changes.
+		y, m, d := date.Date()
+		interval := Interval{
+			StartAt: time.Date(y, m, d, 0, 0, 0, int(hours.Start), loc).UTC(),
+			EndAt:   time.Date(y, m, d, 0, 0, 0, int(hours.End), loc).UTC(),
+		}
+		if interval.StartAt.Before(from) {
+			interval.StartAt = from
+		}
+		if interval.EndAt.After(to) {
+			interval.EndAt = to
+		}
+		if interval.StartAt.Before(interval.EndAt) {
+			intervals = append(intervals, interval)
+		}
+	}
+	return intervals
+}
+
+func (a *App) validateWorkingHours(hours *storage.WorkingHours) error {
+	if _, err := time.LoadLocation(hours.TimeZone); err != nil || hours.TimeZone == "" {
+		return fmt.Errorf("%w: unknown time zone %q", ErrInvalidWorkingHours, hours.TimeZone)
+	}
+	switch {
+	case hours.Start < 0 || hours.End > 24*time.Hour:
+		return fmt.Errorf("%w: working time must be within a day", ErrInvalidWorkingHours)
+	case hours.End <= hours.Start:
+		return fmt.Errorf("%w: end of working time must be after its start", ErrInvalidWorkingHours)
+	case hours.Holidays != "" && a.holidays[hours.Holidays] == nil:
+		return fmt.Errorf("%w: unknown holiday calendar %q", ErrInvalidWorkingHours, hours.Holidays)
+	}
+
+	seen := make(map[time.Weekday]bool, len(hours.Days))
+	days := make([]time.Weekday, 0, len(hours.Days))
+	for _, day := range hours.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("%w: bad weekday %d", ErrInvalidWorkingHours, day)
+		}
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	hours.Days = days
+	return nil
+}
+
+// intersect returns the time covered by both a and b; both must be sorted and disjoint.
+func intersect(a, b []Interval) []Interval {
+	result := make([]Interval, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := a[i].StartAt, a[i].EndAt
+		if b[j].StartAt.After(start) {
+			start = b[j].StartAt
+		}
+		if b[j].EndAt.Before(end) {
+			end = b[j].EndAt
+		}
+		if start.Before(end) {
+			result = append(result, Interval{StartAt: start, EndAt: end})
+		}
+		if a[i].EndAt.Before(b[j].EndAt) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// subtract returns the time of free not covered by busy; both must be sorted and disjoint.
+func subtract(free, busy []Interval) []Interval {
+	result := make([]Interval, 0, len(free))
+	j := 0
+	for _, interval := range free {
+		start := interval.StartAt
+		for j < len(busy) && busy[j].EndAt.Before(start) {
+			j++
+		}
+		for k := j; k < len(busy) && busy[k].StartAt.Before(interval.EndAt); k++ {
+			if busy[k].StartAt.After(start) {
+				result = append(result, Interval{StartAt: start, EndAt: busy[k].StartAt})
+			}
+			if busy[k].EndAt.After(start) {
+				start = busy[k].EndAt
+			}
+		}
+		if start.Before(interval.EndAt) {
+			result = append(result, Interval{StartAt: start, EndAt: interval.EndAt})
+		}
+	}
+	return result
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/breaker"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// ErrUnavailable is returned when the storage keeps failing transiently or the circuit breaker
+// in front of it is open.
+var ErrUnavailable = errors.New("storage unavailable")
+
+// TransientErrors is implemented by storages that can tell transient failures, such as a lost
+// connection or a serialization conflict, from the errors that would repeat on retry.
+type TransientErrors interface {
+	// IsTransient reports whether err is a transient failure after which the call had no effect.
+	IsTransient(err error) bool
+}
+
+// WithRetries makes a storage call failing transiently be made up to attempts times in total,
+// waiting backoff before the first retry and twice as long before every next one.
+// Calls are retried only if the storage implements TransientErrors.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(a *App) {
+		a.retryAttempts = attempts
+		a.retryBackoff = backoff
+	}
+}
+
+// WithCircuitBreaker makes storage calls fail fast with ErrUnavailable for the cooldown
+// after the given number of consecutive calls failed transiently, retries included.
+// Ready reports the application degraded while the breaker is open.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(a *App) {
+		a.breaker = breaker.New(failures, cooldown)
+	}
+}
+
+// Ready fails with ErrUnavailable while the circuit breaker is open. A half-open breaker is
+// reported ready, so that the replica gets the requests that probe the storage and close it again.
+func (a *App) Ready() error {
+	if a.breaker == nil || a.breaker.State() != breaker.Open {
+		return nil
+	}
+	return fmt.Errorf("%w: circuit breaker is open since %s", ErrUnavailable,
+		a.breaker.OpenedAt().UTC().Format(time.RFC3339))
+}
+
+func (a *App) isTransient(err error) bool {
+	if err == nil || isCanceled(err) {
+		return false
+	}
+	t, ok := a.tenantStorage.storage.(TransientErrors)
+	return ok && t.IsTransient(err)
+}
+
+// isCanceled reports whether err comes from a cancelled or expired context, which tells nothing
+// about the health of the storage.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// resilientStorage retries storage calls that fail transiently and guards the storage
+// with the circuit breaker, as configured in app.
+type resilientStorage struct {
+	storage Storage
+	app     *App
+}
+
+// call calls fn up to attempts times while it fails transiently, returning the last error
+// as soon as ctx is done.
+func (s resilientStorage) call(ctx context.Context, attempts int, fn func() error) error {
+	a := s.app
+	if a.breaker != nil {
+		if err := a.breaker.Allow(); err != nil {
+			return fmt.Errorf("%w: %s", ErrUnavailable, err)
+		}
+	}
+
+	backoff := a.retryBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if !a.isTransient(err) || attempt >= attempts || !sleep(ctx, backoff) {
+			break
+		}
+		backoff *= 2
+	}
+
+	transient := a.isTransient(err)
+	switch {
+	case a.breaker == nil:
+	case isCanceled(err):
+		a.breaker.Cancel()
+	default:
+		a.breaker.Done(transient)
+	}
+	if transient {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	return err
+}
+
+// sleep waits for d and reports whether it did before ctx was done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retry calls fn retrying transient failures.
+func (s resilientStorage) retry(ctx context.Context, fn func() error) error {
+	return s.call(ctx, s.app.retryAttempts, fn)
+}
+
+// once calls fn without retries, for calls that may have had an effect when they failed.
+func (s resilientStorage) once(ctx context.Context, fn func() error) error {
+	return s.call(ctx, 1, fn)
+}
+
+func (s resilientStorage) CreateEvent(ctx context.Context, event storage.Event) error {
+	return s.retry(ctx, func() error {
+		return s.storage.CreateEvent(ctx, event)
+	})
+}
+
+func (s resilientStorage) UpdateEvent(ctx context.Context, event storage.Event) error {
+	return s.retry(ctx, func() error {
+		return s.storage.UpdateEvent(ctx, event)
+	})
+}
+
+func (s resilientStorage) DeleteEvent(ctx context.Context, id string, at time.Time) error {
+	return s.retry(ctx, func() error {
+		return s.storage.DeleteEvent(ctx, id, at)
+	})
+}
+
+func (s resilientStorage) RestoreEvent(ctx context.Context, id string) error {
+	return s.retry(ctx, func() error {
+		return s.storage.RestoreEvent(ctx, id)
+	})
+}
+
+func (s resilientStorage) PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time,
+) ([]storage.Event, error) {
+	var purged []storage.Event
+	err := s.retry(ctx, func() (err error) {
+		purged, err = s.storage.PurgeEvents(ctx, endedBefore, deletedBefore)
+		return err
+	})
+	return purged, err
+}
+
+func (s resilientStorage) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	var event storage.Event
+	err := s.retry(ctx, func() (err error) {
+		event, err = s.storage.GetEvent(ctx, id)
+		return err
+	})
+	return event, err
+}
+
+func (s resilientStorage) ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	var events []storage.Event
+	err := s.retry(ctx, func() (err error) {
+		events, err = s.storage.ListEvents(ctx, userID, from, to)
+		return err
+	})
+	return events, err
+}
+
+func (s resilientStorage) ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error) {
+	var events []storage.Event
+	err := s.retry(ctx, func() (err error) {
+		events, err = s.storage.ListDeletedEvents(ctx, userID)
+		return err
+	})
+	return events, err
+}
+
+func (s resilientStorage) ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error) {
+	var events []storage.Event
+	err := s.retry(ctx, func() (err error) {
+		events, err = s.storage.ListEventsToNotify(ctx, from, to)
+		return err
+	})
+	return events, err
+}
+
+func (s resilientStorage) ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error) {
+	var busy []storage.Busy
+	err := s.retry(ctx, func() (err error) {
+		busy, err = s.storage.ListBusy(ctx, userIDs, from, to)
+		return err
+	})
+	return busy, err
+}
+
+// ApplyBatch retries only atomic batches, as the operations of others may have been applied
+// before the failure.
+func (s resilientStorage) ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error) {
+	call := s.once
+	if atomic {
+		call = s.retry
+	}
+	var errs []error
+	err := call(ctx, func() (err error) {
+		errs, err = s.storage.ApplyBatch(ctx, ops, atomic)
+		return err
+	})
+	return errs, err
+}
+
+func (s resilientStorage) AddAuditRecord(ctx context.Context, record storage.AuditRecord) error {
+	return s.retry(ctx, func() error {
+		return s.storage.AddAuditRecord(ctx, record)
+	})
+}
+
+func (s resilientStorage) ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error) {
+	var records []storage.AuditRecord
+	err := s.retry(ctx, func() (err error) {
+		records, err = s.storage.ListAuditRecords(ctx, eventID)
+		return err
+	})
+	return records, err
+}
+
+func (s resilientStorage) CreateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	return s.retry(ctx, func() error {
+		return s.storage.CreateCalendar(ctx, calendar)
+	})
+}
+
+func (s resilientStorage) UpdateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	return s.retry(ctx, func() error {
+		return s.storage.UpdateCalendar(ctx, calendar)
+	})
+}
+
+func (s resilientStorage) DeleteCalendar(ctx context.Context, id string) error {
+	return s.retry(ctx, func() error {
+		return s.storage.DeleteCalendar(ctx, id)
+	})
+}
+
+func (s resilientStorage) GetCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	var calendar storage.Calendar
+	err := s.retry(ctx, func() (err error) {
+		calendar, err = s.storage.GetCalendar(ctx, id)
+		return err
+	})
+	return calendar, err
+}
+
+func (s resilientStorage) ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error) {
+	var calendars []storage.Calendar
+	err := s.retry(ctx, func() (err error) {
+		calendars, err = s.storage.ListCalendars(ctx, userID)
+		return err
+	})
+	return calendars, err
+}
+
+func (s resilientStorage) SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error {
+	return s.retry(ctx, func() error {
+		return s.storage.SetWorkingHours(ctx, hours)
+	})
+}
+
+func (s resilientStorage) GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error) {
+	var hours storage.WorkingHours
+	err := s.retry(ctx, func() (err error) {
+		hours, err = s.storage.GetWorkingHours(ctx, userID)
+		return err
+	})
+	return hours, err
+}
+
+// ExportEvents is not retried, as some of the events may have been passed to fn when the call failed.
+func (s resilientStorage) ExportEvents(ctx context.Context, userID string, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	return s.once(ctx, func() error {
+		return s.storage.ExportEvents(ctx, userID, from, to, fn)
+	})
+}
+
+func (s resilientStorage) Stats(ctx context.Context, now time.Time) (storage.Stats, error) {
+	var stats storage.Stats
+	err := s.retry(ctx, func() (err error) {
+		stats, err = s.storage.Stats(ctx, now)
+		return err
+	})
+	return stats, err
+}
+
+// ConsumeReminders is not retried, as the reminders may have been sent when the call failed.
+func (s resilientStorage) ConsumeReminders(ctx context.Context, until time.Time, limit int,
+	fn func(events []storage.Event) error) (int, error) {
+	var n int
+	err := s.once(ctx, func() (err error) {
+		n, err = s.storage.(ReminderOutbox).ConsumeReminders(ctx, until, limit, fn)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+// JobStatus tells how the last run of a background job of this process went.
+type JobStatus struct {
+	// LastRun is when the job last finished; zero if it has not run in this process,
+	// e.g. because another replica sends the reminders.
+	LastRun time.Time
+	// Error is the error of the last run, empty if it succeeded.
+	Error string
+}
+
+// Stats describe the state of the application for operators.
+type Stats struct {
+	// Storage is the sum of the counts of all tenants in Tenants, which are keyed by tenant ID.
+	Storage storage.Stats
+	Tenants map[string]storage.Stats
+	// Subscribers is the number of open change streams and QueuedChanges the number
+	// of changes waiting to be sent to them.
+	Subscribers   int
+	QueuedChanges int
+	Reminders     JobStatus
+	// ReminderLag is how long ago the period up to which all reminders were sent ended.
+	// It stays within the reminder interval unless sending fails.
+	ReminderLag time.Duration
+	Cleanup     JobStatus
+}
+
+// jobs keeps the status of the background jobs.
+type jobs struct {
+	mu        sync.Mutex
+	reminders JobStatus
+	cleanup   JobStatus
+	// remindedUntil is the end of the last period whose reminders were sent.
+	remindedUntil time.Time
+}
+
+func (j *jobs) finished(status *JobStatus, at time.Time, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status.LastRun = at
+	status.Error = ""
+	if err != nil {
+		status.Error = err.Error()
+	}
+}
+
+func (j *jobs) reminded(until time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.remindedUntil = until
+}
+
+// Stats returns the counts of stored events and reminders of every tenant, the change streams
+// and the status of the background jobs.
+func (a *App) Stats(ctx context.Context) (Stats, error) {
+	ctx, span := tracing.Start(ctx, "app.Stats")
+	defer span.End()
+
+	now := a.now().UTC()
+	stats := Stats{Tenants: make(map[string]storage.Stats)}
+	for _, tenantID := range a.tenantIDs() {
+		tenantStats, err := a.storage.Stats(ContextWithTenantID(ctx, tenantID), now)
+		if err != nil {
+			return Stats{}, fmt.Errorf("tenant %q: %w", tenantID, err)
+		}
+		stats.Tenants[tenantID] = tenantStats
+		stats.Storage.Events += tenantStats.Events
+		stats.Storage.DeletedEvents += tenantStats.DeletedEvents
+		stats.Storage.Reminders += tenantStats.Reminders
+	}
+	stats.Subscribers, stats.QueuedChanges = a.feed.stats()
+
+	a.jobs.mu.Lock()
+	defer a.jobs.mu.Unlock()
+
+	stats.Reminders, stats.Cleanup = a.jobs.reminders, a.jobs.cleanup
+	if !a.jobs.remindedUntil.IsZero() {
+		stats.ReminderLag = now.Sub(a.jobs.remindedUntil)
+	}
+	return stats, nil
+}
@@ -0,0 +1,305 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Tenant is the configuration of a tenant, such as a team whose calendars are hosted here.
+type Tenant struct {
+	// MaxEvents limits the number of live events of the tenant; zero means no limit.
+	MaxEvents int
+}
+
+// TenantStorages gives the storage of a tenant. Storages of different tenants share no data.
+type TenantStorages interface {
+	Tenant(id string) (Storage, error)
+}
+
+// TenantFunc adapts a function to TenantStorages.
+type TenantFunc func(id string) (Storage, error)
+
+func (f TenantFunc) Tenant(id string) (Storage, error) {
+	return f(id)
+}
+
+// WithTenants serves the given tenants, by ID, with their storages from storages. The storage
+// passed to New is the one of the default tenant "", which always exists and is configured
+// by the entry "" if there is any. Requests of any other tenant are rejected with ErrUnknownTenant.
+func WithTenants(storages TenantStorages, tenants map[string]Tenant) Option {
+	return func(a *App) {
+		a.tenantStorages = storages
+		for id, tenant := range tenants {
+			a.tenants[id] = tenant
+		}
+	}
+}
+
+// tenantIDs returns the IDs of all tenants in order, the default one first.
+func (a *App) tenantIDs() []string {
+	ids := []string{""}
+	for id := range a.tenants {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids[1:])
+	return ids
+}
+
+// checkTenant fails if the tenant from ctx is not served.
+func (a *App) checkTenant(ctx context.Context) error {
+	_, err := a.tenantStorage.forContext(ctx)
+	return err
+}
+
+// tenantKey identifies the user of the tenant from ctx among the users of all tenants.
+func tenantKey(ctx context.Context, userID string) string {
+	if tenantID := TenantIDFromContext(ctx); tenantID != "" {
+		return tenantID + "/" + userID
+	}
+	return userID
+}
+
+// tenantStorage passes every call to the storage of the tenant from its context,
+// so no call can reach the data of another tenant.
+// Calls that may add live events fail with ErrQuotaExceeded if the tenant would have
+// more than its quota allows.
+type tenantStorage struct {
+	storage Storage
+	app     *App
+}
+
+func (s tenantStorage) forContext(ctx context.Context) (Storage, error) {
+	id := TenantIDFromContext(ctx)
+	if id == "" {
+		return s.storage, nil
+	}
+	if _, ok := s.app.tenants[id]; !ok || s.app.tenantStorages == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, id)
+	}
+	return s.app.tenantStorages.Tenant(id)
+}
+
+// withQuota returns a copy of ctx in which the storage enforces the quota of the tenant from ctx.
+func (s tenantStorage) withQuota(ctx context.Context) context.Context {
+	if limit := s.app.tenants[TenantIDFromContext(ctx)].MaxEvents; limit > 0 {
+		return storage.ContextWithEventLimit(ctx, limit)
+	}
+	return ctx
+}
+
+// quotaError reports the limit of live events set by withQuota being reached as ErrQuotaExceeded.
+func quotaError(ctx context.Context, err error) error {
+	if errors.Is(err, storage.ErrTooManyEvents) {
+		return fmt.Errorf("%w: the tenant may have at most %d events",
+			ErrQuotaExceeded, storage.EventLimitFromContext(ctx))
+	}
+	return err
+}
+
+func (s tenantStorage) CreateEvent(ctx context.Context, event storage.Event) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	ctx = s.withQuota(ctx)
+	return quotaError(ctx, st.CreateEvent(ctx, event))
+}
+
+func (s tenantStorage) UpdateEvent(ctx context.Context, event storage.Event) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.UpdateEvent(ctx, event)
+}
+
+func (s tenantStorage) DeleteEvent(ctx context.Context, id string, at time.Time) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.DeleteEvent(ctx, id, at)
+}
+
+func (s tenantStorage) RestoreEvent(ctx context.Context, id string) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	ctx = s.withQuota(ctx)
+	return quotaError(ctx, st.RestoreEvent(ctx, id))
+}
+
+func (s tenantStorage) PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.PurgeEvents(ctx, endedBefore, deletedBefore)
+}
+
+func (s tenantStorage) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return storage.Event{}, err
+	}
+	return st.GetEvent(ctx, id)
+}
+
+func (s tenantStorage) ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ListEvents(ctx, userID, from, to)
+}
+
+func (s tenantStorage) ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ListDeletedEvents(ctx, userID)
+}
+
+func (s tenantStorage) ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ListEventsToNotify(ctx, from, to)
+}
+
+func (s tenantStorage) ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ListBusy(ctx, userIDs, from, to)
+}
+
+func (s tenantStorage) ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = s.withQuota(ctx)
+	errs, err := st.ApplyBatch(ctx, ops, atomic)
+	return errs, quotaError(ctx, err)
+}
+
+func (s tenantStorage) AddAuditRecord(ctx context.Context, record storage.AuditRecord) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.AddAuditRecord(ctx, record)
+}
+
+func (s tenantStorage) ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ListAuditRecords(ctx, eventID)
+}
+
+func (s tenantStorage) CreateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.CreateCalendar(ctx, calendar)
+}
+
+func (s tenantStorage) UpdateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.UpdateCalendar(ctx, calendar)
+}
+
+func (s tenantStorage) DeleteCalendar(ctx context.Context, id string) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.DeleteCalendar(ctx, id)
+}
+
+func (s tenantStorage) GetCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+	return st.GetCalendar(ctx, id)
+}
+
+func (s tenantStorage) ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.ListCalendars(ctx, userID)
+}
+
+func (s tenantStorage) SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.SetWorkingHours(ctx, hours)
+}
+
+func (s tenantStorage) GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return storage.WorkingHours{}, err
+	}
+	return st.GetWorkingHours(ctx, userID)
+}
+
+func (s tenantStorage) ExportEvents(ctx context.Context, userID string, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return err
+	}
+	return st.ExportEvents(ctx, userID, from, to, fn)
+}
+
+func (s tenantStorage) Stats(ctx context.Context, now time.Time) (storage.Stats, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return storage.Stats{}, err
+	}
+	return st.Stats(ctx, now)
+}
+
+// ConsumeReminders consumes the reminders of the tenant from ctx; the storage passed to New,
+// and so the storages of all tenants, must be a ReminderOutbox.
+func (s tenantStorage) ConsumeReminders(ctx context.Context, until time.Time, limit int,
+	fn func(events []storage.Event) error) (int, error) {
+	st, err := s.forContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	outbox, ok := st.(ReminderOutbox)
+	if !ok {
+		return 0, fmt.Errorf("storage of tenant %q has no reminder outbox", TenantIDFromContext(ctx))
+	}
+	return outbox.ConsumeReminders(ctx, until, limit, fn)
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+// tracedStorage records a span for every storage call made in a traced context.
+// Created and changed events keep the trace context of the call for their reminders.
+type tracedStorage struct {
+	storage Storage
+}
+
+func (s tracedStorage) CreateEvent(ctx context.Context, event storage.Event) error {
+	event = withTrace(ctx, event)
+	ctx, span := tracing.Start(ctx, "storage.CreateEvent")
+	defer span.End()
+
+	err := s.storage.CreateEvent(ctx, event)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) UpdateEvent(ctx context.Context, event storage.Event) error {
+	event = withTrace(ctx, event)
+	ctx, span := tracing.Start(ctx, "storage.UpdateEvent")
+	defer span.End()
+
+	err := s.storage.UpdateEvent(ctx, event)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) DeleteEvent(ctx context.Context, id string, at time.Time) error {
+	ctx, span := tracing.Start(ctx, "storage.DeleteEvent")
+	defer span.End()
+
+	err := s.storage.DeleteEvent(ctx, id, at)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) RestoreEvent(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "storage.RestoreEvent")
+	defer span.End()
+
+	err := s.storage.RestoreEvent(ctx, id)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "storage.PurgeEvents")
+	defer span.End()
+
+	purged, err := s.storage.PurgeEvents(ctx, endedBefore, deletedBefore)
+	span.SetError(err)
+	return purged, err
+}
+
+func (s tracedStorage) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetEvent")
+	defer span.End()
+
+	event, err := s.storage.GetEvent(ctx, id)
+	span.SetError(err)
+	return event, err
+}
+
+func (s tracedStorage) ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListEvents")
+	defer span.End()
+
+	events, err := s.storage.ListEvents(ctx, userID, from, to)
+	span.SetError(err)
+	return events, err
+}
+
+func (s tracedStorage) ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListDeletedEvents")
+	defer span.End()
+
+	events, err := s.storage.ListDeletedEvents(ctx, userID)
+	span.SetError(err)
+	return events, err
+}
+
+func (s tracedStorage) ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListEventsToNotify")
+	defer span.End()
+
+	events, err := s.storage.ListEventsToNotify(ctx, from, to)
+	span.SetError(err)
+	return events, err
+}
+
+func (s tracedStorage) ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListBusy")
+	defer span.End()
+
+	busy, err := s.storage.ListBusy(ctx, userIDs, from, to)
+	span.SetError(err)
+	return busy, err
+}
+
+func (s tracedStorage) ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error) {
+	traced := make([]storage.BatchOp, len(ops))
+	for i, op := range ops {
+		if op.Kind != storage.BatchDelete {
+			op.Event = withTrace(ctx, op.Event)
+		}
+		traced[i] = op
+	}
+	ops = traced
+	ctx, span := tracing.Start(ctx, "storage.ApplyBatch")
+	defer span.End()
+
+	errs, err := s.storage.ApplyBatch(ctx, ops, atomic)
+	span.SetError(err)
+	return errs, err
+}
+
+func (s tracedStorage) AddAuditRecord(ctx context.Context, record storage.AuditRecord) error {
+	ctx, span := tracing.Start(ctx, "storage.AddAuditRecord")
+	defer span.End()
+
+	err := s.storage.AddAuditRecord(ctx, record)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListAuditRecords")
+	defer span.End()
+
+	records, err := s.storage.ListAuditRecords(ctx, eventID)
+	span.SetError(err)
+	return records, err
+}
+
+func (s tracedStorage) CreateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	ctx, span := tracing.Start(ctx, "storage.CreateCalendar")
+	defer span.End()
+
+	err := s.storage.CreateCalendar(ctx, calendar)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) UpdateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	ctx, span := tracing.Start(ctx, "storage.UpdateCalendar")
+	defer span.End()
+
+	err := s.storage.UpdateCalendar(ctx, calendar)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) DeleteCalendar(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "storage.DeleteCalendar")
+	defer span.End()
+
+	err := s.storage.DeleteCalendar(ctx, id)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) GetCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetCalendar")
+	defer span.End()
+
+	calendar, err := s.storage.GetCalendar(ctx, id)
+	span.SetError(err)
+	return calendar, err
+}
+
+func (s tracedStorage) ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error) {
+	ctx, span := tracing.Start(ctx, "storage.ListCalendars")
+	defer span.End()
+
+	calendars, err := s.storage.ListCalendars(ctx, userID)
+	span.SetError(err)
+	return calendars, err
+}
+
+func (s tracedStorage) SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error {
+	ctx, span := tracing.Start(ctx, "storage.SetWorkingHours")
+	defer span.End()
+
+	err := s.storage.SetWorkingHours(ctx, hours)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error) {
+	ctx, span := tracing.Start(ctx, "storage.GetWorkingHours")
+	defer span.End()
+
+	hours, err := s.storage.GetWorkingHours(ctx, userID)
+	span.SetError(err)
+	return hours, err
+}
+
+func (s tracedStorage) ExportEvents(ctx context.Context, userID string, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	ctx, span := tracing.Start(ctx, "storage.ExportEvents")
+	defer span.End()
+
+	err := s.storage.ExportEvents(ctx, userID, from, to, fn)
+	span.SetError(err)
+	return err
+}
+
+func (s tracedStorage) Stats(ctx context.Context, now time.Time) (storage.Stats, error) {
+	ctx, span := tracing.Start(ctx, "storage.Stats")
+	defer span.End()
+
+	stats, err := s.storage.Stats(ctx, now)
+	span.SetError(err)
+	return stats, err
+}
+
+// withTrace returns the event carrying the trace context of ctx, or none if ctx is not traced.
+func withTrace(ctx context.Context, event storage.Event) storage.Event {
+	carrier := tracing.MapCarrier{}
+	tracing.Inject(ctx, carrier)
+	event.Trace = nil
+	if len(carrier) > 0 {
+		event.Trace = carrier
+	}
+	return event
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// APIKeys authenticates static API keys, mapping each key to an identity.
+type APIKeys map[string]Identity
+
+func (k APIKeys) Authenticate(_ context.Context, token string) (Identity, error) {
+	var identity Identity
+	for key, id := range k {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			identity = id
+		}
+	}
+	if identity.UserID == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+// BasicAPIKeys authenticates HTTP Basic credentials whose password is an API key, for clients
+// such as calendar apps that support no other scheme. The user name must be the one the key
+// authenticates, "<tenant ID>/<user ID>" for users of tenants other than the default one.
+type BasicAPIKeys APIKeys
+
+func (k BasicAPIKeys) Authenticate(ctx context.Context, token string) (Identity, error) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return Identity{}, ErrUnauthenticated
+	}
+	user, key, ok := cut(string(decoded), ":")
+	if !ok {
+		return Identity{}, ErrUnauthenticated
+	}
+	identity, err := APIKeys(k).Authenticate(ctx, key)
+	if err != nil {
+		return Identity{}, err
+	}
+	name := identity.UserID
+	if identity.TenantID != "" {
+		name = identity.TenantID + "/" + identity.UserID
+	}
+	if subtle.ConstantTimeCompare([]byte(name), []byte(user)) != 1 {
+		return Identity{}, ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := BasicAPIKeys{
+		"k1": {UserID: "user-1"},
+		"k2": {UserID: "user-2", TenantID: "acme"},
+	}
+	basic := func(credentials string) string {
+		return base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	identity, err := keys.Authenticate(ctx, basic("user-1:k1"))
+	require.NoError(t, err)
+	require.Equal(t, Identity{UserID: "user-1"}, identity)
+
+	identity, err = keys.Authenticate(ctx, basic("acme/user-2:k2"))
+	require.NoError(t, err)
+	require.Equal(t, Identity{UserID: "user-2", TenantID: "acme"}, identity)
+
+	for _, token := range []string{basic("user-2:k1"), basic("user-2:k2"), basic("user-1:k3"), basic("k1"), "user-1:k1"} {
+		_, err = keys.Authenticate(ctx, token)
+		require.ErrorIs(t, err, ErrUnauthenticated, token)
+	}
+}
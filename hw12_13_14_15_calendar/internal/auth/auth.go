@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+const (
+	SchemeBearer = "Bearer"
+	SchemeAPIKey = "ApiKey"
+	SchemeBasic  = "Basic"
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrTokenExpired    = errors.New("token expired")
+)
+
+// Identity is who a credential belongs to: a user of a tenant. The default tenant is "".
+type Identity struct {
+	UserID   string
+	TenantID string
+}
+
+// Authenticator verifies a credential and returns the identity it belongs to.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (Identity, error)
+}
+
+// Schemes dispatches credentials of the form "<scheme> <token>"
+// (the value of an Authorization header) to the authenticator registered for the scheme.
+type Schemes map[string]Authenticator
+
+func (s Schemes) Authenticate(ctx context.Context, credential string) (Identity, error) {
+	parts := strings.SplitN(strings.TrimSpace(credential), " ", 2)
+	if len(parts) != 2 {
+		return Identity{}, ErrUnauthenticated
+	}
+
+	for scheme, authenticator := range s {
+		if strings.EqualFold(scheme, parts[0]) {
+			return authenticator.Authenticate(ctx, strings.TrimSpace(parts[1]))
+		}
+	}
+	return Identity{}, ErrUnauthenticated
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWT(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	j := NewJWT([]byte("secret"))
+	j.now = func() time.Time { return now }
+
+	t.Run("valid token", func(t *testing.T) {
+		token, err := j.Issue(Identity{UserID: "user-1"}, time.Hour)
+		require.NoError(t, err)
+
+		identity, err := j.Authenticate(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, Identity{UserID: "user-1"}, identity)
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		token, err := j.Issue(Identity{UserID: "user-1", TenantID: "acme"}, time.Hour)
+		require.NoError(t, err)
+
+		identity, err := j.Authenticate(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, Identity{UserID: "user-1", TenantID: "acme"}, identity)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		token, err := j.Issue(Identity{UserID: "user-1"}, time.Minute)
+		require.NoError(t, err)
+
+		later := NewJWT([]byte("secret"))
+		later.now = func() time.Time { return now.Add(time.Hour) }
+		_, err = later.Authenticate(ctx, token)
+		require.ErrorIs(t, err, ErrTokenExpired)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		token, err := NewJWT([]byte("other")).Issue(Identity{UserID: "user-1"}, 0)
+		require.NoError(t, err)
+
+		_, err = j.Authenticate(ctx, token)
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("tampered payload", func(t *testing.T) {
+		token, err := j.Issue(Identity{UserID: "user-1"}, 0)
+		require.NoError(t, err)
+		forged, err := j.Issue(Identity{UserID: "user-2"}, 0)
+		require.NoError(t, err)
+
+		parts := strings.Split(token, ".")
+		parts[1] = strings.Split(forged, ".")[1]
+		_, err = j.Authenticate(ctx, strings.Join(parts, "."))
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("alg none", func(t *testing.T) {
+		header, err := encodeSegment(jwtHeader{Alg: "none"})
+		require.NoError(t, err)
+		payload, err := encodeSegment(jwtClaims{Subject: "user-1"})
+		require.NoError(t, err)
+
+		_, err = j.Authenticate(ctx, header+"."+payload+".")
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, token := range []string{"", "abc", "a.b", "a.b.c.d", "!!.??.**"} {
+			_, err := j.Authenticate(ctx, token)
+			require.ErrorIs(t, err, ErrInvalidToken, token)
+		}
+	})
+}
+
+func TestSchemes(t *testing.T) {
+	ctx := context.Background()
+	j := NewJWT([]byte("secret"))
+	schemes := Schemes{
+		SchemeBearer: j,
+		SchemeAPIKey: APIKeys{"key-1": {UserID: "user-1"}, "key-2": {UserID: "user-2", TenantID: "acme"}},
+	}
+
+	token, err := j.Issue(Identity{UserID: "user-3"}, 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		credential string
+		identity   Identity
+		err        error
+	}{
+		{credential: "Bearer " + token, identity: Identity{UserID: "user-3"}},
+		{credential: "bearer " + token, identity: Identity{UserID: "user-3"}},
+		{credential: "ApiKey key-2", identity: Identity{UserID: "user-2", TenantID: "acme"}},
+		{credential: "ApiKey key-3", err: ErrUnauthenticated},
+		{credential: "Basic dXNlcjpwYXNz", err: ErrUnauthenticated},
+		{credential: token, err: ErrUnauthenticated},
+		{credential: "", err: ErrUnauthenticated},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.credential, func(t *testing.T) {
+			identity, err := schemes.Authenticate(ctx, tc.credential)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.identity, identity)
+		})
+	}
+}
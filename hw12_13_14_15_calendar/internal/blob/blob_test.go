@@ -0,0 +1,43 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, l.Put(ctx, "event-1/a", strings.NewReader("hello")))
+	r, err := l.Get(ctx, "event-1/a")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "hello", string(data))
+
+	require.NoError(t, l.Put(ctx, "event-1/a", strings.NewReader("bye")), "put replaces the blob")
+	r, err = l.Get(ctx, "event-1/a")
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "bye", string(data))
+
+	require.NoError(t, l.Delete(ctx, "event-1/a"))
+	require.NoError(t, l.Delete(ctx, "event-1/a"))
+	_, err = l.Get(ctx, "event-1/a")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	for _, key := range []string{"", "../secret", "a/../../b", "/etc/passwd", "a//b", "a/"} {
+		require.Error(t, l.Put(ctx, key, strings.NewReader("x")), key)
+		_, err := l.Get(ctx, key)
+		require.Error(t, err, key)
+	}
+}
@@ -0,0 +1,132 @@
+// Package breaker stops calls to a failing dependency for a while so that they fail fast
+// instead of piling up while it recovers.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker.
+type State int
+
+const (
+	// Closed lets all calls through.
+	Closed State = iota
+	// Open rejects all calls until the cooldown is over.
+	Open
+	// HalfOpen lets a single probe call through to tell whether the dependency has recovered.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// Breaker opens after the given number of consecutive failed calls and rejects calls
+// for the cooldown. Then it lets one call through: the breaker closes if the call succeeds
+// and opens again for another cooldown if it fails.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow fails with ErrOpen if a call may not be made now. Every allowed call must be
+// followed by Done with its outcome or by Cancel.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state() {
+	case Closed:
+		return nil
+	case HalfOpen:
+		if !b.probing {
+			b.probing = true
+			return nil
+		}
+	}
+	return ErrOpen
+}
+
+// Done records the outcome of an allowed call.
+func (b *Breaker) Done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := b.probing
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	// Calls allowed before the breaker opened may still finish while it is open or probing.
+	if !b.openedAt.IsZero() && !probe {
+		return
+	}
+	b.failures++
+	if probe || b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// Cancel records that an allowed call ended without an outcome, such as when its caller gave up
+// on it. The state does not change; if the call was the probe, the next call probes instead.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+// OpenedAt returns when the breaker opened last; it is zero while the breaker is closed.
+func (b *Breaker) OpenedAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openedAt
+}
+
+func (b *Breaker) state() State {
+	switch {
+	case b.openedAt.IsZero():
+		return Closed
+	case b.now().Before(b.openedAt.Add(b.cooldown)):
+		return Open
+	default:
+		return HalfOpen
+	}
+}
@@ -0,0 +1,82 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		b := New(3, time.Minute)
+
+		for i := 0; i < 2; i++ {
+			require.NoError(t, b.Allow())
+			b.Done(true)
+		}
+		require.NoError(t, b.Allow())
+		b.Done(false)
+
+		for i := 0; i < 3; i++ {
+			require.Equal(t, Closed, b.State())
+			require.NoError(t, b.Allow())
+			b.Done(true)
+		}
+		require.Equal(t, Open, b.State())
+		require.ErrorIs(t, b.Allow(), ErrOpen)
+	})
+
+	t.Run("probes after cooldown", func(t *testing.T) {
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		b := New(1, time.Minute)
+		b.now = func() time.Time { return now }
+
+		require.NoError(t, b.Allow())
+		b.Done(true)
+		require.Equal(t, now, b.OpenedAt())
+
+		now = now.Add(time.Minute)
+		require.Equal(t, HalfOpen, b.State())
+		require.NoError(t, b.Allow())
+		require.ErrorIs(t, b.Allow(), ErrOpen, "only one probe at a time")
+
+		b.Done(true)
+		require.Equal(t, Open, b.State())
+		require.Equal(t, now, b.OpenedAt())
+
+		now = now.Add(time.Minute)
+		require.NoError(t, b.Allow())
+		b.Done(false)
+		require.Equal(t, Closed, b.State())
+		require.True(t, b.OpenedAt().IsZero())
+	})
+
+	t.Run("cancelled probe", func(t *testing.T) {
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		b := New(1, time.Minute)
+		b.now = func() time.Time { return now }
+
+		require.NoError(t, b.Allow())
+		b.Done(true)
+		now = now.Add(time.Minute)
+		require.NoError(t, b.Allow())
+		b.Cancel()
+		require.Equal(t, HalfOpen, b.State(), "a call without an outcome does not close the breaker")
+		require.NoError(t, b.Allow(), "the next call probes instead")
+	})
+
+	t.Run("late failures do not extend cooldown", func(t *testing.T) {
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		b := New(1, time.Minute)
+		b.now = func() time.Time { return now }
+
+		require.NoError(t, b.Allow())
+		require.NoError(t, b.Allow())
+		b.Done(true)
+
+		now = now.Add(30 * time.Second)
+		b.Done(true)
+		require.Equal(t, now.Add(-30*time.Second), b.OpenedAt())
+	})
+}
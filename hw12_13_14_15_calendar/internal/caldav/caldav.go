@@ -0,0 +1,551 @@
+// Package caldav serves the calendars of the authenticated user over CalDAV (RFC 4791),
+// so that native calendar apps can subscribe to them and change their events.
+//
+// The tree under the prefix of the handler is:
+//
+//	/                    the principal of the user, which is also the home of the calendars
+//	/<calendar>/         a calendar collection; the default calendar has the ID of the user
+//	/<calendar>/<id>.ics an event as an iCalendar object, named after the event ID
+//
+// Only the methods needed to sync calendars are supported: PROPFIND, the calendar-query and
+// calendar-multiget REPORTs, GET, PUT and DELETE. Events created with PUT get the resource
+// name as their ID. Recurring events and other components than VEVENT are not supported.
+package caldav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+const (
+	methodPropfind = "PROPFIND"
+	methodReport   = "REPORT"
+
+	contentTypeCalendar = "text/calendar; charset=utf-8"
+	contentTypeXML      = "application/xml; charset=utf-8"
+
+	// maxBodySize limits the size of request bodies.
+	maxBodySize = 1 << 20
+)
+
+// allTime is the period listed when a request does not limit it.
+var allTime = [2]time.Time{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+type Logger interface {
+	Error(msg string)
+}
+
+// Application is the part of the calendar served over CalDAV.
+type Application interface {
+	ListCalendars(ctx context.Context) ([]storage.Calendar, error)
+	ListEvents(ctx context.Context, from, to time.Time, calendarIDs ...string) ([]storage.Event, error)
+	GetEvent(ctx context.Context, id string) (storage.Event, error)
+	PutEvent(ctx context.Context, id string, event storage.Event) (storage.Event, bool, error)
+	DeleteEvent(ctx context.Context, id string) error
+	DeleteEventVersion(ctx context.Context, id string, version int64) error
+}
+
+// Handler serves CalDAV requests of users authenticated by the server it is mounted on.
+type Handler struct {
+	logger Logger
+	app    Application
+	prefix string
+}
+
+// NewHandler returns a handler serving the tree under prefix, such as "/caldav/".
+func NewHandler(logger Logger, app Application, prefix string) *Handler {
+	return &Handler{logger: logger, app: app, prefix: "/" + strings.Trim(prefix, "/") + "/"}
+}
+
+// resource is the target of a request: the home, a calendar or, if name is set, an event.
+type resource struct {
+	calendarID string
+	name       string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res, ok := h.parsePath(r.URL.EscapedPath())
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, HEAD, PUT, DELETE")
+	case r.Method == methodPropfind:
+		h.propfind(w, r, res)
+	case r.Method == methodReport && res.calendarID != "" && res.name == "":
+		h.report(w, r, res)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && res.name != "":
+		h.get(w, r, res)
+	case r.Method == http.MethodPut && res.name != "":
+		h.put(w, r, res)
+	case r.Method == http.MethodDelete && res.name != "":
+		h.delete(w, r, res)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// parsePath reads the resource from the escaped path of a request.
+func (h *Handler) parsePath(path string) (resource, bool) {
+	if path+"/" == h.prefix || path == h.prefix {
+		return resource{}, true
+	}
+	if !strings.HasPrefix(path, h.prefix) {
+		return resource{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, h.prefix), "/"), "/")
+	if len(parts) > 2 || parts[0] == "" {
+		return resource{}, false
+	}
+
+	var res resource
+	var err error
+	if res.calendarID, err = url.PathUnescape(parts[0]); err != nil {
+		return resource{}, false
+	}
+	if len(parts) == 2 {
+		name, err := url.PathUnescape(parts[1])
+		if err != nil || !strings.HasSuffix(name, ".ics") || name == ".ics" {
+			return resource{}, false
+		}
+		res.name = strings.TrimSuffix(name, ".ics")
+	}
+	return res, true
+}
+
+func (h *Handler) homeHref() string {
+	return h.prefix
+}
+
+func (h *Handler) calendarHref(id string) string {
+	return h.prefix + url.PathEscape(id) + "/"
+}
+
+func (h *Handler) eventHref(event storage.Event) string {
+	return h.calendarHref(event.Calendar()) + url.PathEscape(event.ID) + ".ics"
+}
+
+func (h *Handler) propfind(w http.ResponseWriter, r *http.Request, res resource) {
+	var req propfind
+	if err := decodeXML(r.Body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	names := req.Prop.names()
+	depth1 := r.Header.Get("Depth") != "0"
+	ctx := r.Context()
+
+	var responses []response
+	switch {
+	case res.name != "":
+		event, err := h.getEvent(ctx, res)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		responses = append(responses, newResponse(h.eventHref(event), h.eventProps(event), names))
+	case res.calendarID != "":
+		calendar, err := h.getCalendar(ctx, res.calendarID)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		responses = append(responses, newResponse(h.calendarHref(calendar.ID), h.calendarProps(ctx, calendar), names))
+		if depth1 {
+			events, err := h.app.ListEvents(ctx, allTime[0], allTime[1], calendar.ID)
+			if err != nil {
+				h.writeError(w, err)
+				return
+			}
+			for _, event := range events {
+				responses = append(responses, newResponse(h.eventHref(event), h.eventProps(event), names))
+			}
+		}
+	default:
+		responses = append(responses, newResponse(h.homeHref(), h.homeProps(ctx), names))
+		if depth1 {
+			calendars, err := h.app.ListCalendars(ctx)
+			if err != nil {
+				h.writeError(w, err)
+				return
+			}
+			for _, calendar := range calendars {
+				responses = append(responses,
+					newResponse(h.calendarHref(calendar.ID), h.calendarProps(ctx, calendar), names))
+			}
+		}
+	}
+	h.writeMultistatus(w, responses)
+}
+
+func (h *Handler) report(w http.ResponseWriter, r *http.Request, res resource) {
+	var req report
+	if err := decodeXML(r.Body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	calendar, err := h.getCalendar(ctx, res.calendarID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	names := req.Prop.names()
+	var responses []response
+	switch req.XMLName {
+	case reportCalendarQuery:
+		var events []storage.Event
+		events, err = h.query(ctx, calendar, req)
+		for _, event := range events {
+			responses = append(responses, newResponse(h.eventHref(event), h.eventProps(event), names))
+		}
+	case reportCalendarMultiget:
+		responses, err = h.multiget(ctx, calendar, req.Hrefs, names)
+	default:
+		h.writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsDAV, Local: "supported-report"})
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeMultistatus(w, responses)
+}
+
+// query returns the events of the calendar matching the filter of a calendar-query.
+// Filters on properties of events are not supported.
+func (h *Handler) query(ctx context.Context, calendar storage.Calendar, req report) ([]storage.Event, error) {
+	from, to := allTime[0], allTime[1]
+	if req.Filter != nil {
+		filter := req.Filter.CompFilter
+		if filter.Name != "VCALENDAR" || filter.IsNotDefined != nil || filter.TimeRange != nil ||
+			len(filter.PropFilters) > 0 {
+			return nil, errUnsupportedFilter
+		}
+		for _, f := range filter.CompFilters {
+			if f.Name != "VEVENT" || f.IsNotDefined != nil {
+				// Only events are stored, so other components never match.
+				return nil, nil
+			}
+			if len(f.PropFilters) > 0 || len(f.CompFilters) > 0 {
+				return nil, errUnsupportedFilter
+			}
+			if f.TimeRange != nil {
+				var err error
+				if from, to, err = parseTimeRange(*f.TimeRange, from, to); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return h.app.ListEvents(ctx, from, to, calendar.ID)
+}
+
+// multiget reports the properties of the events of the calendar at the given hrefs.
+func (h *Handler) multiget(ctx context.Context, calendar storage.Calendar, hrefs []string,
+	names []xml.Name) ([]response, error) {
+	responses := make([]response, 0, len(hrefs))
+	for _, ref := range hrefs {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad href %q", errBadRequest, ref)
+		}
+		notFound := response{Href: ref, Status: status(http.StatusNotFound)}
+		res, ok := h.parsePath(u.EscapedPath())
+		if !ok || res.name == "" || res.calendarID != calendar.ID {
+			responses = append(responses, notFound)
+			continue
+		}
+		event, err := h.getEvent(ctx, res)
+		switch {
+		case errors.Is(err, storage.ErrEventNotFound):
+			responses = append(responses, notFound)
+		case err != nil:
+			return nil, err
+		default:
+			responses = append(responses, newResponse(h.eventHref(event), h.eventProps(event), names))
+		}
+	}
+	return responses, nil
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, res resource) {
+	event, err := h.getEvent(r.Context(), res)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	data := encodeEvent(event)
+	w.Header().Set("Content-Type", contentTypeCalendar)
+	w.Header().Set("ETag", etag(data))
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// put creates or replaces an event. The event is stored as the application sees it, which may
+// differ from the request, so no ETag is returned and clients read the event back.
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, res resource) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "text/calendar" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	event, err := decodeEvent(r.Body)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	ctx := r.Context()
+	current, err := h.app.GetEvent(ctx, res.name)
+	exists := err == nil
+	switch {
+	case err != nil && !errors.Is(err, storage.ErrEventNotFound):
+		h.writeError(w, err)
+		return
+	case exists && current.Calendar() != res.calendarID:
+		// Events are moved between calendars in the API, not by creating them anew.
+		h.writeError(w, fmt.Errorf("%w in another calendar", storage.ErrEventExists))
+		return
+	}
+	if !checkPreconditions(r, current, exists) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	// Links cannot be edited in calendar apps, so they are kept.
+	event.Links = current.Links
+	event.CalendarID = res.calendarID
+	// The preconditions hold for the event read above, so it is only stored if it has not changed since.
+	if hasPreconditions(r) {
+		event.Version = app.AbsentVersion
+		if exists {
+			event.Version = current.Version
+		}
+	}
+	if _, created, err := h.app.PutEvent(ctx, res.name, event); err != nil {
+		h.writeError(w, err)
+	} else if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, res resource) {
+	ctx := r.Context()
+	current, err := h.getEvent(ctx, res)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !checkPreconditions(r, current, true) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if hasPreconditions(r) {
+		err = h.app.DeleteEventVersion(ctx, current.ID, current.Version)
+	} else {
+		err = h.app.DeleteEvent(ctx, current.ID)
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkPreconditions evaluates the If-Match and If-None-Match headers against the current event.
+func checkPreconditions(r *http.Request, current storage.Event, exists bool) bool {
+	tag := ""
+	if exists {
+		tag = etag(encodeEvent(current))
+	}
+	if match := r.Header.Get("If-Match"); match != "" {
+		if !exists || (match != "*" && !containsETag(match, tag)) {
+			return false
+		}
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && exists {
+		if noneMatch == "*" || containsETag(noneMatch, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+func containsETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// getEvent returns the event of the resource, which must be in the calendar of the resource.
+func (h *Handler) getEvent(ctx context.Context, res resource) (storage.Event, error) {
+	event, err := h.app.GetEvent(ctx, res.name)
+	if err != nil {
+		return storage.Event{}, err
+	}
+	if event.Calendar() != res.calendarID {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	return event, nil
+}
+
+func (h *Handler) getCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	calendars, err := h.app.ListCalendars(ctx)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+	for _, calendar := range calendars {
+		if calendar.ID == id {
+			return calendar, nil
+		}
+	}
+	return storage.Calendar{}, storage.ErrCalendarNotFound
+}
+
+func (h *Handler) homeProps(ctx context.Context) map[xml.Name]string {
+	userID, _ := app.UserIDFromContext(ctx)
+	return map[xml.Name]string{
+		propResourceType:     `<collection xmlns="DAV:"/><principal xmlns="DAV:"/>`,
+		propDisplayName:      text(userID),
+		propCurrentPrincipal: href(h.homeHref()),
+		propPrincipalURL:     href(h.homeHref()),
+		propCalendarHome:     href(h.homeHref()),
+	}
+}
+
+func (h *Handler) calendarProps(ctx context.Context, calendar storage.Calendar) map[xml.Name]string {
+	userID, _ := app.UserIDFromContext(ctx)
+	privileges := `<privilege xmlns="DAV:"><read/></privilege>`
+	if calendar.CanWrite(userID) {
+		privileges += `<privilege xmlns="DAV:"><write/></privilege>`
+	}
+	return map[xml.Name]string{
+		propResourceType:     `<collection xmlns="DAV:"/><calendar xmlns="urn:ietf:params:xml:ns:caldav"/>`,
+		propDisplayName:      text(calendar.Name),
+		propCurrentPrincipal: href(h.homeHref()),
+		propPrivileges:       privileges,
+		propSupportedCompSet: `<comp xmlns="urn:ietf:params:xml:ns:caldav" name="VEVENT"/>`,
+	}
+}
+
+func (h *Handler) eventProps(event storage.Event) map[xml.Name]string {
+	data := encodeEvent(event)
+	return map[xml.Name]string{
+		propResourceType: "",
+		propETag:         text(etag(data)),
+		propContentType:  text(contentTypeCalendar),
+		propCalendarData: text(string(data)),
+	}
+}
+
+func (h *Handler) writeMultistatus(w http.ResponseWriter, responses []response) {
+	w.Header().Set("Content-Type", contentTypeXML)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(multistatus{Responses: responses}); err != nil {
+		h.logger.Error("caldav: " + err.Error())
+	}
+}
+
+// writePrecondition responds with the precondition of RFC 4791 or RFC 4918 the request violates.
+func (h *Handler) writePrecondition(w http.ResponseWriter, code int, precondition xml.Name) {
+	w.Header().Set("Content-Type", contentTypeXML)
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `%s<error xmlns="DAV:"><%s xmlns="%s"/></error>`, xml.Header, precondition.Local, precondition.Space)
+}
+
+var (
+	errBadRequest        = errors.New("bad request")
+	errUnsupportedFilter = errors.New("unsupported filter")
+)
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidData):
+		h.writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-data"})
+	case errors.Is(err, errUnsupportedObject):
+		h.writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-object-resource"})
+	case errors.Is(err, errUnsupportedFilter):
+		h.writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "supported-filter"})
+	case errors.Is(err, errBadRequest), errors.Is(err, app.ErrInvalidEvent):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, app.ErrNoUser):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, app.ErrPermissionDenied), errors.Is(err, app.ErrUnknownTenant):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, storage.ErrEventNotFound), errors.Is(err, storage.ErrCalendarNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, storage.ErrDateBusy), errors.Is(err, storage.ErrEventExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, storage.ErrEventChanged):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, app.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, app.ErrUnavailable):
+		h.logger.Error("caldav: " + err.Error())
+		http.Error(w, app.ErrUnavailable.Error(), http.StatusServiceUnavailable)
+	default:
+		h.logger.Error("caldav: " + err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// decodeXML reads a request body into v; an empty body leaves v as it is.
+func decodeXML(r io.Reader, v interface{}) error {
+	err := xml.NewDecoder(r).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+	return nil
+}
+
+func parseTimeRange(r timeRange, from, to time.Time) (time.Time, time.Time, error) {
+	var err error
+	if r.Start != "" {
+		if from, err = time.Parse(dateTimeFormat, r.Start); err != nil {
+			return from, to, fmt.Errorf("%w: bad time-range start %q", errBadRequest, r.Start)
+		}
+	}
+	if r.End != "" {
+		if to, err = time.Parse(dateTimeFormat, r.End); err != nil {
+			return from, to, fmt.Errorf("%w: bad time-range end %q", errBadRequest, r.End)
+		}
+	}
+	return from, to, nil
+}
+
+func etag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
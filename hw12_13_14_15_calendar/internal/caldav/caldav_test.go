@@ -0,0 +1,284 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+
+const standup = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//test//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Berlin\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"DTSTAMP:20210520T080000Z\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20210601T100000\r\n" +
+	"DURATION:PT30M\r\n" +
+	"SUMMARY:Standup\\, daily\r\n" +
+	"DESCRIPTION:First line\\nsecond \r\n" +
+	" line\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestICalendar(t *testing.T) {
+	t.Run("decode", func(t *testing.T) {
+		event, err := decodeEvent(strings.NewReader(standup))
+		require.NoError(t, err)
+		require.Equal(t, storage.Event{
+			Title:        "Standup, daily",
+			Description:  "First line\nsecond line",
+			StartAt:      time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC),
+			EndAt:        time.Date(2021, 6, 1, 8, 30, 0, 0, time.UTC),
+			NotifyBefore: 15 * time.Minute,
+		}, event)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		event := storage.Event{
+			ID:           "1",
+			Title:        strings.Repeat("Планёрка; ", 20),
+			Description:  "a\\b,\nc",
+			StartAt:      time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC),
+			EndAt:        time.Date(2021, 6, 2, 8, 0, 0, 0, time.UTC),
+			NotifyBefore: 26*time.Hour + 90*time.Second,
+		}
+		data := encodeEvent(event)
+		for _, line := range strings.Split(string(data), "\r\n") {
+			require.LessOrEqual(t, len(line), maxLineLength)
+		}
+
+		decoded, err := decodeEvent(strings.NewReader(string(data)))
+		require.NoError(t, err)
+		event.ID = ""
+		require.Equal(t, event, decoded)
+	})
+
+	t.Run("all-day event", func(t *testing.T) {
+		data := strings.Replace(standup, "DTSTART;TZID=Europe/Berlin:20210601T100000\r\nDURATION:PT30M",
+			"DTSTART;VALUE=DATE:20210601\r\nDTEND;VALUE=DATE:20210602", 1)
+		event, err := decodeEvent(strings.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), event.StartAt)
+		require.Equal(t, time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC), event.EndAt)
+
+		data = strings.Replace(standup, "DTSTART;TZID=Europe/Berlin:20210601T100000\r\nDURATION:PT30M",
+			"DTSTART;VALUE=DATE:20210601", 1)
+		event, err = decodeEvent(strings.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC), event.EndAt, "all-day events last a day by default")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		recurring := strings.Replace(standup, "DURATION:PT30M", "DURATION:PT30M\r\nRRULE:FREQ=DAILY", 1)
+		_, err := decodeEvent(strings.NewReader(recurring))
+		require.ErrorIs(t, err, errUnsupportedObject)
+
+		todo := strings.Replace(standup, "VEVENT", "VTODO", 2)
+		_, err = decodeEvent(strings.NewReader(todo))
+		require.ErrorIs(t, err, errUnsupportedObject)
+
+		_, err = decodeEvent(strings.NewReader(strings.TrimSuffix(standup, "END:VCALENDAR\r\n")))
+		require.ErrorIs(t, err, errInvalidData)
+
+		badZone := strings.Replace(standup, "TZID=Europe/Berlin", "TZID=Nowhere", 1)
+		_, err = decodeEvent(strings.NewReader(badZone))
+		require.ErrorIs(t, err, errInvalidData)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	calendar := app.New(nopLogger{}, memorystorage.New())
+	h := NewHandler(nopLogger{}, calendar, "/caldav/")
+	do := func(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r = r.WithContext(app.ContextWithUserID(context.Background(), "alice"))
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	const (
+		eventPath    = "/caldav/alice/standup@example.com.ics"
+		calendarData = "text/calendar"
+	)
+
+	w := do(http.MethodPut, eventPath, standup, "Content-Type", calendarData, "If-None-Match", "*")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = do(http.MethodPut, eventPath, standup, "Content-Type", calendarData, "If-None-Match", "*")
+	require.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = do(http.MethodGet, eventPath, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "UID:standup@example.com\r\n")
+	tag := w.Header().Get("ETag")
+	require.NotEmpty(t, tag)
+
+	event, err := calendar.GetEvent(app.ContextWithUserID(context.Background(), "alice"), "standup@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "Standup, daily", event.Title)
+	require.Equal(t, 15*time.Minute, event.NotifyBefore)
+
+	t.Run("update", func(t *testing.T) {
+		retro := strings.Replace(standup, "Standup", "Retro", 1)
+		w := do(http.MethodPut, eventPath, retro, "Content-Type", calendarData, "If-Match", `"stale"`)
+		require.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		w = do(http.MethodPut, eventPath, retro, "Content-Type", calendarData, "If-Match", tag)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		tag = do(http.MethodGet, eventPath, "").Header().Get("ETag")
+
+		w = do(http.MethodPut, eventPath, retro, "Content-Type", "application/json")
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+		badZone := strings.Replace(retro, "TZID=Europe/Berlin", "TZID=Nowhere", 1)
+		w = do(http.MethodPut, "/caldav/alice/other.ics", badZone, "Content-Type", calendarData)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "valid-calendar-data")
+
+		w = do(http.MethodPut, "/caldav/alice/busy.ics", retro, "Content-Type", calendarData)
+		require.Equal(t, http.StatusConflict, w.Code, "the time is taken by the event")
+	})
+
+	t.Run("propfind", func(t *testing.T) {
+		w := do("PROPFIND", "/caldav/", `<?xml version="1.0"?>
+			<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+				<d:prop><d:current-user-principal/><c:calendar-home-set/><d:getctag/></d:prop>
+			</d:propfind>`, "Depth", "0")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		body := w.Body.String()
+		require.Contains(t, body, `<current-user-principal xmlns="DAV:"><href xmlns="DAV:">/caldav/</href>`)
+		require.Contains(t, body, `<calendar-home-set xmlns="urn:ietf:params:xml:ns:caldav">`)
+		require.Contains(t, body, `<getctag xmlns="DAV:"></getctag></prop><status>HTTP/1.1 404 Not Found</status>`)
+		require.NotContains(t, body, "/caldav/alice/")
+
+		w = do("PROPFIND", "/caldav/", "", "Depth", "1")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), `<href>/caldav/alice/</href>`)
+		require.Contains(t, w.Body.String(), `<calendar xmlns="urn:ietf:params:xml:ns:caldav"/>`)
+
+		w = do("PROPFIND", "/caldav/alice/", `<propfind xmlns="DAV:"><prop><getetag/></prop></propfind>`, "Depth", "1")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), `<href>/caldav/alice/standup@example.com.ics</href>`)
+		require.Contains(t, w.Body.String(), `<getetag xmlns="DAV:">`+text(tag)+`</getetag>`)
+
+		w = do("PROPFIND", "/caldav/bob/", "", "Depth", "0")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("calendar-query", func(t *testing.T) {
+		query := func(start, end string) string {
+			return `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+				<d:prop><d:getetag/><c:calendar-data/></d:prop>
+				<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VEVENT">
+					<c:time-range start="` + start + `" end="` + end + `"/>
+				</c:comp-filter></c:comp-filter></c:filter>
+			</c:calendar-query>`
+		}
+
+		w := do("REPORT", "/caldav/alice/", query("20210601T000000Z", "20210602T000000Z"), "Depth", "1")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), `<href>/caldav/alice/standup@example.com.ics</href>`)
+		require.Contains(t, w.Body.String(), "SUMMARY:Retro\\, daily")
+
+		w = do("REPORT", "/caldav/alice/", query("20210602T000000Z", "20210603T000000Z"), "Depth", "1")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.NotContains(t, w.Body.String(), "<response>")
+
+		todos := strings.Replace(query("20210601T000000Z", "20210602T000000Z"), `"VEVENT"`, `"VTODO"`, 1)
+		w = do("REPORT", "/caldav/alice/", todos, "Depth", "1")
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.NotContains(t, w.Body.String(), "<response>")
+	})
+
+	t.Run("calendar-multiget", func(t *testing.T) {
+		w := do("REPORT", "/caldav/alice/", `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+				<d:prop><d:getetag/></d:prop>
+				<d:href>/caldav/alice/standup%40example.com.ics</d:href>
+				<d:href>/caldav/alice/missing.ics</d:href>
+			</c:calendar-multiget>`)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		body := w.Body.String()
+		require.Contains(t, body, `<getetag xmlns="DAV:">`+text(tag)+`</getetag>`)
+		require.Contains(t, body, `<href>/caldav/alice/missing.ics</href><status>HTTP/1.1 404 Not Found</status>`)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := do(http.MethodDelete, eventPath, "", "If-Match", `"stale"`)
+		require.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		w = do(http.MethodDelete, eventPath, "", "If-Match", tag)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		w = do(http.MethodGet, eventPath, "")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+// changingApp changes the event each time after it is read, as a concurrent client would.
+type changingApp struct {
+	*app.App
+	change func(ctx context.Context, event storage.Event)
+}
+
+func (a changingApp) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	event, err := a.App.GetEvent(ctx, id)
+	if err == nil {
+		a.change(ctx, event)
+	}
+	return event, err
+}
+
+func TestConcurrentChange(t *testing.T) {
+	calendar := app.New(nopLogger{}, memorystorage.New())
+	ctx := app.ContextWithUserID(context.Background(), "alice")
+	h := NewHandler(nopLogger{}, changingApp{App: calendar, change: func(ctx context.Context, event storage.Event) {
+		event.Version = 0
+		event.Title += "!"
+		_, err := calendar.UpdateEvent(ctx, event.ID, event)
+		require.NoError(t, err)
+	}}, "/caldav/")
+	do := func(method, body string, headers ...string) int {
+		r := httptest.NewRequest(method, "/caldav/alice/standup@example.com.ics", strings.NewReader(body))
+		r = r.WithContext(ctx)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPut, standup, "Content-Type", "text/calendar"))
+	require.Equal(t, http.StatusPreconditionFailed,
+		do(http.MethodPut, standup, "Content-Type", "text/calendar", "If-Match", "*"))
+	require.Equal(t, http.StatusPreconditionFailed, do(http.MethodDelete, "", "If-Match", "*"))
+	event, err := calendar.GetEvent(ctx, "standup@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "Standup, daily!!", event.Title, "the changes made after the checks are kept")
+
+	require.Equal(t, http.StatusNoContent, do(http.MethodPut, standup, "Content-Type", "text/calendar"))
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, ""))
+}
@@ -0,0 +1,273 @@
+package caldav
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/ical"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+const (
+	dateTimeFormat = "20060102T150405Z"
+	dateFormat     = "20060102"
+	// maxLineLength is the length in octets content lines are folded at.
+	maxLineLength = 75
+)
+
+var (
+	// errInvalidData is returned for data that is not iCalendar.
+	errInvalidData = errors.New("invalid iCalendar data")
+	// errUnsupportedObject is returned for calendar objects other than a single VEVENT.
+	errUnsupportedObject = errors.New("unsupported calendar object")
+)
+
+// encodeEvent renders the event as an iCalendar object with a single VEVENT whose UID is the event ID.
+// Events keep no modification time, so DTSTAMP is the start of the event, which keeps the object
+// and its ETag the same as long as the event does not change.
+func encodeEvent(event storage.Event) []byte {
+	var buf bytes.Buffer
+	line := func(name, value string) {
+		writeLine(&buf, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//hw12_13_14_15_calendar//CalDAV//EN")
+	line("BEGIN", "VEVENT")
+	line("UID", escape(event.ID))
+	line("DTSTAMP", event.StartAt.UTC().Format(dateTimeFormat))
+	line("DTSTART", event.StartAt.UTC().Format(dateTimeFormat))
+	line("DTEND", event.EndAt.UTC().Format(dateTimeFormat))
+	line("SUMMARY", escape(event.Title))
+	if event.Description != "" {
+		line("DESCRIPTION", escape(event.Description))
+	}
+	if event.NotifyBefore > 0 {
+		line("BEGIN", "VALARM")
+		line("ACTION", "DISPLAY")
+		line("DESCRIPTION", escape(event.Title))
+		line("TRIGGER", "-"+formatDuration(event.NotifyBefore))
+		line("END", "VALARM")
+	}
+	line("END", "VEVENT")
+	line("END", "VCALENDAR")
+	return buf.Bytes()
+}
+
+// decodeEvent reads the event from an iCalendar object with a single non-recurring VEVENT.
+// Times with a TZID are read in that IANA time zone and floating times in UTC.
+// The first VALARM triggered before the start of the event sets its notification.
+// An all-day event without an end or a duration lasts one day, as RFC 5545 defines.
+func decodeEvent(r io.Reader) (storage.Event, error) {
+	lines, err := ical.Unfold(r)
+	if err != nil {
+		return storage.Event{}, fmt.Errorf("%w: %s", errInvalidData, err)
+	}
+	if len(lines) == 0 || lines[0] != "BEGIN:VCALENDAR" {
+		return storage.Event{}, fmt.Errorf("%w: no VCALENDAR", errInvalidData)
+	}
+
+	var (
+		event    storage.Event
+		events   int
+		duration time.Duration
+		allDay   bool
+		stack    []string
+	)
+	for i, line := range lines {
+		name, params, value := ical.ParseLine(line)
+		component := ""
+		if len(stack) > 0 {
+			component = stack[len(stack)-1]
+		}
+		switch {
+		case name == "BEGIN":
+			stack = append(stack, strings.ToUpper(value))
+			switch strings.ToUpper(value) {
+			case "VEVENT":
+				events++
+			case "VTODO", "VJOURNAL", "VFREEBUSY":
+				return storage.Event{}, fmt.Errorf("%w: %s", errUnsupportedObject, value)
+			}
+		case name == "END":
+			if component == "" || component != strings.ToUpper(value) {
+				return storage.Event{}, fmt.Errorf("%w: line %d: unexpected END:%s", errInvalidData, i+1, value)
+			}
+			stack = stack[:len(stack)-1]
+		case component == "VEVENT":
+			if name == "DTSTART" {
+				allDay = isDate(params, value)
+			}
+			if err := decodeProperty(&event, &duration, name, params, value); err != nil {
+				return storage.Event{}, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		case component == "VALARM" && name == "TRIGGER" && event.NotifyBefore == 0:
+			if d, err := parseDuration(value); err == nil && d < 0 && params["RELATED"] != "END" {
+				event.NotifyBefore = -d
+			}
+		}
+	}
+
+	switch {
+	case len(stack) > 0:
+		return storage.Event{}, fmt.Errorf("%w: unterminated %s", errInvalidData, stack[len(stack)-1])
+	case events != 1:
+		return storage.Event{}, fmt.Errorf("%w: %d events instead of one", errUnsupportedObject, events)
+	case event.EndAt.IsZero() && duration > 0:
+		event.EndAt = event.StartAt.Add(duration)
+	case event.EndAt.IsZero() && allDay:
+		event.EndAt = event.StartAt.AddDate(0, 0, 1)
+	}
+	return event, nil
+}
+
+func decodeProperty(event *storage.Event, duration *time.Duration, name string, params map[string]string,
+	value string) error {
+	var err error
+	switch name {
+	case "DTSTART":
+		event.StartAt, err = parseTime(params, value)
+	case "DTEND":
+		event.EndAt, err = parseTime(params, value)
+	case "DURATION":
+		*duration, err = parseDuration(value)
+	case "SUMMARY":
+		event.Title = unescape(value)
+	case "DESCRIPTION":
+		event.Description = unescape(value)
+	case "RRULE", "RDATE":
+		return fmt.Errorf("%w: recurring events", errUnsupportedObject)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", errInvalidData, name, err)
+	}
+	return nil
+}
+
+// parseTime reads a DATE or DATE-TIME value. Dates stand for midnight UTC.
+func parseTime(params map[string]string, value string) (time.Time, error) {
+	if isDate(params, value) {
+		return time.Parse(dateFormat, value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(dateTimeFormat, value)
+	}
+	location := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		var err error
+		if location, err = time.LoadLocation(strings.Trim(tzid, `"`)); err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %q", tzid)
+		}
+	}
+	t, err := time.ParseInLocation(strings.TrimSuffix(dateTimeFormat, "Z"), value, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// isDate reports whether a time value is a DATE rather than a DATE-TIME.
+func isDate(params map[string]string, value string) bool {
+	return params["VALUE"] == "DATE" || len(value) == len(dateFormat)
+}
+
+// parseDuration reads a duration such as "-PT15M" or "P1DT2H".
+func parseDuration(value string) (time.Duration, error) {
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign, value = -1, value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, fmt.Errorf("bad duration %q", value)
+	}
+
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour}
+	var d time.Duration
+	number := ""
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= '0' && c <= '9':
+			number += string(c)
+		case c == 'T' && number == "":
+			units = map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+		default:
+			unit, ok := units[c]
+			n, err := strconv.Atoi(number)
+			if !ok || err != nil {
+				return 0, fmt.Errorf("bad duration %q", value)
+			}
+			d += time.Duration(n) * unit
+			number = ""
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("bad duration %q", value)
+	}
+	return sign * d, nil
+}
+
+// formatDuration writes a positive duration in whole seconds, such as "PT1H30M".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+
+	var b strings.Builder
+	b.WriteString("P")
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if d > 0 || days == 0 {
+		b.WriteString("T")
+		if h := d / time.Hour; h > 0 {
+			fmt.Fprintf(&b, "%dH", h)
+		}
+		if m := d % time.Hour / time.Minute; m > 0 {
+			fmt.Fprintf(&b, "%dM", m)
+		}
+		if s := d % time.Minute / time.Second; s > 0 || d < time.Minute {
+			fmt.Fprintf(&b, "%dS", s)
+		}
+	}
+	return b.String()
+}
+
+// writeLine writes a content line folded at maxLineLength octets, without splitting UTF-8 characters.
+func writeLine(buf *bytes.Buffer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !isRuneStart(line[i]) {
+			i--
+		}
+		buf.WriteString(line[:i])
+		buf.WriteString("\r\n ")
+		line = line[i:]
+		// Continuation lines start with a space.
+		limit = maxLineLength - 1
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+func unescape(text string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(text)
+}
@@ -0,0 +1,163 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+)
+
+var (
+	propResourceType       = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName        = xml.Name{Space: nsDAV, Local: "displayname"}
+	propCurrentPrincipal   = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL       = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propPrivileges         = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propETag               = xml.Name{Space: nsDAV, Local: "getetag"}
+	propContentType        = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propCalendarHome       = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+	propSupportedCompSet   = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData       = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+	reportCalendarQuery    = xml.Name{Space: nsCalDAV, Local: "calendar-query"}
+	reportCalendarMultiget = xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}
+)
+
+// propfind is the body of a PROPFIND request; an empty body asks for all properties.
+type propfind struct {
+	XMLName xml.Name   `xml:"DAV: propfind"`
+	AllProp *struct{}  `xml:"DAV: allprop"`
+	Prop    *propNames `xml:"DAV: prop"`
+}
+
+type propNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+func (p *propNames) names() []xml.Name {
+	if p == nil {
+		return nil
+	}
+	names := make([]xml.Name, 0, len(p.Names))
+	for _, n := range p.Names {
+		names = append(names, n.XMLName)
+	}
+	return names
+}
+
+// report is the body of a calendar-query or calendar-multiget REPORT request.
+type report struct {
+	XMLName xml.Name
+	Prop    *propNames `xml:"DAV: prop"`
+	Hrefs   []string   `xml:"DAV: href"`
+	Filter  *struct {
+		CompFilter compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type compFilter struct {
+	Name         string       `xml:"name,attr"`
+	IsNotDefined *struct{}    `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	CompFilters  []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters  []struct{}   `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"response"`
+}
+
+type response struct {
+	Href      string     `xml:"href"`
+	Status    string     `xml:"status,omitempty"`
+	Propstats []propstat `xml:"propstat"`
+}
+
+type propstat struct {
+	Prop   innerXML `xml:"prop"`
+	Status string   `xml:"status"`
+}
+
+type innerXML struct {
+	XML string `xml:",innerxml"`
+}
+
+// newResponse reports the properties of the resource at href: the requested ones or,
+// if names is nil, all of them.
+func newResponse(href string, props map[xml.Name]string, names []xml.Name) response {
+	var found, missing bytes.Buffer
+	if names == nil {
+		for _, name := range sortedNames(props) {
+			if name != propCalendarData {
+				writeProp(&found, name, props[name])
+			}
+		}
+	}
+	for _, name := range names {
+		if value, ok := props[name]; ok {
+			writeProp(&found, name, value)
+		} else {
+			writeProp(&missing, name, "")
+		}
+	}
+
+	resp := response{Href: href}
+	if found.Len() > 0 || missing.Len() == 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Prop: innerXML{found.String()}, Status: status(http.StatusOK)})
+	}
+	if missing.Len() > 0 {
+		resp.Propstats = append(resp.Propstats,
+			propstat{Prop: innerXML{missing.String()}, Status: status(http.StatusNotFound)})
+	}
+	return resp
+}
+
+// writeProp writes a property whose value is XML already.
+func writeProp(buf *bytes.Buffer, name xml.Name, value string) {
+	var space bytes.Buffer
+	xml.EscapeText(&space, []byte(name.Space))
+	fmt.Fprintf(buf, `<%s xmlns="%s">%s</%[1]s>`, name.Local, space.String(), value)
+}
+
+// text escapes a property value.
+func text(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
+
+func href(value string) string {
+	return `<href xmlns="DAV:">` + text(value) + `</href>`
+}
+
+func status(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+// sortedNames returns the names of props in a stable order.
+func sortedNames(props map[xml.Name]string) []xml.Name {
+	names := make([]xml.Name, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+	return names
+}
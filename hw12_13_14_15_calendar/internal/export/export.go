@@ -0,0 +1,118 @@
+// Package export writes events as CSV or JSON Lines for analysis in other tools.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// header is the first row of CSV exports.
+var header = []string{
+	"id", "user_id", "calendar_id", "title", "start_at", "end_at", "notify_before_seconds", "description",
+}
+
+// Writer writes events one by one. Output is buffered until Flush.
+type Writer interface {
+	Write(event *eventpb.Event) error
+	Flush() error
+}
+
+// NewWriter returns a writer of the format, FormatCSV or FormatJSONL.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatJSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q, want %s or %s", format, FormatCSV, FormatJSONL)
+	}
+}
+
+// ContentType returns the media type of the format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// csvWriter writes the header before the first event; times are RFC 3339 in UTC.
+// Text cells are escaped with cell, as the files are meant to be opened in spreadsheets.
+type csvWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func (c *csvWriter) Write(event *eventpb.Event) error {
+	if !c.started {
+		c.started = true
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{
+		cell(event.GetId()),
+		cell(event.GetUserId()),
+		cell(event.GetCalendarId()),
+		cell(event.GetTitle()),
+		event.GetStartAt().AsTime().Format(time.RFC3339),
+		event.GetEndAt().AsTime().Format(time.RFC3339),
+		strconv.FormatInt(int64(event.GetNotifyBefore().AsDuration().Seconds()), 10),
+		cell(event.GetDescription()),
+	})
+}
+
+// cell prefixes text a spreadsheet would take for a formula with a quote, so that
+// a title such as "=HYPERLINK(...)" is shown as is instead of being evaluated.
+func cell(text string) string {
+	if text != "" && strings.ContainsAny(text[:1], "=+-@\t\r") {
+		return "'" + text
+	}
+	return text
+}
+
+// Flush writes the header even if there were no events, so an empty export is still valid CSV.
+func (c *csvWriter) Flush() error {
+	if !c.started {
+		c.started = true
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes every event as a JSON object on its own line, as the HTTP API returns it.
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonlWriter) Write(event *eventpb.Event) error {
+	data, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
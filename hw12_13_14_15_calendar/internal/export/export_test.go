@@ -0,0 +1,85 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var events = []*eventpb.Event{
+	{
+		Id:           "1",
+		UserId:       "alice",
+		CalendarId:   "team",
+		Title:        "standup, daily",
+		StartAt:      timestamppb.New(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)),
+		EndAt:        timestamppb.New(time.Date(2021, 6, 1, 10, 15, 0, 0, time.UTC)),
+		NotifyBefore: durationpb.New(5 * time.Minute),
+		Description:  "line 1\n\"line 2\"",
+	},
+	{
+		Id:      "2",
+		UserId:  "bob",
+		Title:   "lunch",
+		StartAt: timestamppb.New(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)),
+		EndAt:   timestamppb.New(time.Date(2021, 6, 1, 13, 0, 0, 0, time.UTC)),
+	},
+}
+
+func write(t *testing.T, format string, events []*eventpb.Event) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	require.NoError(t, err)
+	for _, event := range events {
+		require.NoError(t, w.Write(event))
+	}
+	require.NoError(t, w.Flush())
+	return buf.String()
+}
+
+func TestCSV(t *testing.T) {
+	require.Equal(t, "id,user_id,calendar_id,title,start_at,end_at,notify_before_seconds,description\n"+
+		"1,alice,team,\"standup, daily\",2021-06-01T10:00:00Z,2021-06-01T10:15:00Z,300,\"line 1\n\"\"line 2\"\"\"\n"+
+		"2,bob,,lunch,2021-06-01T12:00:00Z,2021-06-01T13:00:00Z,0,\n",
+		write(t, FormatCSV, events))
+	require.Equal(t, strings.Join(header, ",")+"\n", write(t, FormatCSV, nil), "empty exports have a header")
+
+	formulas := []*eventpb.Event{{
+		Id:          "@standup",
+		UserId:      "alice",
+		Title:       `=HYPERLINK("http://example.com","standup")`,
+		StartAt:     timestamppb.New(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)),
+		EndAt:       timestamppb.New(time.Date(2021, 6, 1, 10, 15, 0, 0, time.UTC)),
+		Description: "\t-1+2",
+	}}
+	require.Equal(t, strings.Join(header, ",")+"\n"+
+		"'@standup,alice,,\"'=HYPERLINK(\"\"http://example.com\"\",\"\"standup\"\")\","+
+		"2021-06-01T10:00:00Z,2021-06-01T10:15:00Z,0,'\t-1+2\n",
+		write(t, FormatCSV, formulas), "cells starting like formulas are quoted")
+}
+
+func TestJSONL(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(write(t, FormatJSONL, events), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	var event struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	require.Equal(t, "1", event.ID)
+	require.Equal(t, "line 1\n\"line 2\"", event.Description)
+	require.Empty(t, write(t, FormatJSONL, nil))
+
+	_, err := NewWriter(&bytes.Buffer{}, "xml")
+	require.Error(t, err)
+}
@@ -0,0 +1,129 @@
+// Package holiday reads holiday calendars from iCalendar (.ics) files.
+package holiday
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/ical"
+)
+
+// maxDays limits the length of a single holiday event, to catch broken files.
+const maxDays = 366
+
+var ErrInvalidICS = errors.New("invalid iCalendar data")
+
+// Holiday is a day off. Date is midnight UTC of the day, which is a holiday in every time zone.
+type Holiday struct {
+	Date time.Time
+	Name string
+}
+
+// Calendar is a named set of holidays, such as the public holidays of a country.
+type Calendar struct {
+	Name     string
+	Holidays []Holiday
+	days     map[time.Time]string
+}
+
+// New returns a calendar with the given holidays.
+func New(name string, holidays []Holiday) *Calendar {
+	c := &Calendar{Name: name, Holidays: holidays, days: make(map[time.Time]string, len(holidays))}
+	for _, h := range holidays {
+		c.days[h.Date] = h.Name
+	}
+	return c
+}
+
+// Lookup reports whether the day of t in its location is a holiday and returns its name.
+func (c *Calendar) Lookup(t time.Time) (string, bool) {
+	year, month, day := t.Date()
+	name, ok := c.days[time.Date(year, month, day, 0, 0, 0, 0, time.UTC)]
+	return name, ok
+}
+
+// ParseICS reads the holidays from the events of an iCalendar file. Every day an event covers
+// is a holiday named after the summary of the event. Recurring events are not supported.
+func ParseICS(r io.Reader) ([]Holiday, error) {
+	lines, err := ical.Unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	holidays := make([]Holiday, 0)
+	var (
+		inEvent    bool
+		start, end time.Time
+		summary    string
+	)
+	for i, line := range lines {
+		name, params, value := ical.ParseLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			inEvent, start, end, summary = true, time.Time{}, time.Time{}, ""
+		case !inEvent:
+			continue
+		case name == "END" && value == "VEVENT":
+			inEvent = false
+			days, err := expand(start, end)
+			if err != nil {
+				return nil, fmt.Errorf("%w: event %q ending on line %d: %s", ErrInvalidICS, summary, i+1, err)
+			}
+			for _, day := range days {
+				holidays = append(holidays, Holiday{Date: day, Name: summary})
+			}
+		case name == "DTSTART":
+			if start, err = parseDate(params, value); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidICS, i+1, err)
+			}
+		case name == "DTEND":
+			if end, err = parseDate(params, value); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidICS, i+1, err)
+			}
+		case name == "SUMMARY":
+			summary = unescape(value)
+		case name == "RRULE":
+			return nil, fmt.Errorf("%w: line %d: recurring events are not supported", ErrInvalidICS, i+1)
+		}
+	}
+	if inEvent {
+		return nil, fmt.Errorf("%w: unterminated event", ErrInvalidICS)
+	}
+	return holidays, nil
+}
+
+// parseDate reads the day of a DATE or DATE-TIME value; the time of day is ignored.
+func parseDate(params map[string]string, value string) (time.Time, error) {
+	if len(value) < 8 || (params["VALUE"] != "" && params["VALUE"] != "DATE" && params["VALUE"] != "DATE-TIME") {
+		return time.Time{}, fmt.Errorf("bad date %q", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// expand returns the days from start to end, excluding end as iCalendar does.
+// An event without an end lasts one day.
+func expand(start, end time.Time) ([]time.Time, error) {
+	switch {
+	case start.IsZero():
+		return nil, errors.New("no start date")
+	case end.IsZero() || end.Equal(start):
+		end = start.AddDate(0, 0, 1)
+	case end.Before(start):
+		return nil, errors.New("end date before start date")
+	case end.Sub(start) > maxDays*24*time.Hour:
+		return nil, fmt.Errorf("longer than %d days", maxDays)
+	}
+
+	days := make([]time.Time, 0, 1)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func unescape(text string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(text)
+}
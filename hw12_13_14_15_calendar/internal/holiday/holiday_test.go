@@ -0,0 +1,69 @@
+package holiday
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const ics = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20210101\r\n" +
+	"DTEND;VALUE=DATE:20210102\r\n" +
+	"SUMMARY:New Year\\, Day\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20211224\r\n" +
+	"DTEND;VALUE=DATE:20211227\r\n" +
+	"SUMMARY:Christmas \r\n" +
+	" Holidays\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20210501T000000Z\r\n" +
+	"SUMMARY:Labour Day\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseICS(t *testing.T) {
+	holidays, err := ParseICS(strings.NewReader(ics))
+	require.NoError(t, err)
+	require.Equal(t, []Holiday{
+		{Date: date(2021, 1, 1), Name: "New Year, Day"},
+		{Date: date(2021, 12, 24), Name: "Christmas Holidays"},
+		{Date: date(2021, 12, 25), Name: "Christmas Holidays"},
+		{Date: date(2021, 12, 26), Name: "Christmas Holidays"},
+		{Date: date(2021, 5, 1), Name: "Labour Day"},
+	}, holidays)
+
+	for name, src := range map[string]string{
+		"recurring":    "BEGIN:VEVENT\nDTSTART;VALUE=DATE:20210101\nRRULE:FREQ=YEARLY\nEND:VEVENT\n",
+		"no start":     "BEGIN:VEVENT\nSUMMARY:x\nEND:VEVENT\n",
+		"bad date":     "BEGIN:VEVENT\nDTSTART;VALUE=DATE:2021\nEND:VEVENT\n",
+		"reversed":     "BEGIN:VEVENT\nDTSTART;VALUE=DATE:20210102\nDTEND;VALUE=DATE:20210101\nEND:VEVENT\n",
+		"unterminated": "BEGIN:VEVENT\nDTSTART;VALUE=DATE:20210101\n",
+	} {
+		_, err := ParseICS(strings.NewReader(src))
+		require.ErrorIs(t, err, ErrInvalidICS, name)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	holidays, err := ParseICS(strings.NewReader(ics))
+	require.NoError(t, err)
+	c := New("de", holidays)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	name, ok := c.Lookup(time.Date(2021, 1, 1, 0, 30, 0, 0, berlin))
+	require.True(t, ok, "holidays are checked in the time zone of the time")
+	require.Equal(t, "New Year, Day", name)
+	_, ok = c.Lookup(time.Date(2020, 12, 31, 23, 30, 0, 0, time.UTC))
+	require.False(t, ok)
+}
@@ -0,0 +1,44 @@
+// Package ical reads the content lines of iCalendar (RFC 5545) data, leaving the meaning
+// of components and properties to its users.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Unfold reads the content lines of r, joining the lines split over several physical lines.
+// Empty lines are skipped.
+func Unfold(r io.Reader) ([]string, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// ParseLine splits "NAME;PARAM=VALUE:value" into its parts. Names of the property
+// and its parameters are upper-cased, as they are case-insensitive.
+func ParseLine(line string) (name string, params map[string]string, value string) {
+	head, value := line, ""
+	if i := strings.Index(line, ":"); i >= 0 {
+		head, value = line[:i], line[i+1:]
+	}
+	parts := strings.Split(head, ";")
+	params = make(map[string]string, len(parts)-1)
+	for _, param := range parts[1:] {
+		if i := strings.Index(param, "="); i >= 0 {
+			params[strings.ToUpper(param[:i])] = param[i+1:]
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
@@ -0,0 +1,26 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnfold(t *testing.T) {
+	lines, err := Unfold(strings.NewReader("BEGIN:VEVENT\r\nSUMMARY:Stand\r\n up\r\n\r\nDESCRIPTION:a\n\tb\nEND:VEVENT"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"BEGIN:VEVENT", "SUMMARY:Standup", "DESCRIPTION:ab", "END:VEVENT"}, lines)
+}
+
+func TestParseLine(t *testing.T) {
+	name, params, value := ParseLine(`dtstart;tzid=Europe/Berlin;VALUE=DATE-TIME:20210601T100000`)
+	require.Equal(t, "DTSTART", name)
+	require.Equal(t, map[string]string{"TZID": "Europe/Berlin", "VALUE": "DATE-TIME"}, params)
+	require.Equal(t, "20210601T100000", value)
+
+	name, params, value = ParseLine("DESCRIPTION:time: 10:00")
+	require.Equal(t, "DESCRIPTION", name)
+	require.Empty(t, params)
+	require.Equal(t, "time: 10:00", value)
+}
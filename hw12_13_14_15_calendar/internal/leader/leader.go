@@ -0,0 +1,132 @@
+// Package leader elects a single replica to run a background job using leases kept in the storage.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Lease is implemented by storages shared by the replicas.
+type Lease interface {
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+type Logger interface {
+	Info(msg string)
+	Error(msg string)
+}
+
+// Elector contends for a named lease with the replicas running the same job.
+// The lease is held for ttl and renewed every ttl/3, so the job moves to another replica
+// within ttl and ttl/3 after its leader fails, and at once after it stops gracefully.
+type Elector struct {
+	logger Logger
+	lease  Lease
+	name   string
+	holder string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// New returns an elector for the lease with the given name; holder must be unique among the replicas.
+func New(logger Logger, lease Lease, name, holder string, ttl time.Duration) *Elector {
+	return &Elector{
+		logger: logger,
+		lease:  lease,
+		name:   name,
+		holder: holder,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Run calls fn every time the lease is acquired until ctx is done. The context of fn is canceled
+// when the lease is lost, and fn must return promptly then, as another replica may already lead.
+// The lease is released when fn returns.
+func (e *Elector) Run(ctx context.Context, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		start := e.now()
+		ok, err := e.acquire(ctx)
+		switch {
+		case err != nil:
+			e.logger.Error(fmt.Sprintf("failed to acquire lease %s: %s", e.name, err))
+		case ok:
+			e.logger.Info(fmt.Sprintf("acquired lease %s as %s", e.name, e.holder))
+			e.lead(ctx, ticker, start.Add(e.ttl), fn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs fn while renewing the lease acquired until expiresAt.
+// The lease is considered lost once a renewal fails to extend it before it expires.
+func (e *Elector) lead(ctx context.Context, ticker *time.Ticker, expiresAt time.Time, fn func(ctx context.Context)) {
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leadCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		e.release()
+	}()
+
+	timer := time.NewTimer(expiresAt.Sub(e.now()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			e.logger.Error(fmt.Sprintf("lost lease %s: it expired before renewal", e.name))
+			return
+		case <-ticker.C:
+		}
+
+		start := e.now()
+		ok, err := e.acquire(leadCtx)
+		switch {
+		case err != nil:
+			// Retried on the next tick while the lease has not expired.
+			e.logger.Error(fmt.Sprintf("failed to renew lease %s: %s", e.name, err))
+		case !ok:
+			e.logger.Error(fmt.Sprintf("lost lease %s: it is held by another replica", e.name))
+			return
+		default:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(start.Add(e.ttl).Sub(e.now()))
+		}
+	}
+}
+
+// acquire takes or renews the lease, giving up once a third of its time has passed.
+func (e *Elector) acquire(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.ttl/3)
+	defer cancel()
+	return e.lease.AcquireLease(ctx, e.name, e.holder, e.ttl)
+}
+
+// release frees the lease so another replica takes over without waiting for it to expire.
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	defer cancel()
+	if err := e.lease.ReleaseLease(ctx, e.name, e.holder); err != nil {
+		e.logger.Error(fmt.Sprintf("failed to release lease %s: %s", e.name, err))
+		return
+	}
+	e.logger.Info(fmt.Sprintf("released lease %s", e.name))
+}
@@ -0,0 +1,144 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+const ttl = 30 * time.Millisecond
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+
+// failingLease grants the lease once and then fails to reach the storage.
+type failingLease struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (l *failingLease) AcquireLease(context.Context, string, string, time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	if l.calls > 1 {
+		return false, errors.New("unavailable")
+	}
+	return true, nil
+}
+
+func (l *failingLease) ReleaseLease(context.Context, string, string) error {
+	return nil
+}
+
+func TestElector(t *testing.T) {
+	t.Run("single leader with takeover", func(t *testing.T) {
+		storage := memorystorage.New()
+		var (
+			mu      sync.Mutex
+			leaders = make(map[string]bool)
+			overlap bool
+		)
+		job := func(holder string) func(ctx context.Context) {
+			return func(ctx context.Context) {
+				mu.Lock()
+				overlap = overlap || len(leaders) > 0
+				leaders[holder] = true
+				mu.Unlock()
+
+				<-ctx.Done()
+
+				mu.Lock()
+				delete(leaders, holder)
+				mu.Unlock()
+			}
+		}
+		leader := func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			for holder := range leaders {
+				return holder
+			}
+			return ""
+		}
+
+		ctxA, cancelA := context.WithCancel(context.Background())
+		ctxB, cancelB := context.WithCancel(context.Background())
+		defer cancelB()
+		stoppedA := make(chan struct{})
+		go func() {
+			defer close(stoppedA)
+			New(nopLogger{}, storage, "reminders", "a", ttl).Run(ctxA, job("a"))
+		}()
+		require.Eventually(t, func() bool { return leader() == "a" }, time.Second, time.Millisecond)
+		go New(nopLogger{}, storage, "reminders", "b", ttl).Run(ctxB, job("b"))
+
+		time.Sleep(3 * ttl)
+		require.Equal(t, "a", leader(), "the leader keeps renewing its lease")
+
+		cancelA()
+		<-stoppedA
+		require.Eventually(t, func() bool { return leader() == "b" }, 2*ttl, time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.False(t, overlap, "only one replica leads at a time")
+	})
+
+	t.Run("lease taken over", func(t *testing.T) {
+		storage := memorystorage.New()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lost := make(chan struct{})
+		go New(nopLogger{}, storage, "reminders", "a", ttl).Run(ctx, func(ctx context.Context) {
+			<-ctx.Done()
+			select {
+			case <-lost:
+			default:
+				close(lost)
+			}
+		})
+		require.Eventually(t, func() bool {
+			ok, err := storage.AcquireLease(ctx, "reminders", "b", ttl)
+			require.NoError(t, err)
+			return !ok
+		}, time.Second, time.Millisecond)
+
+		// Simulates the lease expiring while the leader is paused.
+		require.NoError(t, storage.ReleaseLease(ctx, "reminders", "a"))
+		ok, err := storage.AcquireLease(ctx, "reminders", "b", time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		select {
+		case <-lost:
+		case <-time.After(time.Second):
+			require.Fail(t, "the job is stopped when the lease is lost")
+		}
+	})
+
+	t.Run("renewal fails", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stopped := make(chan struct{})
+		go New(nopLogger{}, &failingLease{}, "reminders", "a", ttl).Run(ctx, func(ctx context.Context) {
+			<-ctx.Done()
+			close(stopped)
+		})
+
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			require.Fail(t, "the job is stopped when the lease cannot be renewed")
+		}
+	})
+}
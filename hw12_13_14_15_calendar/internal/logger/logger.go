@@ -1,20 +1,70 @@
 package logger
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
-type Logger struct { // TODO
+// Levels in order of severity; messages below the level of the logger are dropped.
+const (
+	LevelInfo  = "INFO"
+	LevelError = "ERROR"
+)
+
+var levels = map[string]int32{LevelInfo: 0, LevelError: 1}
+
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level int32
 }
 
+// New returns a logger writing to stdout. An unknown level falls back to INFO.
 func New(level string) *Logger {
-	return &Logger{}
+	l := &Logger{out: os.Stdout}
+	_ = l.SetLevel(level)
+	return l
+}
+
+// SetLevel changes the level at runtime; it is safe to call while logging.
+func (l *Logger) SetLevel(level string) error {
+	value, ok := levels[strings.ToUpper(level)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	atomic.StoreInt32(&l.level, value)
+	return nil
 }
 
-func (l Logger) Info(msg string) {
-	fmt.Println(msg)
+// Level returns the current level.
+func (l *Logger) Level() string {
+	level := atomic.LoadInt32(&l.level)
+	for name, value := range levels {
+		if value == level {
+			return name
+		}
+	}
+	return LevelInfo
 }
 
-func (l Logger) Error(msg string) {
-	// TODO
+func (l *Logger) Info(msg string) {
+	l.log(LevelInfo, msg)
 }
 
-// TODO
+func (l *Logger) Error(msg string) {
+	l.log(LevelError, msg)
+}
+
+func (l *Logger) log(level, msg string) {
+	if levels[level] < atomic.LoadInt32(&l.level) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(l.out, msg)
+}
@@ -1,7 +1,29 @@
 package logger
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
 
 func TestLogger(t *testing.T) {
-	// TODO
+	var out bytes.Buffer
+	l := New("info")
+	l.out = &out
+	require.Equal(t, LevelInfo, l.Level())
+
+	l.Info("started")
+	l.Error("failed")
+	require.Equal(t, "started\nfailed\n", out.String())
+
+	require.NoError(t, l.SetLevel("ERROR"))
+	require.Equal(t, LevelError, l.Level())
+	out.Reset()
+	l.Info("started")
+	l.Error("failed")
+	require.Equal(t, "failed\n", out.String())
+
+	require.Error(t, l.SetLevel("verbose"))
+	require.Equal(t, LevelError, l.Level(), "an unknown level keeps the current one")
 }
@@ -0,0 +1,184 @@
+// Package markdown renders the Markdown descriptions of events to HTML that is safe to embed in a page.
+//
+// Only a subset is supported: paragraphs, headings, bullet and numbered lists, quotes, fenced code,
+// inline code, bold and italic text, links and bare URLs. Everything else, raw HTML included,
+// is rendered as escaped text, and links are kept only for http, https and mailto URLs.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	heading  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bullet   = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	numbered = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	quote    = regexp.MustCompile(`^>\s?(.*)$`)
+	inlines  = regexp.MustCompile("(`[^`]+`)" +
+		`|\*\*([^*]+)\*\*` +
+		`|\*([^*\s][^*]*)\*` +
+		`|\[([^\]]+)\]\(([^)\s]+)\)` +
+		`|(https?://[^\s<>"]+)`)
+)
+
+// ToHTML renders src to sanitized HTML.
+func ToHTML(src string) string {
+	r := renderer{}
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			r.flush()
+			code := make([]string, 0)
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			r.out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+			continue
+		}
+
+		switch m := match(trimmed); {
+		case trimmed == "":
+			r.flush()
+		case m.re == heading:
+			r.flush()
+			level := string(rune('0' + len(m.groups[1])))
+			r.out.WriteString("<h" + level + ">" + inline(m.groups[2]) + "</h" + level + ">\n")
+		case m.re == bullet:
+			r.item("ul", m.groups[1])
+		case m.re == numbered:
+			r.item("ol", m.groups[1])
+		case m.re == quote:
+			r.block("blockquote", m.groups[1])
+		default:
+			if r.list != "" && line != trimmed {
+				// An indented line continues the list item.
+				r.lines = append(r.lines, trimmed)
+				continue
+			}
+			r.block("p", trimmed)
+		}
+	}
+	r.flush()
+	return r.out.String()
+}
+
+type lineMatch struct {
+	re     *regexp.Regexp
+	groups []string
+}
+
+func match(line string) lineMatch {
+	for _, re := range []*regexp.Regexp{heading, bullet, numbered, quote} {
+		if groups := re.FindStringSubmatch(line); groups != nil {
+			return lineMatch{re: re, groups: groups}
+		}
+	}
+	return lineMatch{}
+}
+
+// renderer collects the lines of the current paragraph, quote or list item.
+type renderer struct {
+	out   strings.Builder
+	tag   string
+	list  string
+	lines []string
+}
+
+func (r *renderer) block(tag, line string) {
+	if r.tag != tag || r.list != "" {
+		r.flush()
+		r.tag = tag
+	}
+	r.lines = append(r.lines, line)
+}
+
+func (r *renderer) item(list, line string) {
+	if r.list != list {
+		r.flush()
+		r.list = list
+		r.out.WriteString("<" + list + ">\n")
+	} else {
+		r.flushItem()
+	}
+	r.tag = "li"
+	r.lines = append(r.lines, line)
+}
+
+func (r *renderer) flushItem() {
+	if len(r.lines) > 0 {
+		r.out.WriteString("<" + r.tag + ">" + inline(strings.Join(r.lines, "\n")) + "</" + r.tag + ">\n")
+	}
+	r.lines = r.lines[:0]
+}
+
+func (r *renderer) flush() {
+	r.flushItem()
+	if r.list != "" {
+		r.out.WriteString("</" + r.list + ">\n")
+		r.list = ""
+	}
+	r.tag = ""
+}
+
+// inline renders the text of a block, escaping everything that is not markup.
+func inline(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range inlines.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		last = m[1]
+		group := func(i int) string {
+			if m[2*i] < 0 {
+				return ""
+			}
+			return text[m[2*i]:m[2*i+1]]
+		}
+
+		switch {
+		case m[2] >= 0:
+			code := group(1)
+			b.WriteString("<code>" + html.EscapeString(code[1:len(code)-1]) + "</code>")
+		case m[4] >= 0:
+			b.WriteString("<strong>" + inline(group(2)) + "</strong>")
+		case m[6] >= 0:
+			b.WriteString("<em>" + inline(group(3)) + "</em>")
+		case m[8] >= 0:
+			b.WriteString(link(group(5), html.EscapeString(group(4))))
+		default:
+			u := strings.TrimRight(group(6), ".,;:!?)")
+			last = m[12] + len(u)
+			b.WriteString(link(u, html.EscapeString(u)))
+		}
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// link renders an anchor to u with the given HTML content, or only the content if u is not safe.
+func link(u, content string) string {
+	if !SafeURL(u) {
+		return content
+	}
+	return `<a href="` + html.EscapeString(u) + `" rel="nofollow noopener noreferrer">` + content + `</a>`
+}
+
+// SafeURL reports whether u is an absolute http or https URL with a host, or a mailto one.
+func SafeURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.Host != ""
+	case "mailto":
+		return parsed.Opaque != ""
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "paragraphs",
+			src:  "first line\nsecond line\n\nnext paragraph",
+			want: "<p>first line\nsecond line</p>\n<p>next paragraph</p>\n",
+		},
+		{
+			name: "heading and emphasis",
+			src:  "## Agenda\n**bold** and *italic* and `a < b`",
+			want: "<h2>Agenda</h2>\n<p><strong>bold</strong> and <em>italic</em> and <code>a &lt; b</code></p>\n",
+		},
+		{
+			name: "lists",
+			src:  "- one\n  continued\n- two\n1. first\n2. second\nafter",
+			want: "<ul>\n<li>one\ncontinued</li>\n<li>two</li>\n</ul>\n" +
+				"<ol>\n<li>first</li>\n<li>second</li>\n</ol>\n<p>after</p>\n",
+		},
+		{
+			name: "quote and code",
+			src:  "> quoted\n```\n<b>raw</b>\n```",
+			want: "<blockquote>quoted</blockquote>\n<pre><code>&lt;b&gt;raw&lt;/b&gt;</code></pre>\n",
+		},
+		{
+			name: "links",
+			src:  "see [the doc](https://example.com/doc?a=1&b=2) or https://example.com/x.",
+			want: `<p>see <a href="https://example.com/doc?a=1&amp;b=2" rel="nofollow noopener noreferrer">the doc</a>` +
+				` or <a href="https://example.com/x" rel="nofollow noopener noreferrer">https://example.com/x</a>.</p>` + "\n",
+		},
+		{
+			name: "raw html",
+			src:  `<script>alert("x")</script><img src=x onerror=alert(1)>`,
+			want: "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;&lt;img src=x onerror=alert(1)&gt;</p>\n",
+		},
+		{
+			name: "unsafe links",
+			src:  "[click](javascript:alert(1)) [data](data:text/html,x) [rel](/local)",
+			want: "<p>click) data rel</p>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ToHTML(tt.src))
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	for _, u := range []string{"https://example.com", "http://example.com/a?b#c", "mailto:alice@example.com"} {
+		require.True(t, SafeURL(u), u)
+	}
+	unsafe := []string{"javascript:alert(1)", "JavaScript:alert(1)", "data:text/html,x", "/local", "https://", "mailto:"}
+	for _, u := range unsafe {
+		require.False(t, SafeURL(u), u)
+	}
+}
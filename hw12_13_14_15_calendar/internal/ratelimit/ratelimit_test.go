@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("burst then refill", func(t *testing.T) {
+		now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		l := New(2, 3)
+		l.now = func() time.Time { return now }
+
+		for i := 0; i < 3; i++ {
+			ok, _ := l.Allow("user-1")
+			require.True(t, ok)
+		}
+
+		ok, retryAfter := l.Allow("user-1")
+		require.False(t, ok)
+		require.Equal(t, 500*time.Millisecond, retryAfter)
+
+		now = now.Add(retryAfter)
+		ok, _ = l.Allow("user-1")
+		require.True(t, ok)
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		l := New(1, 1)
+
+		ok, _ := l.Allow("user-1")
+		require.True(t, ok)
+		ok, _ = l.Allow("user-1")
+		require.False(t, ok)
+
+		ok, _ = l.Allow("user-2")
+		require.True(t, ok)
+	})
+
+	t.Run("idle buckets are swept", func(t *testing.T) {
+		now := time.Now()
+		l := New(10, 10)
+		l.now = func() time.Time { return now }
+
+		l.Allow("user-1")
+		require.Len(t, l.buckets, 1)
+
+		now = now.Add(sweepInterval)
+		l.Allow("user-2")
+		require.Len(t, l.buckets, 1)
+		require.Contains(t, l.buckets, "user-2")
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		l := New(0.001, 50)
+
+		var mu sync.Mutex
+		allowed := 0
+		wg := sync.WaitGroup{}
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if ok, _ := l.Allow("user-1"); ok {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, 50, allowed)
+	})
+}
@@ -0,0 +1,66 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDeadLettered is matched by the errors of notifications that could not be delivered
+// and were put into the dead-letter queue instead, so there is no point in sending them again.
+var ErrDeadLettered = errors.New("dead-lettered")
+
+type deadLetteredError struct {
+	err error
+}
+
+func (e deadLetteredError) Error() string {
+	return e.err.Error()
+}
+
+func (e deadLetteredError) Unwrap() error {
+	return e.err
+}
+
+func (e deadLetteredError) Is(target error) bool {
+	return target == ErrDeadLettered
+}
+
+// DeadLetter is a notification that could not be delivered.
+type DeadLetter struct {
+	Notification Notification
+	Backend      string
+	Attempts     int
+	Err          string
+	At           time.Time
+}
+
+// DeadLetterQueue keeps undeliverable notifications for inspection or redelivery.
+type DeadLetterQueue interface {
+	Put(ctx context.Context, letter DeadLetter) error
+}
+
+// MemoryDeadLetters is a DeadLetterQueue kept in memory.
+type MemoryDeadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func (q *MemoryDeadLetters) Put(_ context.Context, letter DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.letters = append(q.letters, letter)
+	return nil
+}
+
+// List returns the dead letters in the order they were put.
+func (q *MemoryDeadLetters) List() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := make([]DeadLetter, len(q.letters))
+	copy(letters, q.letters)
+	return letters
+}
@@ -0,0 +1,71 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy configures redelivery of failed notifications with exponential backoff.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of delivery attempts, at least one.
+	MaxAttempts int
+	// BaseBackoff is the pause after the first failed attempt, doubled after each next one.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the pause between attempts if positive.
+	MaxBackoff time.Duration
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks a delivery failure that retrying would not fix.
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// do calls send until it succeeds, fails permanently, attempts are exhausted or ctx is done.
+// It returns the number of attempts made and the last error.
+func (p RetryPolicy) do(ctx context.Context, send func(ctx context.Context) error) (int, error) {
+	attempt := 0
+	for {
+		attempt++
+		err := send(ctx)
+		if err == nil {
+			return attempt, nil
+		}
+
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.BaseBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return backoff
+}
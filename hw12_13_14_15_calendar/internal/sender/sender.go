@@ -0,0 +1,24 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoRecipient is returned by backends when the user has no address configured for them.
+var ErrNoRecipient = errors.New("no recipient configured for user")
+
+// Notification is a reminder about an upcoming event. It is not stored,
+// the scheduler puts it into the queue and the sender delivers it.
+type Notification struct {
+	EventID string    `json:"eventId"`
+	Title   string    `json:"title"`
+	StartAt time.Time `json:"startAt"`
+	UserID  string    `json:"userId"`
+}
+
+// Backend delivers notifications to users through a particular channel.
+type Backend interface {
+	Send(ctx context.Context, n Notification) error
+}
@@ -0,0 +1,262 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+const (
+	DefaultSubjectTemplate = `Reminder: {{.Title}}`
+	DefaultBodyTemplate    = `"{{.Title}}" starts at {{.StartAt.Format "2006-01-02 15:04 MST"}}.`
+)
+
+// TLS modes of the SMTP backend.
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+)
+
+// EmailAddresses maps user IDs to their email addresses.
+type EmailAddresses map[string]string
+
+func (a EmailAddresses) EmailAddress(_ context.Context, userID string) (string, error) {
+	address, ok := a[userID]
+	if !ok || address == "" {
+		return "", ErrNoRecipient
+	}
+	return address, nil
+}
+
+// EmailResolver finds the email address of a user.
+type EmailResolver interface {
+	EmailAddress(ctx context.Context, userID string) (string, error)
+}
+
+type SMTPConfig struct {
+	Host string
+	Port string
+	// Username and Password enable AUTH PLAIN if set.
+	Username string
+	Password string
+	From     string
+	// TLS is one of TLSNone, TLSStartTLS (the default) and TLSImplicit.
+	TLS string
+	// CAFile is a PEM bundle used instead of the system roots to verify the server.
+	CAFile             string
+	InsecureSkipVerify bool
+	// SubjectTemplate and BodyTemplate are text/template sources executed with the Notification.
+	SubjectTemplate string
+	BodyTemplate    string
+	Timeout         time.Duration
+}
+
+// SMTP emails notifications to the addresses of their users.
+// Temporary failures (network errors, 4xx replies) are retried according to the retry policy,
+// undeliverable notifications are put into the dead-letter queue.
+type SMTP struct {
+	conf      SMTPConfig
+	addresses EmailResolver
+	tls       *tls.Config
+	subject   *template.Template
+	body      *template.Template
+	retry     RetryPolicy
+	dlq       DeadLetterQueue
+	now       func() time.Time
+}
+
+func NewSMTP(conf SMTPConfig, addresses EmailResolver, retry RetryPolicy, dlq DeadLetterQueue) (*SMTP, error) {
+	if conf.TLS == "" {
+		conf.TLS = TLSStartTLS
+	}
+	if conf.TLS != TLSNone && conf.TLS != TLSStartTLS && conf.TLS != TLSImplicit {
+		return nil, fmt.Errorf("unknown smtp tls mode %q", conf.TLS)
+	}
+	if conf.SubjectTemplate == "" {
+		conf.SubjectTemplate = DefaultSubjectTemplate
+	}
+	if conf.BodyTemplate == "" {
+		conf.BodyTemplate = DefaultBodyTemplate
+	}
+
+	subject, err := template.New("subject").Parse(conf.SubjectTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse subject template: %w", err)
+	}
+	body, err := template.New("body").Parse(conf.BodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parse body template: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         conf.Host,
+		InsecureSkipVerify: conf.InsecureSkipVerify, //nolint:gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read smtp ca file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in smtp ca file %s", conf.CAFile)
+		}
+	}
+
+	return &SMTP{
+		conf:      conf,
+		addresses: addresses,
+		tls:       tlsConfig,
+		subject:   subject,
+		body:      body,
+		retry:     retry,
+		dlq:       dlq,
+		now:       time.Now,
+	}, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, n Notification) (err error) {
+	ctx, span := tracing.Start(ctx, "sender.smtp")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttribute("event.id", n.EventID)
+
+	to, err := s.addresses.EmailAddress(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.message(to, n)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := s.retry.do(ctx, func(ctx context.Context) error {
+		return s.send(ctx, to, msg)
+	})
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	letter := DeadLetter{Notification: n, Backend: "smtp", Attempts: attempts, Err: err.Error(), At: s.now()}
+	if dlqErr := s.dlq.Put(ctx, letter); dlqErr != nil {
+		return fmt.Errorf("smtp delivery failed: %w; dead-letter queue: %s", err, dlqErr.Error())
+	}
+	err = fmt.Errorf("smtp delivery failed after %d attempts, dead-lettered: %w", attempts, err)
+	return deadLetteredError{err: err}
+}
+
+func (s *SMTP) message(to string, n Notification) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, n); err != nil {
+		return nil, fmt.Errorf("execute subject template: %w", err)
+	}
+	if err := s.body.Execute(&body, n); err != nil {
+		return nil, fmt.Errorf("execute body template: %w", err)
+	}
+
+	// Subject must stay a single header line whatever the template produces.
+	subjectLine := strings.Join(strings.Fields(subject.String()), " ")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subjectLine))
+	fmt.Fprintf(&msg, "Date: %s\r\n", s.now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+func (s *SMTP) send(ctx context.Context, to string, msg []byte) error {
+	if s.conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.conf.Timeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(s.conf.Host, s.conf.Port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if s.conf.TLS == TLSImplicit {
+		conn = tls.Client(conn, s.tls)
+	}
+
+	c, err := smtp.NewClient(conn, s.conf.Host)
+	if err != nil {
+		conn.Close()
+		return classify(err)
+	}
+	defer c.Close()
+
+	if err := s.transmit(c, to, msg); err != nil {
+		return classify(err)
+	}
+	return c.Quit()
+}
+
+func (s *SMTP) transmit(c *smtp.Client, to string, msg []byte) error {
+	if err := c.Hello("localhost"); err != nil {
+		return err
+	}
+	if s.conf.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return permanent(errors.New("smtp server does not support STARTTLS"))
+		}
+		if err := c.StartTLS(s.tls); err != nil {
+			return err
+		}
+	}
+	if s.conf.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.conf.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// classify makes permanent SMTP replies (5xx) fail without retries.
+func classify(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mail struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string
+}
+
+// fakeSMTP is a minimal in-process SMTP server recording received mail.
+type fakeSMTP struct {
+	ln  net.Listener
+	tls *tls.Config
+	// rcptReplies override the replies to successive RCPT TO commands, e.g. "451 try later".
+	rcptReplies []string
+
+	mu    sync.Mutex
+	mails []mail
+	rcpts int
+}
+
+func startFakeSMTP(t *testing.T, tlsConfig *tls.Config, rcptReplies ...string) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{ln: ln, tls: tlsConfig, rcptReplies: rcptReplies}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return port
+}
+
+func (s *fakeSMTP) received() []mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]mail(nil), s.mails...)
+}
+
+func (s *fakeSMTP) rcptReply() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rcpts++
+	if s.rcpts <= len(s.rcptReplies) {
+		return s.rcptReplies[s.rcpts-1]
+	}
+	return "250 ok"
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	current := mail{}
+	tp.PrintfLine("220 localhost fake smtp")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := strings.TrimSpace(strings.TrimPrefix(line, strings.SplitN(line, " ", 2)[0]))
+
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			if s.tls != nil && !current.tls {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 go ahead")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			current.tls = true
+		case "AUTH":
+			current.auth = arg
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			current.from = arg
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			reply := s.rcptReply()
+			if strings.HasPrefix(reply, "250") {
+				current.to = append(current.to, arg)
+			}
+			tp.PrintfLine("%s", reply)
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := ioutil.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			current.data = string(data)
+			s.mu.Lock()
+			s.mails = append(s.mails, current)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// testTLS returns a server config with the httptest certificate and a CA file trusting it.
+func testTLS(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	return &tls.Config{Certificates: srv.TLS.Certificates, MinVersion: tls.VersionTLS12}, caFile
+}
+
+func TestSMTP(t *testing.T) {
+	ctx := context.Background()
+	addresses := EmailAddresses{"user-1": "alice@example.com"}
+
+	t.Run("starttls and auth", func(t *testing.T) {
+		serverTLS, caFile := testTLS(t)
+		server := startFakeSMTP(t, serverTLS)
+		dlq := &MemoryDeadLetters{}
+
+		s, err := NewSMTP(SMTPConfig{
+			Host:     "127.0.0.1",
+			Port:     server.port(),
+			Username: "calendar",
+			Password: "secret",
+			From:     "calendar@example.com",
+			CAFile:   caFile,
+		}, addresses, fastRetry, dlq)
+		require.NoError(t, err)
+
+		require.NoError(t, s.Send(ctx, notification))
+
+		mails := server.received()
+		require.Len(t, mails, 1)
+		require.True(t, mails[0].tls)
+		require.NotEmpty(t, mails[0].auth)
+		require.Equal(t, "FROM:<calendar@example.com>", mails[0].from)
+		require.Equal(t, []string{"TO:<alice@example.com>"}, mails[0].to)
+		require.Contains(t, mails[0].data, "Subject: Reminder: standup\n")
+		require.Contains(t, mails[0].data, `"standup" starts at 2021-06-01 10:00 UTC.`)
+		require.Empty(t, dlq.List())
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		server := startFakeSMTP(t, nil)
+
+		s, err := NewSMTP(SMTPConfig{
+			Host:            "127.0.0.1",
+			Port:            server.port(),
+			From:            "calendar@example.com",
+			TLS:             TLSNone,
+			SubjectTemplate: "{{.Title}}\r\nBcc: everyone@example.com",
+			BodyTemplate:    "Event {{.EventID}} for {{.UserID}}\n{{.StartAt.Format \"15:04\"}}",
+		}, addresses, fastRetry, &MemoryDeadLetters{})
+		require.NoError(t, err)
+
+		require.NoError(t, s.Send(ctx, notification))
+
+		mails := server.received()
+		require.Len(t, mails, 1)
+		require.Contains(t, mails[0].data, "Subject: standup Bcc: everyone@example.com\n")
+		require.NotContains(t, mails[0].data, "\nBcc:")
+		require.True(t, strings.HasSuffix(mails[0].data, "\n\nEvent event-1 for user-1\n10:00\n"), mails[0].data)
+	})
+
+	t.Run("starttls required", func(t *testing.T) {
+		server := startFakeSMTP(t, nil)
+		dlq := &MemoryDeadLetters{}
+
+		s, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "calendar@example.com"},
+			addresses, fastRetry, dlq)
+		require.NoError(t, err)
+
+		require.Error(t, s.Send(ctx, notification))
+		require.Empty(t, server.received())
+		require.Len(t, dlq.List(), 1)
+		require.Equal(t, 1, dlq.List()[0].Attempts)
+	})
+
+	t.Run("temporary failure retried", func(t *testing.T) {
+		server := startFakeSMTP(t, nil, "451 try again later")
+		dlq := &MemoryDeadLetters{}
+
+		s, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "calendar@example.com", TLS: TLSNone},
+			addresses, fastRetry, dlq)
+		require.NoError(t, err)
+
+		require.NoError(t, s.Send(ctx, notification))
+		require.Len(t, server.received(), 1)
+		require.Empty(t, dlq.List())
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		server := startFakeSMTP(t, nil, "550 no such user")
+		dlq := &MemoryDeadLetters{}
+
+		s, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "calendar@example.com", TLS: TLSNone},
+			addresses, fastRetry, dlq)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, s.Send(ctx, notification), ErrDeadLettered)
+		require.Empty(t, server.received())
+
+		letters := dlq.List()
+		require.Len(t, letters, 1)
+		require.Equal(t, "smtp", letters[0].Backend)
+		require.Equal(t, 1, letters[0].Attempts)
+		require.Contains(t, letters[0].Err, "no such user")
+	})
+
+	t.Run("no address", func(t *testing.T) {
+		s, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: "1"}, EmailAddresses{}, fastRetry, &MemoryDeadLetters{})
+		require.NoError(t, err)
+
+		require.ErrorIs(t, s.Send(ctx, notification), ErrNoRecipient)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewSMTP(SMTPConfig{TLS: "ssl"}, addresses, fastRetry, &MemoryDeadLetters{})
+		require.Error(t, err)
+
+		_, err = NewSMTP(SMTPConfig{BodyTemplate: "{{.Title"}, addresses, fastRetry, &MemoryDeadLetters{})
+		require.Error(t, err)
+	})
+}
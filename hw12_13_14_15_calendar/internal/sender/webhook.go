@@ -0,0 +1,136 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+)
+
+const (
+	SignatureHeader = "X-Calendar-Signature"
+	TimestampHeader = "X-Calendar-Timestamp"
+)
+
+// WebhookURLs maps user IDs to the URLs their notifications are posted to.
+type WebhookURLs map[string]string
+
+func (u WebhookURLs) WebhookURL(_ context.Context, userID string) (string, error) {
+	url, ok := u[userID]
+	if !ok || url == "" {
+		return "", ErrNoRecipient
+	}
+	return url, nil
+}
+
+// WebhookURLResolver finds the webhook URL of a user.
+type WebhookURLResolver interface {
+	WebhookURL(ctx context.Context, userID string) (string, error)
+}
+
+// Webhook posts notifications as JSON to per-user URLs.
+//
+// Each request is signed with HMAC-SHA256 of "<timestamp>.<body>" using the shared secret;
+// the hex signature is sent in X-Calendar-Signature as "sha256=<hex>" and the unix
+// timestamp in X-Calendar-Timestamp. Network errors, 429 and 5xx responses are retried
+// according to the retry policy, other failures are not. Undeliverable notifications
+// are put into the dead-letter queue.
+type Webhook struct {
+	client *http.Client
+	urls   WebhookURLResolver
+	secret []byte
+	retry  RetryPolicy
+	dlq    DeadLetterQueue
+	now    func() time.Time
+}
+
+func NewWebhook(client *http.Client, urls WebhookURLResolver, secret string, retry RetryPolicy,
+	dlq DeadLetterQueue) *Webhook {
+	return &Webhook{
+		client: client,
+		urls:   urls,
+		secret: []byte(secret),
+		retry:  retry,
+		dlq:    dlq,
+		now:    time.Now,
+	}
+}
+
+func (w *Webhook) Send(ctx context.Context, n Notification) (err error) {
+	ctx, span := tracing.Start(ctx, "sender.webhook")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	span.SetAttribute("event.id", n.EventID)
+
+	url, err := w.urls.WebhookURL(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := w.retry.do(ctx, func(ctx context.Context) error {
+		return w.post(ctx, url, body)
+	})
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	letter := DeadLetter{Notification: n, Backend: "webhook", Attempts: attempts, Err: err.Error(), At: w.now()}
+	if dlqErr := w.dlq.Put(ctx, letter); dlqErr != nil {
+		return fmt.Errorf("webhook delivery failed: %w; dead-letter queue: %s", err, dlqErr.Error())
+	}
+	err = fmt.Errorf("webhook delivery failed after %d attempts, dead-lettered: %w", attempts, err)
+	return deadLetteredError{err: err}
+}
+
+// Sign returns the signature of a webhook body sent at the given unix timestamp.
+func Sign(secret []byte, timestamp, body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(w.secret, timestamp, string(body)))
+	tracing.Inject(ctx, tracing.HeaderCarrier(req.Header))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	default:
+		return permanent(fmt.Errorf("webhook responded %s", resp.Status))
+	}
+}
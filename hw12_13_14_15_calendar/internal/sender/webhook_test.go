@@ -0,0 +1,129 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var notification = Notification{
+	EventID: "event-1",
+	Title:   "standup",
+	StartAt: time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+	UserID:  "user-1",
+}
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// webhookServer answers with the given status codes in turn, repeating the last one.
+func webhookServer(t *testing.T, codes ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(atomic.AddInt32(&calls, 1))
+		if call > len(codes) {
+			call = len(codes)
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		signature := Sign([]byte("secret"), r.Header.Get(TimestampHeader), string(body))
+		require.Equal(t, signature, r.Header.Get(SignatureHeader))
+
+		var got Notification
+		require.NoError(t, json.Unmarshal(body, &got))
+		require.Equal(t, notification, got)
+
+		w.WriteHeader(codes[call-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delivered", func(t *testing.T) {
+		srv, calls := webhookServer(t, http.StatusOK)
+		dlq := &MemoryDeadLetters{}
+		w := NewWebhook(srv.Client(), WebhookURLs{"user-1": srv.URL}, "secret", fastRetry, dlq)
+
+		require.NoError(t, w.Send(ctx, notification))
+		require.Equal(t, int32(1), atomic.LoadInt32(calls))
+		require.Empty(t, dlq.List())
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		srv, calls := webhookServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent)
+		dlq := &MemoryDeadLetters{}
+		w := NewWebhook(srv.Client(), WebhookURLs{"user-1": srv.URL}, "secret", fastRetry, dlq)
+
+		require.NoError(t, w.Send(ctx, notification))
+		require.Equal(t, int32(3), atomic.LoadInt32(calls))
+		require.Empty(t, dlq.List())
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		srv, calls := webhookServer(t, http.StatusInternalServerError)
+		dlq := &MemoryDeadLetters{}
+		w := NewWebhook(srv.Client(), WebhookURLs{"user-1": srv.URL}, "secret", fastRetry, dlq)
+
+		require.ErrorIs(t, w.Send(ctx, notification), ErrDeadLettered)
+		require.Equal(t, int32(3), atomic.LoadInt32(calls))
+
+		letters := dlq.List()
+		require.Len(t, letters, 1)
+		require.Equal(t, notification, letters[0].Notification)
+		require.Equal(t, "webhook", letters[0].Backend)
+		require.Equal(t, 3, letters[0].Attempts)
+		require.Contains(t, letters[0].Err, "500")
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		srv, calls := webhookServer(t, http.StatusGone)
+		dlq := &MemoryDeadLetters{}
+		w := NewWebhook(srv.Client(), WebhookURLs{"user-1": srv.URL}, "secret", fastRetry, dlq)
+
+		require.Error(t, w.Send(ctx, notification))
+		require.Equal(t, int32(1), atomic.LoadInt32(calls))
+		require.Len(t, dlq.List(), 1)
+	})
+
+	t.Run("no url", func(t *testing.T) {
+		dlq := &MemoryDeadLetters{}
+		w := NewWebhook(http.DefaultClient, WebhookURLs{}, "secret", fastRetry, dlq)
+
+		require.ErrorIs(t, w.Send(ctx, notification), ErrNoRecipient)
+		require.Empty(t, dlq.List())
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		srv, _ := webhookServer(t, http.StatusInternalServerError)
+		dlq := &MemoryDeadLetters{}
+		slow := RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Hour}
+		w := NewWebhook(srv.Client(), WebhookURLs{"user-1": srv.URL}, "secret", slow, dlq)
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, w.Send(ctx, notification), context.DeadlineExceeded)
+		require.Empty(t, dlq.List())
+	})
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	require.Equal(t, time.Second, p.backoff(1))
+	require.Equal(t, 2*time.Second, p.backoff(2))
+	require.Equal(t, 8*time.Second, p.backoff(4))
+	require.Equal(t, 10*time.Second, p.backoff(5))
+	require.Equal(t, 10*time.Second, p.backoff(50))
+}
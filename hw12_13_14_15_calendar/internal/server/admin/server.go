@@ -0,0 +1,197 @@
+// Package admin serves the operator API of the calendar on a listener of its own,
+// separate from the API for users.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+type Server struct {
+	logger Logger
+	app    Application
+	token  string
+	srv    *http.Server
+}
+
+// Logger is the logger of the service, whose level can be changed at runtime.
+type Logger interface {
+	Info(msg string)
+	Error(msg string)
+	Level() string
+	SetLevel(level string) error
+}
+
+type Application interface {
+	Stats(ctx context.Context) (app.Stats, error)
+	CleanupEvents(ctx context.Context) (int, error)
+}
+
+// NewServer builds the admin API. Every request must carry "Authorization: Bearer <token>".
+func NewServer(logger Logger, app Application, token, host, port string) *Server {
+	s := &Server{logger: logger, app: app, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", s.stats)
+	mux.HandleFunc("/log-level", s.logLevel)
+	mux.HandleFunc("/cleanup", s.cleanup)
+
+	s.srv = &http.Server{
+		Addr:              net.JoinHostPort(host, port),
+		Handler:           s.withAuth(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	s.logger.Info("admin server is listening on " + s.srv.Addr)
+	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) withAuth(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			s.logger.Info("admin request rejected from " + r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type jobStatus struct {
+	LastRun *time.Time `json:"lastRun"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type storageStats struct {
+	Events        int `json:"events"`
+	DeletedEvents int `json:"deletedEvents"`
+	Reminders     int `json:"reminders"`
+}
+
+type statsResponse struct {
+	Storage storageStats `json:"storage"`
+	// Tenants are the storage counts by tenant ID; the default tenant is "".
+	Tenants            map[string]storageStats `json:"tenants"`
+	Subscribers        int                     `json:"subscribers"`
+	QueuedChanges      int                     `json:"queuedChanges"`
+	Reminders          jobStatus               `json:"reminders"`
+	ReminderLagSeconds float64                 `json:"reminderLagSeconds"`
+	Cleanup            jobStatus               `json:"cleanup"`
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	stats, err := s.app.Stats(r.Context())
+	if err != nil {
+		s.logger.Error("admin stats: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := statsResponse{Storage: toStorageStats(stats.Storage), Tenants: make(map[string]storageStats)}
+	for tenantID, tenantStats := range stats.Tenants {
+		resp.Tenants[tenantID] = toStorageStats(tenantStats)
+	}
+	resp.Subscribers = stats.Subscribers
+	resp.QueuedChanges = stats.QueuedChanges
+	resp.Reminders = toJobStatus(stats.Reminders)
+	resp.ReminderLagSeconds = stats.ReminderLag.Seconds()
+	resp.Cleanup = toJobStatus(stats.Cleanup)
+	writeJSON(w, resp)
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// logLevel returns the level of the logger on GET and changes it on PUT.
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var req logLevel
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.logger.SetLevel(req.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Info("log level changed to " + s.logger.Level())
+	}
+	writeJSON(w, logLevel{Level: s.logger.Level()})
+}
+
+// cleanup purges old and expired deleted events right away.
+func (s *Server) cleanup(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+
+	purged, err := s.app.CleanupEvents(r.Context())
+	if err != nil {
+		s.logger.Error("admin cleanup: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		Purged int `json:"purged"`
+	}{purged})
+}
+
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	for _, method := range methods {
+		w.Header().Add("Allow", method)
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+func toJobStatus(status app.JobStatus) jobStatus {
+	result := jobStatus{Error: status.Error}
+	if !status.LastRun.IsZero() {
+		result.LastRun = &status.LastRun
+	}
+	return result
+}
+
+func toStorageStats(stats storage.Stats) storageStats {
+	return storageStats{Events: stats.Events, DeletedEvents: stats.DeletedEvents, Reminders: stats.Reminders}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
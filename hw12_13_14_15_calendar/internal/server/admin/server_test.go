@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLogger struct {
+	level string
+}
+
+func (l *fakeLogger) Info(string)  {}
+func (l *fakeLogger) Error(string) {}
+
+func (l *fakeLogger) Level() string {
+	return l.level
+}
+
+func (l *fakeLogger) SetLevel(level string) error {
+	if level != "INFO" && level != "ERROR" {
+		return errors.New("unknown level")
+	}
+	l.level = level
+	return nil
+}
+
+func do(s *Server, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestServer(t *testing.T) {
+	calendar := app.New(&fakeLogger{}, memorystorage.New())
+	ctx := app.ContextWithUserID(context.Background(), "alice")
+	start := time.Now().Add(time.Hour)
+	_, err := calendar.CreateEvent(ctx, storage.Event{Title: "standup", StartAt: start, EndAt: start.Add(time.Hour)})
+	require.NoError(t, err)
+
+	logger := &fakeLogger{level: "INFO"}
+	s := NewServer(logger, calendar, "secret", "localhost", "0")
+
+	t.Run("auth", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		r.Header.Set("Authorization", "Bearer wrong")
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("stats and cleanup", func(t *testing.T) {
+		w := do(s, http.MethodGet, "/stats", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var stats statsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		require.Equal(t, 1, stats.Storage.Events)
+		require.Equal(t, map[string]storageStats{"": {Events: 1}}, stats.Tenants)
+		require.Nil(t, stats.Cleanup.LastRun, "cleanup has not run yet")
+
+		w = do(s, http.MethodGet, "/cleanup", "")
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		w = do(s, http.MethodPost, "/cleanup", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.JSONEq(t, `{"purged": 0}`, w.Body.String())
+
+		w = do(s, http.MethodGet, "/stats", "")
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		require.NotNil(t, stats.Cleanup.LastRun)
+		require.Empty(t, stats.Cleanup.Error)
+	})
+
+	t.Run("log level", func(t *testing.T) {
+		w := do(s, http.MethodGet, "/log-level", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.JSONEq(t, `{"level": "INFO"}`, w.Body.String())
+
+		w = do(s, http.MethodPut, "/log-level", `{"level": "ERROR"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "ERROR", logger.level)
+
+		w = do(s, http.MethodPut, "/log-level", `{"level": "LOUD"}`)
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		require.Equal(t, "ERROR", logger.level)
+	})
+}
@@ -0,0 +1,173 @@
+package internalgrpc
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// tracingInterceptor wraps the call in a span continuing the trace from the "traceparent" metadata.
+func tracingInterceptor(tracer *tracing.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx, span := tracer.Start(tracing.Extract(ctx, mdCarrier(md)), info.FullMethod)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		span.SetAttribute("grpc.code", status.Code(err).String())
+		span.SetError(err)
+		return resp, err
+	}
+}
+
+// streamTracingInterceptor is tracingInterceptor for streaming calls.
+func streamTracingInterceptor(tracer *tracing.Tracer) grpc.StreamServerInterceptor {
+	unary := tracingInterceptor(tracer)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		_, err := unary(ss.Context(), nil, &grpc.UnaryServerInfo{FullMethod: info.FullMethod},
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				return nil, handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+			})
+		return err
+	}
+}
+
+// mdCarrier adapts gRPC metadata to tracing.Carrier.
+type mdCarrier metadata.MD
+
+func (c mdCarrier) Get(key string) string {
+	return firstValue(metadata.MD(c), key)
+}
+
+func (c mdCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// authInterceptor rejects calls without valid credentials and puts
+// the authenticated user and tenant IDs into the call context for the application.
+// Credentials are read from the "authorization" metadata or, for API keys, from "x-api-key".
+func authInterceptor(logger Logger, auth Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		credential := firstValue(md, "authorization")
+		if key := firstValue(md, "x-api-key"); credential == "" && key != "" {
+			credential = "ApiKey " + key
+		}
+
+		identity, err := auth.Authenticate(ctx, credential)
+		if err != nil {
+			logger.Info("authentication failed for " + info.FullMethod + ": " + err.Error())
+			return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+		}
+
+		return handler(app.ContextWithTenantID(app.ContextWithUserID(ctx, identity.UserID), identity.TenantID), req)
+	}
+}
+
+// streamAuthInterceptor is authInterceptor for streaming calls.
+func streamAuthInterceptor(logger Logger, auth Authenticator) grpc.StreamServerInterceptor {
+	unary := authInterceptor(logger, auth)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		_, err := unary(ss.Context(), nil, &grpc.UnaryServerInfo{FullMethod: info.FullMethod},
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				return nil, handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+			})
+		return err
+	}
+}
+
+// streamRateLimitInterceptor is rateLimitInterceptor for streaming calls; it limits opening streams.
+func streamRateLimitInterceptor(limiter RateLimiter, key func(ctx context.Context) string) grpc.StreamServerInterceptor {
+	unary := rateLimitInterceptor(limiter, key)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		_, err := unary(ss.Context(), nil, &grpc.UnaryServerInfo{FullMethod: info.FullMethod},
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				return nil, handler(srv, ss)
+			})
+		return err
+	}
+}
+
+// streamShutdownInterceptor cancels the context of streaming calls once streams is cancelled
+// and ends them with Unavailable, so that clients know to reconnect to another instance.
+func streamShutdownInterceptor(streams context.Context) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-streams.Done():
+				cancel()
+			}
+		}()
+
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		if streams.Err() != nil && ss.Context().Err() == nil {
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+		return err
+	}
+}
+
+// contextStream overrides the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// rateLimitInterceptor fails calls with ResourceExhausted and a "retry-after" trailer
+// once the bucket selected by key is exhausted.
+func rateLimitInterceptor(limiter RateLimiter, key func(ctx context.Context) string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if ok, retryAfter := limiter.Allow(key(ctx)); !ok {
+			seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+			_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", seconds))
+			return nil, status.Error(codes.ResourceExhausted, "too many requests, retry after "+seconds+"s")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func clientIPKey(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "ip:"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	return "ip:" + host
+}
+
+func userIDKey(ctx context.Context) string {
+	userID, _ := app.UserIDFromContext(ctx)
+	if tenantID := app.TenantIDFromContext(ctx); tenantID != "" {
+		return "user:" + tenantID + "/" + userID
+	}
+	return "user:" + userID
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
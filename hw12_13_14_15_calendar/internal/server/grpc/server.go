@@ -0,0 +1,97 @@
+package internalgrpc
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/grpc"
+)
+
+type Server struct {
+	logger Logger
+	addr   string
+	srv    *grpc.Server
+	// streams is cancelled on stop to end the open WatchEvents streams, which would otherwise
+	// keep GracefulStop waiting until the stop context expires.
+	streams      context.Context
+	closeStreams context.CancelFunc
+}
+
+type Logger interface {
+	Info(msg string)
+	Error(msg string)
+}
+
+// Authenticator resolves the value of the "authorization" metadata to the identity of a user.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (auth.Identity, error)
+}
+
+// RateLimiter reports whether a call keyed by user ID or client IP may proceed
+// and, if not, how long the client should wait.
+type RateLimiter interface {
+	Allow(key string) (ok bool, retryAfter time.Duration)
+}
+
+// NewServer builds the gRPC API. limiter may be nil to disable rate limiting
+// and tracer may be nil to disable tracing.
+func NewServer(logger Logger, api eventpb.EventServiceServer, auth Authenticator, limiter RateLimiter,
+	tracer *tracing.Tracer, host, port string) *Server {
+	unary := []grpc.UnaryServerInterceptor{tracingInterceptor(tracer)}
+	stream := []grpc.StreamServerInterceptor{streamTracingInterceptor(tracer)}
+	if limiter != nil {
+		unary = append(unary, rateLimitInterceptor(limiter, clientIPKey))
+		stream = append(stream, streamRateLimitInterceptor(limiter, clientIPKey))
+	}
+	unary = append(unary, authInterceptor(logger, auth))
+	stream = append(stream, streamAuthInterceptor(logger, auth))
+	if limiter != nil {
+		unary = append(unary, rateLimitInterceptor(limiter, userIDKey))
+		stream = append(stream, streamRateLimitInterceptor(limiter, userIDKey))
+	}
+
+	streams, closeStreams := context.WithCancel(context.Background())
+	stream = append(stream, streamShutdownInterceptor(streams))
+
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	eventpb.RegisterEventServiceServer(srv, api)
+
+	return &Server{
+		logger:       logger,
+		addr:         net.JoinHostPort(host, port),
+		srv:          srv,
+		streams:      streams,
+		closeStreams: closeStreams,
+	}
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	lis, err := (&net.ListenConfig{}).Listen(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("grpc server is listening on " + s.addr)
+	return s.srv.Serve(lis)
+}
+
+func (s *Server) Stop(ctx context.Context) error {
+	s.closeStreams()
+	stopped := make(chan struct{})
+	go func() {
+		s.srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.srv.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,246 @@
+package internalgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+
+type staticAuth map[string]string
+
+func (a staticAuth) Authenticate(_ context.Context, credential string) (auth.Identity, error) {
+	if userID, ok := a[credential]; ok {
+		return auth.Identity{UserID: userID}, nil
+	}
+	return auth.Identity{}, errors.New("unauthenticated")
+}
+
+type denyLimiter struct{}
+
+func (denyLimiter) Allow(string) (bool, time.Duration) {
+	return false, 3 * time.Second
+}
+
+func startServer(t *testing.T, limiter RateLimiter) eventpb.EventServiceClient {
+	t.Helper()
+
+	api := NewService(nopLogger{}, app.New(nopLogger{}, memorystorage.New()))
+	auth := staticAuth{"Bearer alice": "alice", "ApiKey bob": "bob"}
+	return serve(t, NewServer(nopLogger{}, api, auth, limiter, nil, "", ""))
+}
+
+// serve starts s on an in-memory listener and returns a client connected to it.
+func serve(t *testing.T, s *Server) eventpb.EventServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	go s.srv.Serve(lis)
+	t.Cleanup(s.srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return eventpb.NewEventServiceClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	alice := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer alice")
+	bob := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "bob")
+
+	t.Run("crud", func(t *testing.T) {
+		client := startServer(t, nil)
+
+		created, err := client.CreateEvent(alice, &eventpb.CreateEventRequest{Event: &eventpb.Event{
+			Title:   "standup",
+			StartAt: timestamppb.New(day.Add(10 * time.Hour)),
+			EndAt:   timestamppb.New(day.Add(11 * time.Hour)),
+		}})
+		require.NoError(t, err)
+		require.Equal(t, "alice", created.GetUserId())
+
+		_, err = client.CreateEvent(alice, &eventpb.CreateEventRequest{Event: &eventpb.Event{
+			Title:   "overlapping",
+			StartAt: timestamppb.New(day.Add(10 * time.Hour)),
+			EndAt:   timestamppb.New(day.Add(12 * time.Hour)),
+		}})
+		require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+		list, err := client.ListEvents(alice, &eventpb.ListEventsRequest{
+			Period: eventpb.Period_WEEK,
+			Date:   timestamppb.New(day),
+		})
+		require.NoError(t, err)
+		require.Len(t, list.GetEvents(), 1)
+		require.Equal(t, created.GetId(), list.GetEvents()[0].GetId())
+
+		_, err = client.DeleteEvent(bob, &eventpb.DeleteEventRequest{Id: created.GetId()})
+		require.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = client.DeleteEvent(alice, &eventpb.DeleteEventRequest{Id: created.GetId()})
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		client := startServer(t, nil)
+
+		_, err := client.ListEvents(alice, &eventpb.ListEventsRequest{Date: timestamppb.New(day)})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.CreateEvent(alice, &eventpb.CreateEventRequest{Event: &eventpb.Event{Title: "no time"}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		client := startServer(t, nil)
+
+		_, err := client.ListEvents(context.Background(), &eventpb.ListEventsRequest{})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		spoofed := metadata.AppendToOutgoingContext(context.Background(), "x-user-id", "alice")
+		_, err = client.ListEvents(spoofed, &eventpb.ListEventsRequest{})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		client := startServer(t, nil)
+
+		standup := &eventpb.Event{
+			Title:   "standup",
+			StartAt: timestamppb.New(day.Add(10 * time.Hour)),
+			EndAt:   timestamppb.New(day.Add(11 * time.Hour)),
+		}
+		resp, err := client.BatchEvents(alice, &eventpb.BatchEventsRequest{
+			Atomic: true,
+			Operations: []*eventpb.BatchOperation{
+				{Operation: &eventpb.BatchOperation_Create{Create: standup}},
+				{Operation: &eventpb.BatchOperation_Delete{Delete: &eventpb.DeleteEventRequest{Id: "missing"}}},
+			},
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.GetResults(), 2)
+		require.Equal(t, int32(codes.Aborted), resp.GetResults()[0].GetCode())
+		require.Equal(t, int32(codes.NotFound), resp.GetResults()[1].GetCode())
+
+		resp, err = client.BatchEvents(alice, &eventpb.BatchEventsRequest{
+			Operations: []*eventpb.BatchOperation{
+				{Operation: &eventpb.BatchOperation_Create{Create: standup}},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, int32(codes.OK), resp.GetResults()[0].GetCode())
+		require.Equal(t, "alice", resp.GetResults()[0].GetEvent().GetUserId())
+
+		_, err = client.BatchEvents(alice, &eventpb.BatchEventsRequest{
+			Operations: []*eventpb.BatchOperation{{}},
+		})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		client := startServer(t, nil)
+
+		ctx, cancel := context.WithCancel(alice)
+		defer cancel()
+		stream, err := client.WatchEvents(ctx, &eventpb.WatchEventsRequest{})
+		require.NoError(t, err)
+		_, err = stream.Header()
+		require.NoError(t, err)
+
+		created, err := client.CreateEvent(alice, &eventpb.CreateEventRequest{Event: &eventpb.Event{
+			Title:   "standup",
+			StartAt: timestamppb.New(day.Add(10 * time.Hour)),
+			EndAt:   timestamppb.New(day.Add(11 * time.Hour)),
+		}})
+		require.NoError(t, err)
+
+		change, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, eventpb.ChangeKind_EVENT_CREATED, change.GetKind())
+		require.Equal(t, created.GetId(), change.GetEvent().GetId())
+
+		unauthenticated, err := client.WatchEvents(context.Background(), &eventpb.WatchEventsRequest{})
+		require.NoError(t, err)
+		_, err = unauthenticated.Recv()
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("stop closes watch streams", func(t *testing.T) {
+		api := NewService(nopLogger{}, app.New(nopLogger{}, memorystorage.New()))
+		s := NewServer(nopLogger{}, api, staticAuth{"Bearer alice": "alice"}, nil, nil, "", "")
+		client := serve(t, s)
+
+		stream, err := client.WatchEvents(alice, &eventpb.WatchEventsRequest{})
+		require.NoError(t, err)
+		_, err = stream.Header()
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, s.Stop(ctx))
+		_, err = stream.Recv()
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		client := startServer(t, denyLimiter{})
+
+		var trailer metadata.MD
+		_, err := client.ListEvents(alice, &eventpb.ListEventsRequest{}, grpc.Trailer(&trailer))
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+		require.Equal(t, []string{"3"}, trailer.Get("retry-after"))
+	})
+}
+
+type errorLogger []string
+
+func (l *errorLogger) Info(string) {}
+
+func (l *errorLogger) Error(msg string) {
+	*l = append(*l, msg)
+}
+
+func TestToStatus(t *testing.T) {
+	logger := &errorLogger{}
+	s := NewService(logger, nil)
+
+	st := status.Convert(s.toStatus(errors.New("pq: password authentication failed for user \"calendar\"")))
+	require.Equal(t, codes.Internal, st.Code())
+	require.Equal(t, "internal error", st.Message())
+
+	st = status.Convert(s.toStatus(fmt.Errorf("%w: dial tcp 10.0.0.5:5432: connection refused", app.ErrUnavailable)))
+	require.Equal(t, codes.Unavailable, st.Code())
+	require.Equal(t, "storage unavailable", st.Message())
+
+	require.Len(t, *logger, 2)
+	require.Contains(t, (*logger)[0], "password authentication failed")
+
+	st = status.Convert(s.toStatus(fmt.Errorf("%w: title is empty", app.ErrInvalidEvent)))
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Contains(t, st.Message(), "title is empty")
+}
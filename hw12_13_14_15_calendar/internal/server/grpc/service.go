@@ -0,0 +1,639 @@
+package internalgrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/holiday"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Application interface {
+	CreateEvent(ctx context.Context, event storage.Event) (storage.Event, error)
+	UpdateEvent(ctx context.Context, id string, event storage.Event) (storage.Event, error)
+	DeleteEvent(ctx context.Context, id string) error
+	RestoreEvent(ctx context.Context, id string) (storage.Event, error)
+	ListDeletedEvents(ctx context.Context) ([]storage.Event, error)
+	ListDayEvents(ctx context.Context, date time.Time, calendarIDs ...string) ([]storage.Event, error)
+	ListWeekEvents(ctx context.Context, weekStart time.Time, calendarIDs ...string) ([]storage.Event, error)
+	ListMonthEvents(ctx context.Context, monthStart time.Time, calendarIDs ...string) ([]storage.Event, error)
+	GetEventHistory(ctx context.Context, id string) ([]storage.AuditRecord, error)
+	ApplyBatch(ctx context.Context, ops []app.BatchOp, atomic bool) ([]app.BatchResult, error)
+	GetFreeBusy(ctx context.Context, userIDs []string, from, to time.Time) (app.FreeBusy, error)
+	FindFreeSlots(ctx context.Context, userIDs []string, from, to time.Time,
+		duration time.Duration) ([]app.Interval, error)
+	SetWorkingHours(ctx context.Context, hours storage.WorkingHours) (storage.WorkingHours, error)
+	GetWorkingHours(ctx context.Context) (storage.WorkingHours, error)
+	ListHolidayCalendars() []*holiday.Calendar
+	WorkingHoursWarnings(ctx context.Context, event storage.Event) ([]string, error)
+	CreateCalendar(ctx context.Context, calendar storage.Calendar) (storage.Calendar, error)
+	UpdateCalendar(ctx context.Context, id string, calendar storage.Calendar) (storage.Calendar, error)
+	DeleteCalendar(ctx context.Context, id string) error
+	ListCalendars(ctx context.Context) ([]storage.Calendar, error)
+	AddAttachment(ctx context.Context, eventID, name, contentType string, r io.Reader) (storage.Attachment, error)
+	GetAttachment(ctx context.Context, eventID, id string) (storage.Attachment, io.ReadCloser, error)
+	DeleteAttachment(ctx context.Context, eventID, id string) error
+	ExportEvents(ctx context.Context, allUsers bool, from, to time.Time, fn func(event storage.Event) error) error
+	Subscribe(ctx context.Context) (<-chan app.Change, error)
+	Ready() error
+}
+
+// Service implements eventpb.EventServiceServer on top of the application.
+// It backs both the gRPC server and the HTTP gateway.
+type Service struct {
+	eventpb.UnimplementedEventServiceServer
+	logger Logger
+	app    Application
+}
+
+// NewService builds the event API; logger receives the errors hidden from clients as internal ones.
+func NewService(logger Logger, app Application) *Service {
+	return &Service{logger: logger, app: app}
+}
+
+// Ready fails while the application cannot serve requests, such as while its storage is unavailable.
+func (s *Service) Ready() error {
+	return s.app.Ready()
+}
+
+func (s *Service) CreateEvent(ctx context.Context, req *eventpb.CreateEventRequest) (*eventpb.Event, error) {
+	event, err := s.app.CreateEvent(ctx, fromProto(req.GetEvent()))
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return s.withWarnings(ctx, event, req.GetCheckWorkingHours()), nil
+}
+
+func (s *Service) UpdateEvent(ctx context.Context, req *eventpb.UpdateEventRequest) (*eventpb.Event, error) {
+	event, err := s.app.UpdateEvent(ctx, req.GetId(), fromProto(req.GetEvent()))
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return s.withWarnings(ctx, event, req.GetCheckWorkingHours()), nil
+}
+
+// withWarnings converts the saved event and, if asked to, warns when it is outside the working hours.
+// The event is saved already, so failing to check the working hours is a warning too.
+func (s *Service) withWarnings(ctx context.Context, event storage.Event, check bool) *eventpb.Event {
+	resp := toProto(event)
+	if !check {
+		return resp
+	}
+	warnings, err := s.app.WorkingHoursWarnings(ctx, event)
+	if err != nil {
+		warnings = []string{"working hours could not be checked: " + err.Error()}
+	}
+	resp.Warnings = warnings
+	return resp
+}
+
+func (s *Service) DeleteEvent(ctx context.Context, req *eventpb.DeleteEventRequest) (*emptypb.Empty, error) {
+	if err := s.app.DeleteEvent(ctx, req.GetId()); err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Service) ListEvents(ctx context.Context, req *eventpb.ListEventsRequest) (*eventpb.ListEventsResponse, error) {
+	if req.GetDate() == nil {
+		return nil, status.Error(codes.InvalidArgument, "date is required")
+	}
+	date := req.GetDate().AsTime()
+	calendarIDs := req.GetCalendarIds()
+
+	var (
+		events []storage.Event
+		err    error
+	)
+	switch req.GetPeriod() {
+	case eventpb.Period_DAY:
+		events, err = s.app.ListDayEvents(ctx, date, calendarIDs...)
+	case eventpb.Period_WEEK:
+		events, err = s.app.ListWeekEvents(ctx, date, calendarIDs...)
+	case eventpb.Period_MONTH:
+		events, err = s.app.ListMonthEvents(ctx, date, calendarIDs...)
+	case eventpb.Period_PERIOD_UNSPECIFIED:
+		return nil, status.Error(codes.InvalidArgument, "period is required")
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown period %v", req.GetPeriod())
+	}
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return listToProto(events), nil
+}
+
+func (s *Service) ListDeletedEvents(ctx context.Context,
+	_ *eventpb.ListDeletedEventsRequest) (*eventpb.ListEventsResponse, error) {
+	events, err := s.app.ListDeletedEvents(ctx)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return listToProto(events), nil
+}
+
+func (s *Service) RestoreEvent(ctx context.Context, req *eventpb.RestoreEventRequest) (*eventpb.Event, error) {
+	event, err := s.app.RestoreEvent(ctx, req.GetId())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return toProto(event), nil
+}
+
+func (s *Service) GetEventHistory(ctx context.Context,
+	req *eventpb.GetEventHistoryRequest) (*eventpb.EventHistory, error) {
+	records, err := s.app.GetEventHistory(ctx, req.GetId())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &eventpb.EventHistory{Records: make([]*eventpb.AuditRecord, 0, len(records))}
+	for _, record := range records {
+		resp.Records = append(resp.Records, auditRecordToProto(record))
+	}
+	return resp, nil
+}
+
+func (s *Service) BatchEvents(ctx context.Context,
+	req *eventpb.BatchEventsRequest,
+) (*eventpb.BatchEventsResponse, error) {
+	ops := make([]app.BatchOp, 0, len(req.GetOperations()))
+	for _, op := range req.GetOperations() {
+		switch {
+		case op.GetCreate() != nil:
+			ops = append(ops, app.BatchOp{Kind: storage.BatchCreate, Event: fromProto(op.GetCreate())})
+		case op.GetUpdate() != nil:
+			ops = append(ops, app.BatchOp{
+				Kind:  storage.BatchUpdate,
+				ID:    op.GetUpdate().GetId(),
+				Event: fromProto(op.GetUpdate().GetEvent()),
+			})
+		case op.GetDelete() != nil:
+			ops = append(ops, app.BatchOp{Kind: storage.BatchDelete, ID: op.GetDelete().GetId()})
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "operation %d is empty", len(ops))
+		}
+	}
+
+	results, err := s.app.ApplyBatch(ctx, ops, req.GetAtomic())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &eventpb.BatchEventsResponse{Results: make([]*eventpb.BatchResult, 0, len(results))}
+	for _, result := range results {
+		if result.Err != nil {
+			st := status.Convert(s.toStatus(result.Err))
+			resp.Results = append(resp.Results, &eventpb.BatchResult{Code: int32(st.Code()), Error: st.Message()})
+			continue
+		}
+		resp.Results = append(resp.Results, &eventpb.BatchResult{Event: toProto(result.Event)})
+	}
+	return resp, nil
+}
+
+func (s *Service) GetFreeBusy(ctx context.Context, req *eventpb.GetFreeBusyRequest) (*eventpb.FreeBusy, error) {
+	if req.GetFrom() == nil || req.GetTo() == nil {
+		return nil, status.Error(codes.InvalidArgument, "from and to are required")
+	}
+
+	freeBusy, err := s.app.GetFreeBusy(ctx, req.GetUserIds(), req.GetFrom().AsTime(), req.GetTo().AsTime())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &eventpb.FreeBusy{
+		Users: make([]*eventpb.UserBusy, 0, len(freeBusy.Users)),
+		Busy:  intervalsToProto(freeBusy.Busy),
+	}
+	for _, user := range freeBusy.Users {
+		resp.Users = append(resp.Users, &eventpb.UserBusy{UserId: user.UserID, Busy: intervalsToProto(user.Busy)})
+	}
+	return resp, nil
+}
+
+func (s *Service) FindFreeSlots(ctx context.Context, req *eventpb.FindFreeSlotsRequest) (*eventpb.FreeSlots, error) {
+	if req.GetFrom() == nil || req.GetTo() == nil || req.GetDuration() == nil {
+		return nil, status.Error(codes.InvalidArgument, "from, to and duration are required")
+	}
+
+	slots, err := s.app.FindFreeSlots(ctx, req.GetUserIds(), req.GetFrom().AsTime(), req.GetTo().AsTime(),
+		req.GetDuration().AsDuration())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &eventpb.FreeSlots{Slots: intervalsToProto(slots)}, nil
+}
+
+func (s *Service) SetWorkingHours(ctx context.Context,
+	req *eventpb.SetWorkingHoursRequest) (*eventpb.WorkingHours, error) {
+	hours, err := workingHoursFromProto(req.GetWorkingHours())
+	if err != nil {
+		return nil, err
+	}
+	hours, err = s.app.SetWorkingHours(ctx, hours)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return workingHoursToProto(hours), nil
+}
+
+func (s *Service) GetWorkingHours(ctx context.Context,
+	_ *eventpb.GetWorkingHoursRequest) (*eventpb.WorkingHours, error) {
+	hours, err := s.app.GetWorkingHours(ctx)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return workingHoursToProto(hours), nil
+}
+
+func (s *Service) ListHolidayCalendars(_ context.Context,
+	_ *eventpb.ListHolidayCalendarsRequest) (*eventpb.ListHolidayCalendarsResponse, error) {
+	calendars := s.app.ListHolidayCalendars()
+	resp := &eventpb.ListHolidayCalendarsResponse{Calendars: make([]*eventpb.HolidayCalendar, 0, len(calendars))}
+	for _, calendar := range calendars {
+		c := &eventpb.HolidayCalendar{
+			Name:     calendar.Name,
+			Holidays: make([]*eventpb.Holiday, 0, len(calendar.Holidays)),
+		}
+		for _, h := range calendar.Holidays {
+			c.Holidays = append(c.Holidays, &eventpb.Holiday{Date: h.Date.Format(dateLayout), Name: h.Name})
+		}
+		resp.Calendars = append(resp.Calendars, c)
+	}
+	return resp, nil
+}
+
+func (s *Service) CreateCalendar(ctx context.Context, req *eventpb.CreateCalendarRequest) (*eventpb.Calendar, error) {
+	calendar, err := calendarFromProto(req.GetCalendar())
+	if err != nil {
+		return nil, err
+	}
+	calendar, err = s.app.CreateCalendar(ctx, calendar)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return calendarToProto(calendar), nil
+}
+
+func (s *Service) UpdateCalendar(ctx context.Context, req *eventpb.UpdateCalendarRequest) (*eventpb.Calendar, error) {
+	calendar, err := calendarFromProto(req.GetCalendar())
+	if err != nil {
+		return nil, err
+	}
+	calendar, err = s.app.UpdateCalendar(ctx, req.GetId(), calendar)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return calendarToProto(calendar), nil
+}
+
+func (s *Service) DeleteCalendar(ctx context.Context, req *eventpb.DeleteCalendarRequest) (*emptypb.Empty, error) {
+	if err := s.app.DeleteCalendar(ctx, req.GetId()); err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Service) ListCalendars(ctx context.Context,
+	_ *eventpb.ListCalendarsRequest) (*eventpb.ListCalendarsResponse, error) {
+	calendars, err := s.app.ListCalendars(ctx)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &eventpb.ListCalendarsResponse{Calendars: make([]*eventpb.Calendar, 0, len(calendars))}
+	for _, calendar := range calendars {
+		resp.Calendars = append(resp.Calendars, calendarToProto(calendar))
+	}
+	return resp, nil
+}
+
+func (s *Service) UploadAttachment(ctx context.Context,
+	req *eventpb.UploadAttachmentRequest) (*eventpb.Attachment, error) {
+	attachment, err := s.app.AddAttachment(ctx, req.GetEventId(), req.GetName(), req.GetContentType(),
+		bytes.NewReader(req.GetContent()))
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return attachmentToProto(attachment), nil
+}
+
+func (s *Service) DownloadAttachment(ctx context.Context,
+	req *eventpb.DownloadAttachmentRequest) (*eventpb.AttachmentContent, error) {
+	attachment, content, err := s.app.GetAttachment(ctx, req.GetEventId(), req.GetId())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	defer content.Close()
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &eventpb.AttachmentContent{Attachment: attachmentToProto(attachment), Content: data}, nil
+}
+
+func (s *Service) DeleteAttachment(ctx context.Context,
+	req *eventpb.DeleteAttachmentRequest) (*emptypb.Empty, error) {
+	if err := s.app.DeleteAttachment(ctx, req.GetEventId(), req.GetId()); err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Service) ExportEvents(req *eventpb.ExportEventsRequest, stream eventpb.EventService_ExportEventsServer) error {
+	if req.GetFrom() == nil || req.GetTo() == nil {
+		return status.Error(codes.InvalidArgument, "from and to are required")
+	}
+
+	err := s.app.ExportEvents(stream.Context(), req.GetAllUsers(), req.GetFrom().AsTime(), req.GetTo().AsTime(),
+		func(event storage.Event) error {
+			return stream.Send(toProto(event))
+		})
+	if err != nil {
+		return s.toStatus(err)
+	}
+	return nil
+}
+
+func (s *Service) WatchEvents(_ *eventpb.WatchEventsRequest, stream eventpb.EventService_WatchEventsServer) error {
+	changes, err := s.app.Subscribe(stream.Context())
+	if err != nil {
+		return s.toStatus(err)
+	}
+	// Let the client know it is subscribed before the first change arrives.
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for change := range changes {
+		if err := stream.Send(changeToProto(change)); err != nil {
+			return err
+		}
+	}
+	if err := stream.Context().Err(); err != nil {
+		return s.toStatus(err)
+	}
+	return status.Error(codes.Aborted, "subscriber is too slow, resubscribe")
+}
+
+// toStatus maps application errors to gRPC statuses. Errors without a meaning for clients,
+// such as storage failures, are logged and reported without their details.
+func (s *Service) toStatus(err error) error {
+	switch {
+	case errors.Is(err, app.ErrNoUser):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, app.ErrInvalidEvent), errors.Is(err, app.ErrInvalidBatch),
+		errors.Is(err, app.ErrInvalidFreeBusy), errors.Is(err, app.ErrInvalidCalendar),
+		errors.Is(err, app.ErrInvalidAttachment), errors.Is(err, app.ErrAttachmentTooLarge),
+		errors.Is(err, app.ErrInvalidWorkingHours), errors.Is(err, app.ErrInvalidExport):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, app.ErrPermissionDenied), errors.Is(err, app.ErrUnknownTenant):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, app.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, storage.ErrEventNotFound), errors.Is(err, storage.ErrCalendarNotFound),
+		errors.Is(err, app.ErrAttachmentNotFound), errors.Is(err, storage.ErrWorkingHoursNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrDateBusy), errors.Is(err, storage.ErrEventExists),
+		errors.Is(err, storage.ErrCalendarExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, storage.ErrCalendarNotEmpty):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, app.ErrAttachmentsDisabled):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, app.ErrBatchAborted), errors.Is(err, storage.ErrEventChanged):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, app.ErrUnavailable):
+		s.logger.Error(err.Error())
+		return status.Error(codes.Unavailable, app.ErrUnavailable.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		s.logger.Error(err.Error())
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func fromProto(e *eventpb.Event) storage.Event {
+	event := storage.Event{
+		ID:          e.GetId(),
+		Title:       e.GetTitle(),
+		Description: e.GetDescription(),
+		UserID:      e.GetUserId(),
+		CalendarID:  e.GetCalendarId(),
+	}
+	for _, link := range e.GetLinks() {
+		event.Links = append(event.Links, storage.Link{Title: link.GetTitle(), URL: link.GetUrl()})
+	}
+	if e.GetStartAt() != nil {
+		event.StartAt = e.GetStartAt().AsTime()
+	}
+	if e.GetEndAt() != nil {
+		event.EndAt = e.GetEndAt().AsTime()
+	}
+	if e.GetNotifyBefore() != nil {
+		event.NotifyBefore = e.GetNotifyBefore().AsDuration()
+	}
+	return event
+}
+
+func toProto(e storage.Event) *eventpb.Event {
+	event := &eventpb.Event{
+		Id:          e.ID,
+		Title:       e.Title,
+		StartAt:     timestamppb.New(e.StartAt),
+		EndAt:       timestamppb.New(e.EndAt),
+		Description: e.Description,
+		UserId:      e.UserID,
+		CalendarId:  e.Calendar(),
+		// Clients may not sanitize the description, so only the rendered one is safe to embed.
+		DescriptionHtml: app.DescriptionHTML(e),
+	}
+	for _, link := range e.Links {
+		event.Links = append(event.Links, &eventpb.Link{Title: link.Title, Url: link.URL})
+	}
+	for _, attachment := range e.Attachments {
+		event.Attachments = append(event.Attachments, attachmentToProto(attachment))
+	}
+	if e.NotifyBefore != 0 {
+		event.NotifyBefore = durationpb.New(e.NotifyBefore)
+	}
+	if e.IsDeleted() {
+		event.DeletedAt = timestamppb.New(e.DeletedAt)
+	}
+	return event
+}
+
+func attachmentToProto(a storage.Attachment) *eventpb.Attachment {
+	return &eventpb.Attachment{Id: a.ID, Name: a.Name, ContentType: a.ContentType, Size: a.Size}
+}
+
+func listToProto(events []storage.Event) *eventpb.ListEventsResponse {
+	resp := &eventpb.ListEventsResponse{Events: make([]*eventpb.Event, 0, len(events))}
+	for _, event := range events {
+		resp.Events = append(resp.Events, toProto(event))
+	}
+	return resp
+}
+
+func intervalsToProto(intervals []app.Interval) []*eventpb.TimeRange {
+	ranges := make([]*eventpb.TimeRange, 0, len(intervals))
+	for _, interval := range intervals {
+		ranges = append(ranges, &eventpb.TimeRange{
+			StartAt: timestamppb.New(interval.StartAt),
+			EndAt:   timestamppb.New(interval.EndAt),
+		})
+	}
+	return ranges
+}
+
+var roles = map[storage.Role]eventpb.Role{
+	storage.RoleReader: eventpb.Role_READER,
+	storage.RoleWriter: eventpb.Role_WRITER,
+}
+
+func calendarFromProto(c *eventpb.Calendar) (storage.Calendar, error) {
+	calendar := storage.Calendar{ID: c.GetId(), Name: c.GetName(), OwnerID: c.GetOwnerId()}
+	for _, share := range c.GetShares() {
+		var role storage.Role
+		switch share.GetRole() {
+		case eventpb.Role_READER:
+			role = storage.RoleReader
+		case eventpb.Role_WRITER:
+			role = storage.RoleWriter
+		case eventpb.Role_ROLE_UNSPECIFIED:
+			return storage.Calendar{}, status.Errorf(codes.InvalidArgument, "role of user %q is required", share.GetUserId())
+		default:
+			return storage.Calendar{}, status.Errorf(codes.InvalidArgument, "unknown role %v", share.GetRole())
+		}
+		if calendar.Shares == nil {
+			calendar.Shares = make(map[string]storage.Role)
+		}
+		calendar.Shares[share.GetUserId()] = role
+	}
+	return calendar, nil
+}
+
+// calendarToProto lists the shares of the calendar ordered by user ID.
+func calendarToProto(c storage.Calendar) *eventpb.Calendar {
+	calendar := &eventpb.Calendar{
+		Id:      c.ID,
+		Name:    c.Name,
+		OwnerId: c.OwnerID,
+		Shares:  make([]*eventpb.Share, 0, len(c.Shares)),
+	}
+	for userID, role := range c.Shares {
+		calendar.Shares = append(calendar.Shares, &eventpb.Share{UserId: userID, Role: roles[role]})
+	}
+	sort.Slice(calendar.Shares, func(i, j int) bool {
+		return calendar.Shares[i].GetUserId() < calendar.Shares[j].GetUserId()
+	})
+	return calendar
+}
+
+const (
+	dateLayout      = "2006-01-02"
+	timeOfDayLayout = "15:04"
+)
+
+func workingHoursFromProto(h *eventpb.WorkingHours) (storage.WorkingHours, error) {
+	hours := storage.WorkingHours{TimeZone: h.GetTimeZone(), Holidays: h.GetHolidays()}
+	for _, day := range h.GetDays() {
+		hours.Days = append(hours.Days, time.Weekday(day))
+	}
+	var err error
+	if hours.Start, err = parseTimeOfDay(h.GetStart()); err != nil {
+		return storage.WorkingHours{}, status.Errorf(codes.InvalidArgument, "bad start %q, want HH:MM", h.GetStart())
+	}
+	if hours.End, err = parseTimeOfDay(h.GetEnd()); err != nil {
+		return storage.WorkingHours{}, status.Errorf(codes.InvalidArgument, "bad end %q, want HH:MM", h.GetEnd())
+	}
+	return hours, nil
+}
+
+func workingHoursToProto(h storage.WorkingHours) *eventpb.WorkingHours {
+	hours := &eventpb.WorkingHours{
+		UserId:   h.UserID,
+		TimeZone: h.TimeZone,
+		Days:     make([]eventpb.Weekday, 0, len(h.Days)),
+		Start:    formatTimeOfDay(h.Start),
+		End:      formatTimeOfDay(h.End),
+		Holidays: h.Holidays,
+	}
+	for _, day := range h.Days {
+		hours.Days = append(hours.Days, eventpb.Weekday(day))
+	}
+	return hours
+}
+
+// parseTimeOfDay reads "HH:MM" as the time since midnight; "24:00" is the end of the day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse(timeOfDayLayout, s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	if d == 24*time.Hour {
+		return "24:00"
+	}
+	return time.Time{}.Add(d).Format(timeOfDayLayout)
+}
+
+var changeKinds = map[app.ChangeKind]eventpb.ChangeKind{
+	app.ChangeCreated:  eventpb.ChangeKind_EVENT_CREATED,
+	app.ChangeUpdated:  eventpb.ChangeKind_EVENT_UPDATED,
+	app.ChangeDeleted:  eventpb.ChangeKind_EVENT_DELETED,
+	app.ChangeRestored: eventpb.ChangeKind_EVENT_RESTORED,
+	app.ChangeReminder: eventpb.ChangeKind_REMINDER,
+}
+
+func changeToProto(c app.Change) *eventpb.EventChange {
+	return &eventpb.EventChange{
+		Kind:  changeKinds[c.Kind],
+		Event: toProto(c.Event),
+		At:    timestamppb.New(c.At),
+	}
+}
+
+var auditActions = map[storage.AuditAction]eventpb.AuditAction{
+	storage.AuditCreated:  eventpb.AuditAction_CREATED,
+	storage.AuditUpdated:  eventpb.AuditAction_UPDATED,
+	storage.AuditDeleted:  eventpb.AuditAction_DELETED,
+	storage.AuditRestored: eventpb.AuditAction_RESTORED,
+}
+
+func auditRecordToProto(r storage.AuditRecord) *eventpb.AuditRecord {
+	record := &eventpb.AuditRecord{
+		EventId: r.EventID,
+		Actor:   r.Actor,
+		Action:  auditActions[r.Action],
+		At:      timestamppb.New(r.At),
+		Changes: make([]*eventpb.FieldChange, 0, len(r.Changes)),
+	}
+	for _, c := range r.Changes {
+		record.Changes = append(record.Changes, &eventpb.FieldChange{Field: c.Field, Before: c.Before, After: c.After})
+	}
+	return record
+}
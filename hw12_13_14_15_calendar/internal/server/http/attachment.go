@@ -0,0 +1,79 @@
+package internalhttp
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// uploadAttachment serves the UploadAttachment RPC with the file as the raw request body,
+// its name in the name query parameter and its type in the Content-Type header.
+func (s *Server) uploadAttachment(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	content, err := io.ReadAll(io.LimitReader(r.Body, app.MaxAttachmentSize+1))
+	if err != nil {
+		s.writeError(w, r, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	if len(content) > app.MaxAttachmentSize {
+		http.Error(w, app.ErrAttachmentTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	attachment, err := s.app.UploadAttachment(r.Context(), &eventpb.UploadAttachmentRequest{
+		EventId:     params["event_id"],
+		Name:        r.URL.Query().Get("name"),
+		ContentType: r.Header.Get("Content-Type"),
+		Content:     content,
+	})
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	data, err := protojson.Marshal(attachment)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// downloadAttachment serves the DownloadAttachment RPC with the file as the raw response body.
+// Files are always served as downloads, so an uploaded page is never rendered in the browser.
+func (s *Server) downloadAttachment(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	resp, err := s.app.DownloadAttachment(r.Context(), &eventpb.DownloadAttachmentRequest{
+		EventId: params["event_id"],
+		Id:      params["id"],
+	})
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	attachment := resp.GetAttachment()
+	w.Header().Set("Content-Type", attachment.GetContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.GetContent())))
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": attachment.GetName()}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(resp.GetContent())
+}
+
+// writeError responds with the error the same way the gateway does for the generated routes.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var st interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &st) {
+		s.logger.Error(err.Error())
+		err = status.Error(codes.Internal, "internal error")
+	}
+	runtime.DefaultHTTPErrorHandler(r.Context(), s.gateway, &runtime.JSONPb{}, w, r, err)
+}
@@ -0,0 +1,109 @@
+package internalhttp
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/export"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// exportEvents serves the ExportEvents RPC as a CSV or JSON Lines download written while
+// the events are read, so exports of any size take little memory.
+func (s *Server) exportEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	req := &eventpb.ExportEventsRequest{}
+	var err error
+	if req.From, err = parseTimestamp(query.Get("from")); err != nil {
+		s.writeError(w, r, status.Errorf(codes.InvalidArgument, "bad from: %s", err))
+		return
+	}
+	if req.To, err = parseTimestamp(query.Get("to")); err != nil {
+		s.writeError(w, r, status.Errorf(codes.InvalidArgument, "bad to: %s", err))
+		return
+	}
+	if all := query.Get("allUsers"); all != "" {
+		if req.AllUsers, err = strconv.ParseBool(all); err != nil {
+			s.writeError(w, r, status.Errorf(codes.InvalidArgument, "bad allUsers: %s", err))
+			return
+		}
+	}
+	writer, err := export.NewWriter(w, format)
+	if err != nil {
+		s.writeError(w, r, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	stream := &exportStream{ctx: r.Context(), w: w, writer: writer, format: format}
+	if err := s.app.ExportEvents(req, stream); err != nil {
+		if !stream.started {
+			s.writeError(w, r, err)
+			return
+		}
+		// The status is sent already; a truncated export is all the client can tell.
+		s.logger.Error("export events: " + err.Error())
+		return
+	}
+	stream.start()
+	if err := writer.Flush(); err != nil {
+		s.logger.Error("export events: " + err.Error())
+	}
+}
+
+func parseTimestamp(value string) (*timestamppb.Timestamp, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return timestamppb.New(t), nil
+}
+
+// exportStream adapts an HTTP response to the server side of the ExportEvents stream.
+// The headers are sent with the first event, so errors found before it get a proper status.
+type exportStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	w       http.ResponseWriter
+	writer  export.Writer
+	format  string
+	started bool
+}
+
+func (s *exportStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *exportStream) Send(event *eventpb.Event) error {
+	s.start()
+	return s.writer.Write(event)
+}
+
+func (s *exportStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	s.w.Header().Set("Content-Type", export.ContentType(s.format))
+	s.w.Header().Set("Content-Disposition", `attachment; filename="events.`+s.format+`"`)
+	s.w.WriteHeader(http.StatusOK)
+}
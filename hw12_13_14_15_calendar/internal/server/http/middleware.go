@@ -1,7 +1,13 @@
 package internalhttp
 
 import (
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
 )
 
 func loggingMiddleware(next http.Handler) http.Handler {
@@ -9,3 +15,90 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		// TODO
 	})
 }
+
+// tracingMiddleware wraps the request in a span continuing the trace from the traceparent header
+// and reports the span back in the traceparent response header, so clients can find the trace.
+func tracingMiddleware(tracer *tracing.Tracer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), tracing.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method+" "+r.URL.Path)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.path", r.URL.Path)
+		tracing.Inject(ctx, tracing.HeaderCarrier(w.Header()))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttribute("http.status", strconv.Itoa(rec.status))
+	})
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// authMiddleware rejects requests without valid credentials, with the challenge in WWW-Authenticate,
+// and puts the authenticated user and tenant IDs into the request context for the application.
+// Credentials are read from the Authorization header or, for API keys, from X-Api-Key.
+func authMiddleware(logger Logger, auth Authenticator, challenge string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := r.Header.Get("Authorization")
+		if key := r.Header.Get("X-Api-Key"); credential == "" && key != "" {
+			credential = "ApiKey " + key
+		}
+
+		identity, err := auth.Authenticate(r.Context(), credential)
+		if err != nil {
+			logger.Info("authentication failed for " + r.RemoteAddr + ": " + err.Error())
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := app.ContextWithTenantID(app.ContextWithUserID(r.Context(), identity.UserID), identity.TenantID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// rateLimitMiddleware answers 429 Too Many Requests with a Retry-After hint
+// once the bucket selected by key is exhausted.
+func rateLimitMiddleware(limiter RateLimiter, key func(r *http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := limiter.Allow(key(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func userIDKey(r *http.Request) string {
+	userID, _ := app.UserIDFromContext(r.Context())
+	if tenantID := app.TenantIDFromContext(r.Context()); tenantID != "" {
+		return "user:" + tenantID + "/" + userID
+	}
+	return "user:" + userID
+}
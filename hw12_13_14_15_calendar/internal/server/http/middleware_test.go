@@ -0,0 +1,155 @@
+package internalhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+
+type staticAuth map[string]auth.Identity
+
+func (a staticAuth) Authenticate(_ context.Context, credential string) (auth.Identity, error) {
+	if identity, ok := a[credential]; ok {
+		return identity, nil
+	}
+	return auth.Identity{}, errors.New("unauthenticated")
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	identities := staticAuth{"ApiKey k1": {UserID: "user-1", TenantID: "acme"}, "Bearer t2": {UserID: "user-2"}}
+	handler := authMiddleware(nopLogger{}, identities, bearerChallenge,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := app.UserIDFromContext(r.Context())
+			require.True(t, ok)
+			w.Write([]byte(app.TenantIDFromContext(r.Context()) + "/" + userID))
+		}))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		code    int
+		body    string
+	}{
+		{name: "bearer", headers: map[string]string{"Authorization": "Bearer t2"}, code: http.StatusOK, body: "/user-2"},
+		{name: "api key header", headers: map[string]string{"X-Api-Key": "k1"}, code: http.StatusOK, body: "acme/user-1"},
+		{name: "no credentials", code: http.StatusUnauthorized},
+		{name: "bad token", headers: map[string]string{"Authorization": "Bearer t1"}, code: http.StatusUnauthorized},
+		{name: "spoofed user header", headers: map[string]string{"X-User-Id": "user-1"}, code: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tc.code, w.Code)
+			if tc.body != "" {
+				require.Equal(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
+
+type fakeLimiter struct {
+	keys       []string
+	allow      bool
+	retryAfter time.Duration
+}
+
+func (l *fakeLimiter) Allow(key string) (bool, time.Duration) {
+	l.keys = append(l.keys, key)
+	return l.allow, l.retryAfter
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("allowed", func(t *testing.T) {
+		limiter := &fakeLimiter{allow: true}
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		r.RemoteAddr = "10.0.0.1:5555"
+		w := httptest.NewRecorder()
+
+		rateLimitMiddleware(limiter, clientIPKey, ok).ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []string{"ip:10.0.0.1"}, limiter.keys)
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		limiter := &fakeLimiter{retryAfter: 1500 * time.Millisecond}
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		r = r.WithContext(app.ContextWithUserID(r.Context(), "user-1"))
+		w := httptest.NewRecorder()
+
+		rateLimitMiddleware(limiter, userIDKey, ok).ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "2", w.Header().Get("Retry-After"))
+		require.Equal(t, []string{"user:user-1"}, limiter.keys)
+	})
+
+	t.Run("users of tenants", func(t *testing.T) {
+		limiter := &fakeLimiter{allow: true}
+		r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		r = r.WithContext(app.ContextWithTenantID(app.ContextWithUserID(r.Context(), "user-1"), "acme"))
+
+		rateLimitMiddleware(limiter, userIDKey, ok).ServeHTTP(httptest.NewRecorder(), r)
+
+		require.Equal(t, []string{"user:acme/user-1"}, limiter.keys)
+	})
+}
+
+type spanRecorder struct {
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(span tracing.SpanData) {
+	r.spans = append(r.spans, span)
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	rec := &spanRecorder{}
+	handler := tracingMiddleware(tracing.New(rec), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "app.Work")
+		span.End()
+		http.NotFound(w, r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/events/1", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.Len(t, rec.spans, 2)
+	child, server := rec.spans[0], rec.spans[1]
+	require.Equal(t, "HTTP GET /events/1", server.Name)
+	require.Equal(t, traceID, server.TraceID)
+	require.Equal(t, "00f067aa0ba902b7", server.ParentID)
+	require.Equal(t, "404", server.Attributes["http.status"])
+	require.Equal(t, server.SpanID, child.ParentID)
+
+	sc, err := tracing.ParseTraceParent(w.Header().Get("traceparent"))
+	require.NoError(t, err)
+	require.Equal(t, server.SpanID, sc.SpanID.String())
+}
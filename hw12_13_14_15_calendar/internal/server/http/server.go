@@ -2,30 +2,164 @@ package internalhttp
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/auth"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/caldav"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/tracing"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+const (
+	caldavPrefix = "/caldav/"
+
+	bearerChallenge = `Bearer realm="calendar"`
+	// basicChallenge makes calendar apps ask for the user name and the API key.
+	basicChallenge = `Basic realm="calendar", charset="UTF-8"`
 )
 
-type Server struct { // TODO
+type Server struct {
+	logger  Logger
+	app     Application
+	auth    Authenticator
+	limiter RateLimiter
+	gateway *runtime.ServeMux
+	srv     *http.Server
+	// streams is cancelled on shutdown to end the open event streams, which would otherwise
+	// keep Stop waiting until its deadline.
+	streams      context.Context
+	closeStreams context.CancelFunc
+}
+
+type Logger interface {
+	Info(msg string)
+	Error(msg string)
 }
 
-type Logger interface { // TODO
+// Application is the calendar API exposed over HTTP through the gRPC gateway,
+// so that the HTTP routes are always generated from api/EventService.proto.
+type Application interface {
+	eventpb.EventServiceServer
+	// Ready fails while the calendar cannot serve requests, such as while its storage is unavailable.
+	Ready() error
 }
 
-type Application interface { // TODO
+// Authenticator resolves the value of the Authorization header to the identity of a user.
+type Authenticator interface {
+	Authenticate(ctx context.Context, credential string) (auth.Identity, error)
 }
 
-func NewServer(logger Logger, app Application) *Server {
-	return &Server{}
+// RateLimiter reports whether a request keyed by user ID or client IP may proceed
+// and, if not, how long the client should wait.
+type RateLimiter interface {
+	Allow(key string) (ok bool, retryAfter time.Duration)
+}
+
+// NewServer builds the HTTP API. limiter may be nil to disable rate limiting
+// and tracer may be nil to disable tracing. The calendars are also served over CalDAV
+// under /caldav/ if calendar is not nil.
+func NewServer(logger Logger, app Application, auth Authenticator, limiter RateLimiter, tracer *tracing.Tracer,
+	calendar caldav.Application, host, port string) (*Server, error) {
+	s := &Server{
+		logger:  logger,
+		app:     app,
+		auth:    auth,
+		limiter: limiter,
+	}
+
+	gateway := runtime.NewServeMux()
+	if err := eventpb.RegisterEventServiceHandlerServer(context.Background(), gateway, app); err != nil {
+		return nil, err
+	}
+	s.gateway = gateway
+	if err := gateway.HandlePath(http.MethodPost, "/events/{event_id}/attachments", s.uploadAttachment); err != nil {
+		return nil, err
+	}
+	if err := gateway.HandlePath(http.MethodGet, "/events/{event_id}/attachments/{id}", s.downloadAttachment); err != nil {
+		return nil, err
+	}
+
+	protected := http.NewServeMux()
+	protected.HandleFunc("/hello", s.hello)
+	protected.HandleFunc("/events/stream", s.streamEvents)
+	protected.HandleFunc("/events/export", s.exportEvents)
+	protected.Handle("/", gateway)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openapi.json", s.openAPI)
+	mux.HandleFunc("/readyz", s.ready)
+	mux.Handle("/", s.withAuth(bearerChallenge, protected))
+	if calendar != nil {
+		mux.Handle(caldavPrefix, s.withAuth(basicChallenge, caldav.NewHandler(logger, calendar, caldavPrefix)))
+		// Calendar apps find the service from the host name alone (RFC 6764).
+		mux.Handle("/.well-known/caldav", http.RedirectHandler(caldavPrefix, http.StatusMovedPermanently))
+	}
+
+	s.srv = &http.Server{
+		Addr:              net.JoinHostPort(host, port),
+		Handler:           tracingMiddleware(tracer, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.streams, s.closeStreams = context.WithCancel(context.Background())
+	s.srv.RegisterOnShutdown(s.closeStreams)
+	return s, nil
 }
 
 func (s *Server) Start(ctx context.Context) error {
-	// TODO
+	s.logger.Info("http server is listening on " + s.srv.Addr)
+	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 	<-ctx.Done()
 	return nil
 }
 
 func (s *Server) Stop(ctx context.Context) error {
-	// TODO
-	return nil
+	return s.srv.Shutdown(ctx)
+}
+
+// withAuth guards next with authentication, asking clients without credentials for them
+// with the given challenge, and, when enabled, with rate limits per client IP (before
+// credentials are checked) and per authenticated user.
+func (s *Server) withAuth(challenge string, next http.Handler) http.Handler {
+	if s.limiter == nil {
+		return authMiddleware(s.logger, s.auth, challenge, next)
+	}
+	next = rateLimitMiddleware(s.limiter, userIDKey, next)
+	next = authMiddleware(s.logger, s.auth, challenge, next)
+	return rateLimitMiddleware(s.limiter, clientIPKey, next)
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(eventpb.OpenAPI)
 }
 
-// TODO
+type readiness struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ready tells load balancers whether to route requests to this replica: it responds
+// with 503 and the status "degraded" while the calendar cannot serve requests.
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := readiness{Status: "ok"}
+	if err := s.app.Ready(); err != nil {
+		resp = readiness{Status: "degraded", Error: err.Error()}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) hello(w http.ResponseWriter, r *http.Request) {
+	userID, _ := app.UserIDFromContext(r.Context())
+	fmt.Fprintf(w, "Hello, %s!\n", userID)
+}
@@ -0,0 +1,463 @@
+package internalhttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/app"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/blob"
+	internalgrpc "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/server/grpc"
+	memorystorage "github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T, opts ...app.Option) *Server {
+	t.Helper()
+
+	calendar := app.New(nopLogger{}, memorystorage.New(), opts...)
+	api := internalgrpc.NewService(nopLogger{}, calendar)
+	auth := staticAuth{"Bearer alice": {UserID: "alice"}, "Basic YWxpY2U6azE=": {UserID: "alice"}}
+	s, err := NewServer(nopLogger{}, api, auth, nil, nil, calendar, "localhost", "0")
+	require.NoError(t, err)
+	return s
+}
+
+func do(s *Server, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer alice")
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestServer(t *testing.T) {
+	t.Run("gateway", func(t *testing.T) {
+		s := newTestServer(t)
+
+		w := do(s, http.MethodPost, "/events",
+			`{"title": "standup", "startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var created struct {
+			ID     string `json:"id"`
+			UserID string `json:"userId"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		require.NotEmpty(t, created.ID)
+		require.Equal(t, "alice", created.UserID)
+
+		w = do(s, http.MethodGet, "/events?period=DAY&date=2021-06-01T00:00:00Z", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), created.ID)
+
+		w = do(s, http.MethodDelete, "/events/"+created.ID, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = do(s, http.MethodDelete, "/events/"+created.ID, "")
+		require.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		w = do(s, http.MethodGet, "/events/deleted", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), created.ID)
+		require.Contains(t, w.Body.String(), "deletedAt")
+
+		w = do(s, http.MethodPost, "/events/"+created.ID+"/restore", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = do(s, http.MethodGet, "/events?period=DAY&date=2021-06-01T00:00:00Z", "")
+		require.Contains(t, w.Body.String(), created.ID)
+
+		w = do(s, http.MethodGet, "/events/"+created.ID+"/history", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var history struct {
+			Records []struct {
+				Actor  string `json:"actor"`
+				Action string `json:"action"`
+			} `json:"records"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+		require.Len(t, history.Records, 3)
+		require.Equal(t, "CREATED", history.Records[0].Action)
+		require.Equal(t, "DELETED", history.Records[1].Action)
+		require.Equal(t, "alice", history.Records[1].Actor)
+		require.Equal(t, "RESTORED", history.Records[2].Action)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		s := newTestServer(t)
+
+		w := do(s, http.MethodPost, "/events:batch", `{"atomic": false, "operations": [
+			{"create": {"title": "standup", "startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}},
+			{"delete": {"id": "missing"}}
+		]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			Results []struct {
+				Event *struct {
+					ID string `json:"id"`
+				} `json:"event"`
+				Code int `json:"code"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Results, 2)
+		require.NotEmpty(t, resp.Results[0].Event.ID)
+		require.Equal(t, 5, resp.Results[1].Code)
+	})
+
+	t.Run("free busy", func(t *testing.T) {
+		s := newTestServer(t)
+
+		w := do(s, http.MethodPost, "/events",
+			`{"title": "standup", "startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = do(s, http.MethodGet,
+			"/freebusy?userIds=alice&userIds=bob&from=2021-06-01T00:00:00Z&to=2021-06-02T00:00:00Z", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NotContains(t, w.Body.String(), "standup")
+
+		var resp struct {
+			Users []struct {
+				UserID string `json:"userId"`
+				Busy   []struct {
+					StartAt time.Time `json:"startAt"`
+					EndAt   time.Time `json:"endAt"`
+				} `json:"busy"`
+			} `json:"users"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Users, 2)
+		require.Equal(t, "alice", resp.Users[0].UserID)
+		require.Len(t, resp.Users[0].Busy, 1)
+		require.Equal(t, time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), resp.Users[0].Busy[0].StartAt)
+		require.Empty(t, resp.Users[1].Busy)
+
+		w = do(s, http.MethodGet, "/freebusy?from=2021-06-01T00:00:00Z&to=2021-06-02T00:00:00Z", "")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("working hours", func(t *testing.T) {
+		s := newTestServer(t)
+
+		w := do(s, http.MethodPut, "/working-hours",
+			`{"timeZone": "UTC", "days": ["MONDAY", "TUESDAY"], "start": "09:00", "end": "17:30"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = do(s, http.MethodGet, "/working-hours", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.JSONEq(t, `{"userId": "alice", "timeZone": "UTC", "days": ["MONDAY", "TUESDAY"],
+			"start": "09:00", "end": "17:30", "holidays": ""}`, w.Body.String())
+		w = do(s, http.MethodPut, "/working-hours", `{"timeZone": "UTC", "start": "9am", "end": "17:00"}`)
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = do(s, http.MethodPost, "/events?checkWorkingHours=true",
+			`{"title": "late", "startAt": "2021-06-01T17:00:00Z", "endAt": "2021-06-01T18:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), "outside the working hours")
+
+		w = do(s, http.MethodGet,
+			"/freeslots?userIds=alice&from=2021-06-01T00:00:00Z&to=2021-06-02T00:00:00Z&duration=3600s", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.JSONEq(t, `{"slots": [{"startAt": "2021-06-01T09:00:00Z", "endAt": "2021-06-01T17:00:00Z"}]}`,
+			w.Body.String())
+
+		w = do(s, http.MethodGet, "/holidays", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("export", func(t *testing.T) {
+		s := newTestServer(t)
+
+		for _, hour := range []string{"10", "12"} {
+			w := do(s, http.MethodPost, "/events", `{"title": "standup", "startAt": "2021-06-01T`+hour+`:00:00Z", `+
+				`"endAt": "2021-06-01T`+hour+`:30:00Z"}`)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		}
+
+		period := "from=2021-06-01T00:00:00Z&to=2021-06-02T00:00:00Z"
+		w := do(s, http.MethodGet, "/events/export?"+period, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		require.Len(t, lines, 3)
+		require.True(t, strings.HasPrefix(lines[0], "id,user_id,"))
+
+		w = do(s, http.MethodGet, "/events/export?format=jsonl&"+period, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 2)
+
+		w = do(s, http.MethodGet, "/events/export?format=xml&"+period, "")
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		w = do(s, http.MethodGet, "/events/export?from=2021-06-01T00:00:00Z", "")
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		w = do(s, http.MethodGet, "/events/export?allUsers=true&"+period, "")
+		require.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		s = newTestServer(t, app.WithAdmins("alice"))
+		w = do(s, http.MethodGet, "/events/export?allUsers=true&"+period, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "id,user_id,calendar_id,title,start_at,end_at,notify_before_seconds,description\n",
+			w.Body.String())
+	})
+
+	t.Run("calendars", func(t *testing.T) {
+		s := newTestServer(t)
+
+		w := do(s, http.MethodPost, "/calendars", `{"name": "Team", "shares": [{"userId": "bob", "role": "READER"}]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var team struct {
+			ID      string `json:"id"`
+			OwnerID string `json:"ownerId"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &team))
+		require.Equal(t, "alice", team.OwnerID)
+
+		w = do(s, http.MethodPost, "/calendars", `{"name": "Team", "shares": [{"userId": "bob"}]}`)
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = do(s, http.MethodPost, "/events", `{"title": "planning", "calendarId": "`+team.ID+`", `+
+			`"startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = do(s, http.MethodPost, "/events",
+			`{"title": "standup", "startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = do(s, http.MethodGet, "/events?period=DAY&date=2021-06-01T00:00:00Z&calendarIds="+team.ID, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), "planning")
+		require.NotContains(t, w.Body.String(), "standup")
+
+		w = do(s, http.MethodGet, "/calendars", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Contains(t, w.Body.String(), team.ID)
+
+		w = do(s, http.MethodDelete, "/calendars/"+team.ID, "")
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		w = do(s, http.MethodGet, "/events?period=DAY&date=2021-06-01T00:00:00Z&calendarIds=unknown", "")
+		require.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		store, err := blob.NewLocal(t.TempDir())
+		require.NoError(t, err)
+		s := newTestServer(t, app.WithBlobStore(store))
+
+		w := do(s, http.MethodPost, "/events", `{"title": "standup", "description": "**daily** <script>",
+			"links": [{"title": "call", "url": "https://example.com/call"}],
+			"startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var event struct {
+			ID              string `json:"id"`
+			DescriptionHTML string `json:"descriptionHtml"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &event))
+		require.Equal(t, "<p><strong>daily</strong> &lt;script&gt;</p>\n", event.DescriptionHTML)
+
+		w = do(s, http.MethodPost, "/events", `{"title": "bad link", "links": [{"url": "javascript:alert(1)"}],
+			"startAt": "2021-06-01T12:00:00Z", "endAt": "2021-06-01T13:00:00Z"}`)
+		require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		r := httptest.NewRequest(http.MethodPost, "/events/"+event.ID+"/attachments?name=notes.txt",
+			strings.NewReader("hello"))
+		r.Header.Set("Authorization", "Bearer alice")
+		r.Header.Set("Content-Type", "text/plain")
+		w = httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var attachment struct {
+			ID   string `json:"id"`
+			Size string `json:"size"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &attachment))
+		require.Equal(t, "5", attachment.Size)
+
+		w = do(s, http.MethodPost, "/events/"+event.ID+"/attachments?name=big.bin",
+			strings.Repeat("x", app.MaxAttachmentSize+1))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		w = do(s, http.MethodGet, "/events?period=DAY&date=2021-06-01T00:00:00Z", "")
+		require.Contains(t, w.Body.String(), attachment.ID)
+		require.Contains(t, w.Body.String(), "https://example.com/call")
+
+		w = do(s, http.MethodGet, "/events/"+event.ID+"/attachments/"+attachment.ID, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "hello", w.Body.String())
+		require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		require.Equal(t, `attachment; filename=notes.txt`, w.Header().Get("Content-Disposition"))
+
+		w = do(s, http.MethodDelete, "/events/"+event.ID+"/attachments/"+attachment.ID, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = do(s, http.MethodGet, "/events/"+event.ID+"/attachments/"+attachment.ID, "")
+		require.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("attachments disabled", func(t *testing.T) {
+		s := newTestServer(t)
+
+		w := do(s, http.MethodPost, "/events",
+			`{"title": "standup", "startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var event struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &event))
+
+		w = do(s, http.MethodPost, "/events/"+event.ID+"/attachments?name=notes.txt", "hello")
+		require.Equal(t, http.StatusNotImplemented, w.Code, w.Body.String())
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		s := newTestServer(t)
+		ts := httptest.NewServer(s.srv.Handler)
+		defer ts.Close()
+
+		r, err := http.NewRequest(http.MethodGet, ts.URL+"/events/stream", nil)
+		require.NoError(t, err)
+		r.Header.Set("Authorization", "Bearer alice")
+		resp, err := ts.Client().Do(r)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		w := do(s, http.MethodPost, "/events",
+			`{"title": "standup", "startAt": "2021-06-01T10:00:00Z", "endAt": "2021-06-01T11:00:00Z"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		lines := bufio.NewScanner(resp.Body)
+		require.True(t, lines.Scan())
+		require.Equal(t, "event: EVENT_CREATED", lines.Text())
+		require.True(t, lines.Scan())
+		require.True(t, strings.HasPrefix(lines.Text(), "data: "))
+		require.Contains(t, lines.Text(), `"title":"standup"`)
+	})
+
+	t.Run("stop closes event streams", func(t *testing.T) {
+		s := newTestServer(t)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go s.srv.Serve(ln)
+
+		r, err := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/events/stream", nil)
+		require.NoError(t, err)
+		r.Header.Set("Authorization", "Bearer alice")
+		resp, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, s.Stop(ctx))
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err, "the stream ends cleanly")
+	})
+
+	t.Run("stream error is json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		stream := &sseStream{ctx: context.Background(), w: w, flusher: w}
+
+		require.NoError(t, stream.sendError(status.New(codes.Aborted, "too slow\n\nevent: EVENT_DELETED")))
+		frames := strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n")
+		require.Len(t, frames, 1)
+		lines := strings.Split(frames[0], "\n")
+		require.Equal(t, []string{"event: error"}, lines[:1])
+		require.Len(t, lines, 2)
+
+		var body struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &body))
+		require.Equal(t, int(codes.Aborted), body.Code)
+		require.Equal(t, "too slow\n\nevent: EVENT_DELETED", body.Message)
+	})
+
+	t.Run("openapi is public", func(t *testing.T) {
+		s := newTestServer(t)
+
+		r := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var doc struct {
+			Paths map[string]interface{} `json:"paths"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		require.Contains(t, doc.Paths, "/events")
+		require.Contains(t, doc.Paths, "/events/{id}")
+	})
+
+	t.Run("readyz is public", func(t *testing.T) {
+		s := newTestServer(t)
+
+		r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+
+		s.app = degradedApp{s.app}
+		w = httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.JSONEq(t, `{"status":"degraded","error":"storage unavailable"}`, w.Body.String())
+	})
+
+	t.Run("caldav", func(t *testing.T) {
+		s := newTestServer(t)
+
+		r := httptest.NewRequest("PROPFIND", "/caldav/", nil)
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Equal(t, basicChallenge, w.Header().Get("WWW-Authenticate"))
+
+		r.Header.Set("Authorization", "Basic YWxpY2U6azE=")
+		r.Header.Set("Depth", "1")
+		w = httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusMultiStatus, w.Code)
+		require.Contains(t, w.Body.String(), "<href>/caldav/alice/</href>")
+
+		r = httptest.NewRequest(http.MethodGet, "/.well-known/caldav", nil)
+		w = httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusMovedPermanently, w.Code)
+		require.Equal(t, "/caldav/", w.Header().Get("Location"))
+	})
+
+	t.Run("gateway requires auth", func(t *testing.T) {
+		s := newTestServer(t)
+
+		r := httptest.NewRequest(http.MethodGet, "/events?period=DAY&date=2021-06-01T00:00:00Z", nil)
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+type degradedApp struct {
+	Application
+}
+
+func (degradedApp) Ready() error {
+	return app.ErrUnavailable
+}
@@ -0,0 +1,150 @@
+package internalhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/pkg/eventpb"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// sseHeartbeat is how often a comment is sent to keep idle streams open through proxies.
+const sseHeartbeat = 15 * time.Second
+
+// streamEvents serves the WatchEvents RPC as Server-Sent Events: every change is sent
+// as an event named after its kind with the JSON encoded EventChange as data.
+// Streams end when the client goes away or the server shuts down; a failure after the
+// stream has started is sent as an "error" event with the JSON encoded status as data.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream := &sseStream{ctx: ctx, w: w, flusher: flusher}
+
+	go func() {
+		ticker := time.NewTicker(sseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.streams.Done():
+				cancel()
+				return
+			case <-ticker.C:
+				if err := stream.write(": ping\n\n"); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := s.app.WatchEvents(&eventpb.WatchEventsRequest{}, stream)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	st := status.Convert(err)
+	if !stream.started() {
+		http.Error(w, st.Message(), runtime.HTTPStatusFromCode(st.Code()))
+		return
+	}
+	_ = stream.sendError(st)
+}
+
+// sseStream adapts an HTTP response to the server side of the WatchEvents stream.
+// Nothing is written to the response until the stream headers are sent.
+type sseStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sent    bool
+}
+
+func (s *sseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sseStream) SendHeader(metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sendHeader()
+	return nil
+}
+
+func (s *sseStream) Send(change *eventpb.EventChange) error {
+	data, err := protojson.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("event: %s\ndata: %s\n\n", change.GetKind(), data))
+}
+
+// sendError sends st the way the gateway encodes errors, so that its message cannot
+// break out of the data field.
+func (s *sseStream) sendError(st *status.Status) error {
+	data, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("event: error\ndata: %s\n\n", data))
+}
+
+func (s *sseStream) started() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sent
+}
+
+// write sends frame to the client, sending the headers first if needed.
+// Heartbeats are dropped until the stream has started.
+func (s *sseStream) write(frame string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.sent {
+		if frame[0] == ':' {
+			return nil
+		}
+		s.sendHeader()
+	}
+	if _, err := fmt.Fprint(s.w, frame); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseStream) sendHeader() {
+	if s.sent {
+		return
+	}
+	s.sent = true
+
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
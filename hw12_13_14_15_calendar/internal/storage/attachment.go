@@ -0,0 +1,16 @@
+package storage
+
+// Link is a URL related to an event, such as a video call or an agenda.
+type Link struct {
+	Title string
+	URL   string
+}
+
+// Attachment describes a file attached to an event. Its content is kept in a blob store,
+// the event only holds the metadata.
+type Attachment struct {
+	ID          string
+	Name        string
+	ContentType string
+	Size        int64
+}
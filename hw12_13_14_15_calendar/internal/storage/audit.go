@@ -0,0 +1,30 @@
+package storage
+
+import "time"
+
+type AuditAction string
+
+const (
+	AuditCreated  AuditAction = "created"
+	AuditUpdated  AuditAction = "updated"
+	AuditDeleted  AuditAction = "deleted"
+	AuditRestored AuditAction = "restored"
+)
+
+// AuditRecord describes a single change of an event.
+type AuditRecord struct {
+	EventID string
+	// UserID is the owner of the event, Actor is the user who made the change.
+	UserID  string
+	Actor   string
+	Action  AuditAction
+	At      time.Time
+	Changes []FieldChange
+}
+
+// FieldChange holds the values of an event field before and after a change.
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
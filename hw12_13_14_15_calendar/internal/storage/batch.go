@@ -0,0 +1,17 @@
+package storage
+
+type BatchOpKind int
+
+const (
+	BatchCreate BatchOpKind = iota + 1
+	BatchUpdate
+	// BatchDelete turns the event with Event.ID into a tombstone deleted at Event.DeletedAt.
+	// If Event.Version is set, it fails with ErrEventChanged unless the event still has that version.
+	BatchDelete
+)
+
+// BatchOp is one change of a batch applied with a single call to storage.
+type BatchOp struct {
+	Kind  BatchOpKind
+	Event Event
+}
@@ -0,0 +1,47 @@
+package storage
+
+import "errors"
+
+var (
+	ErrCalendarNotFound = errors.New("calendar not found")
+	ErrCalendarExists   = errors.New("calendar already exists")
+	// ErrCalendarNotEmpty is returned by deletions of calendars with events, including deleted
+	// ones, which could not be restored without their calendar.
+	ErrCalendarNotEmpty = errors.New("calendar has events")
+)
+
+// Role is the access a user has been given to a calendar of another user.
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleWriter Role = "writer"
+)
+
+// Calendar is a named set of events of its owner, such as work or personal,
+// which can be shared with other users.
+type Calendar struct {
+	ID      string
+	Name    string
+	OwnerID string
+	// Shares maps the IDs of users the calendar is shared with to their roles.
+	Shares map[string]Role
+}
+
+func (c Calendar) CanRead(userID string) bool {
+	return c.OwnerID == userID || c.Shares[userID] == RoleReader || c.Shares[userID] == RoleWriter
+}
+
+func (c Calendar) CanWrite(userID string) bool {
+	return c.OwnerID == userID || c.Shares[userID] == RoleWriter
+}
+
+// Members returns the owner of the calendar and the users it is shared with.
+func (c Calendar) Members() []string {
+	members := make([]string, 0, len(c.Shares)+1)
+	members = append(members, c.OwnerID)
+	for userID := range c.Shares {
+		members = append(members, userID)
+	}
+	return members
+}
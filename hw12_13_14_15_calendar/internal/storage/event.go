@@ -1,7 +1,68 @@
 package storage
 
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrEventNotFound = errors.New("event not found")
+	ErrEventExists   = errors.New("event already exists")
+	ErrDateBusy      = errors.New("time is already taken by another event")
+	// ErrEventChanged is returned by conditional updates of events changed since they were read.
+	ErrEventChanged = errors.New("event has been changed concurrently")
+)
+
 type Event struct {
-	ID    string
-	Title string
-	// TODO
+	ID          string
+	Title       string
+	StartAt     time.Time
+	EndAt       time.Time
+	Description string
+	UserID      string
+	// CalendarID is empty for events in the default calendar of the owner.
+	CalendarID   string
+	NotifyBefore time.Duration
+	// DeletedAt is set when the event has been deleted and is kept as a tombstone.
+	DeletedAt time.Time
+	// Links and Attachments are kept in the order they were added in.
+	Links       []Link
+	Attachments []Attachment
+	// Version is set by storages: an event is created with version 1 and every change
+	// of it, including deletion and restoration, increments the version. Updates of
+	// an event with a version other than 0 only succeed if the stored event has that version.
+	Version int64
+	// Trace is the trace context of the request that last created or changed the event,
+	// as written to a tracing.MapCarrier, so that its reminder continues the trace.
+	// Storages with a reminder outbox keep it only with the reminder jobs.
+	Trace map[string]string `json:",omitempty"`
+}
+
+// NotifyAt is when the owner should be reminded about the event.
+func (e Event) NotifyAt() time.Time {
+	return e.StartAt.Add(-e.NotifyBefore)
+}
+
+func (e Event) IsDeleted() bool {
+	return !e.DeletedAt.IsZero()
+}
+
+// Calendar returns the ID of the calendar of the event. The default calendar
+// of a user has the ID of the user.
+func (e Event) Calendar() string {
+	if e.CalendarID == "" {
+		return e.UserID
+	}
+	return e.CalendarID
+}
+
+// Overlaps reports whether e and other belong to the same user and intersect in time.
+func (e Event) Overlaps(other Event) bool {
+	return e.UserID == other.UserID && e.StartAt.Before(other.EndAt) && other.StartAt.Before(e.EndAt)
+}
+
+// Conflicts reports whether e and other take the same time in the same calendar,
+// which is not allowed. Events in different calendars of a user may overlap.
+func (e Event) Conflicts(other Event) bool {
+	return e.Calendar() == other.Calendar() && e.Overlaps(other)
 }
@@ -0,0 +1,10 @@
+package storage
+
+import "time"
+
+// Busy is the time taken by an event of a user, without any details of the event.
+type Busy struct {
+	UserID  string
+	StartAt time.Time
+	EndAt   time.Time
+}
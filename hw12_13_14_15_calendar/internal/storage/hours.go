@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrWorkingHoursNotFound = errors.New("working hours not set")
+
+// WorkingHours is when a user works: the same time of day on the given weekdays in the time zone
+// of the user, except the holidays of the holiday calendar the user follows.
+type WorkingHours struct {
+	UserID string
+	// TimeZone is an IANA time zone name, such as Europe/Berlin.
+	TimeZone string
+	Days     []time.Weekday
+	// Start and End are the working time of day as offsets from midnight.
+	Start time.Duration
+	End   time.Duration
+	// Holidays is the name of the holiday calendar, empty if the user works on holidays.
+	Holidays string
+}
+
+// Works reports whether the user works on the weekday.
+func (h WorkingHours) Works(day time.Weekday) bool {
+	for _, d := range h.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
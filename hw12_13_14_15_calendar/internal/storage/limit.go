@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTooManyEvents is returned by changes that would exceed the limit of live events
+// set with ContextWithEventLimit.
+var ErrTooManyEvents = errors.New("too many events")
+
+type limitKey struct{}
+
+// ContextWithEventLimit returns a copy of ctx in which storages allow at most limit live events:
+// creations, restorations and batches with any of them fail with ErrTooManyEvents and have
+// no effect if there would be more. The limit is checked together with the change,
+// so concurrent changes cannot exceed it.
+func ContextWithEventLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, limitKey{}, limit)
+}
+
+// EventLimitFromContext returns the limit of live events set in ctx or zero if there is none.
+func EventLimitFromContext(ctx context.Context) int {
+	limit, _ := ctx.Value(limitKey{}).(int)
+	return limit
+}
@@ -0,0 +1,92 @@
+package memorystorage
+
+import (
+	"context"
+	"sort"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+func (s *Storage) CreateCalendar(_ context.Context, calendar storage.Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.calendars[calendar.ID]; ok {
+		return storage.ErrCalendarExists
+	}
+	return s.setCalendar(calendar)
+}
+
+func (s *Storage) UpdateCalendar(_ context.Context, calendar storage.Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.calendars[calendar.ID]; !ok {
+		return storage.ErrCalendarNotFound
+	}
+	return s.setCalendar(calendar)
+}
+
+// DeleteCalendar removes the calendar if it has no events, not even deleted ones.
+func (s *Storage) DeleteCalendar(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.calendars[id]; !ok {
+		return storage.ErrCalendarNotFound
+	}
+	for _, event := range s.events {
+		if event.CalendarID == id {
+			return storage.ErrCalendarNotEmpty
+		}
+	}
+	if s.journal != nil {
+		if err := s.journal.write(journalEntry{RemovedCalendars: []string{id}}); err != nil {
+			return err
+		}
+	}
+	delete(s.calendars, id)
+	return nil
+}
+
+func (s *Storage) GetCalendar(_ context.Context, id string) (storage.Calendar, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	calendar, ok := s.calendars[id]
+	if !ok {
+		return storage.Calendar{}, storage.ErrCalendarNotFound
+	}
+	return calendar, nil
+}
+
+// ListCalendars returns the calendars the user owns or has been given access to, ordered by name.
+func (s *Storage) ListCalendars(_ context.Context, userID string) ([]storage.Calendar, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	calendars := make([]storage.Calendar, 0)
+	for _, calendar := range s.calendars {
+		if calendar.CanRead(userID) {
+			calendars = append(calendars, calendar)
+		}
+	}
+
+	sort.Slice(calendars, func(i, j int) bool {
+		if calendars[i].Name != calendars[j].Name {
+			return calendars[i].Name < calendars[j].Name
+		}
+		return calendars[i].ID < calendars[j].ID
+	})
+	return calendars, nil
+}
+
+func (s *Storage) setCalendar(calendar storage.Calendar) error {
+	if s.journal != nil {
+		if err := s.journal.write(journalEntry{Calendars: []storage.Calendar{calendar}}); err != nil {
+			return err
+		}
+	}
+	s.calendars[calendar.ID] = calendar
+	return nil
+}
@@ -0,0 +1,58 @@
+package memorystorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// exportBatch is how many events are copied under one lock while exporting.
+const exportBatch = 500
+
+// ExportEvents passes live events intersecting [from, to) of the user, or of all users if userID
+// is empty, to fn in no particular order. Only IDs of matching events are collected up front and
+// the lock is not held while fn runs, so events changed during the export may be skipped.
+func (s *Storage) ExportEvents(ctx context.Context, userID string, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	s.mu.RLock()
+	ids := make([]string, 0)
+	for id, event := range s.events {
+		if s.exported(event, userID, from, to) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	for len(ids) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := exportBatch
+		if n > len(ids) {
+			n = len(ids)
+		}
+
+		s.mu.RLock()
+		batch := make([]storage.Event, 0, n)
+		for _, id := range ids[:n] {
+			if event, ok := s.events[id]; ok && s.exported(event, userID, from, to) {
+				batch = append(batch, event)
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, event := range batch {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func (s *Storage) exported(event storage.Event, userID string, from, to time.Time) bool {
+	return !event.IsDeleted() && (userID == "" || event.UserID == userID) &&
+		event.StartAt.Before(to) && from.Before(event.EndAt)
+}
@@ -0,0 +1,32 @@
+package memorystorage
+
+import (
+	"context"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// SetWorkingHours replaces the working hours of the user.
+func (s *Storage) SetWorkingHours(_ context.Context, hours storage.WorkingHours) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal != nil {
+		if err := s.journal.write(journalEntry{WorkingHours: []storage.WorkingHours{hours}}); err != nil {
+			return err
+		}
+	}
+	s.hours[hours.UserID] = hours
+	return nil
+}
+
+func (s *Storage) GetWorkingHours(_ context.Context, userID string) (storage.WorkingHours, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hours, ok := s.hours[userID]
+	if !ok {
+		return storage.WorkingHours{}, storage.ErrWorkingHoursNotFound
+	}
+	return hours, nil
+}
@@ -0,0 +1,36 @@
+package memorystorage
+
+import (
+	"context"
+	"time"
+)
+
+type lease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+// AcquireLease gives the named lease to holder for ttl if it is free, has expired
+// or is already held by holder, in which case it is renewed. It reports whether holder has the lease.
+func (s *Storage) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if l, ok := s.leases[name]; ok && l.holder != holder && now.Before(l.expiresAt) {
+		return false, nil
+	}
+	s.leases[name] = lease{holder: holder, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+// ReleaseLease frees the named lease if it is held by holder, so another one can take it at once.
+func (s *Storage) ReleaseLease(_ context.Context, name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.leases[name]; ok && l.holder == holder {
+		delete(s.leases, name)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package memorystorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	journalFile  = "journal.jsonl"
+)
+
+// snapshot is the whole state of the storage after the journal entry Seq.
+type snapshot struct {
+	Seq          uint64
+	Events       []storage.Event
+	Calendars    []storage.Calendar
+	WorkingHours []storage.WorkingHours
+	Audit        map[string][]storage.AuditRecord
+}
+
+// journalEntry is one committed operation: the new state of the events, calendars and working hours
+// it changed, the ones it removed and the audit records it added.
+type journalEntry struct {
+	Seq              uint64
+	Events           []storage.Event        `json:",omitempty"`
+	Removed          []string               `json:",omitempty"`
+	Calendars        []storage.Calendar     `json:",omitempty"`
+	RemovedCalendars []string               `json:",omitempty"`
+	WorkingHours     []storage.WorkingHours `json:",omitempty"`
+	Audit            []storage.AuditRecord  `json:",omitempty"`
+}
+
+// journal is an append-only file of changes made since the last snapshot, one JSON entry per line.
+type journal struct {
+	file *os.File
+	seq  uint64
+	// size is the length of the complete entries in the file.
+	size int64
+}
+
+// Open returns a storage persisted in dir. Its state is loaded from the snapshot
+// and the journal of changes made after it, and every later change is appended to the journal.
+func Open(dir string) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := New()
+
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range snap.Events {
+		s.events[event.ID] = event
+	}
+	for _, calendar := range snap.Calendars {
+		s.calendars[calendar.ID] = calendar
+	}
+	for _, hours := range snap.WorkingHours {
+		s.hours[hours.UserID] = hours
+	}
+	for id, records := range snap.Audit {
+		s.audit[id] = records
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	seq, size, err := s.replay(file, snap.Seq)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.journal = &journal{file: file, seq: seq, size: size}
+	s.dir = dir
+	return s, nil
+}
+
+// Snapshot writes the whole state to the snapshot file and truncates the journal,
+// and does the same for every tenant.
+func (s *Storage) Snapshot() error {
+	if err := s.snapshotTenants(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	snap := snapshot{
+		Seq:          s.journal.seq,
+		Events:       make([]storage.Event, 0, len(s.events)),
+		Calendars:    make([]storage.Calendar, 0, len(s.calendars)),
+		WorkingHours: make([]storage.WorkingHours, 0, len(s.hours)),
+		Audit:        s.audit,
+	}
+	for _, event := range s.events {
+		snap.Events = append(snap.Events, event)
+	}
+	for _, calendar := range s.calendars {
+		snap.Calendars = append(snap.Calendars, calendar)
+	}
+	for _, hours := range s.hours {
+		snap.WorkingHours = append(snap.WorkingHours, hours)
+	}
+	dir := filepath.Dir(s.journal.file.Name())
+	if err := writeSnapshot(filepath.Join(dir, snapshotFile), snap); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	// Entries up to the snapshot are skipped on replay, so crashing before
+	// the journal is truncated loses nothing.
+	if err := s.journal.truncate(0); err != nil {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	return nil
+}
+
+// Close takes a final snapshot and closes the journal, and those of every tenant.
+func (s *Storage) Close(ctx context.Context) error {
+	if err := s.closeTenants(ctx); err != nil {
+		return err
+	}
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.Snapshot(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.journal.file.Close()
+	s.journal = nil
+	return err
+}
+
+// replay applies the journal entries written after the snapshot with sequence number after.
+// A torn last entry left by a crash is cut off. It returns the sequence number
+// of the last entry and the length of the complete entries.
+func (s *Storage) replay(file *os.File, after uint64) (uint64, int64, error) {
+	seq := after
+	offset := int64(0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return 0, 0, fmt.Errorf("read journal at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+		if entry.Seq <= after {
+			continue
+		}
+		for _, event := range entry.Events {
+			s.events[event.ID] = event
+		}
+		for _, id := range entry.Removed {
+			delete(s.events, id)
+		}
+		for _, calendar := range entry.Calendars {
+			s.calendars[calendar.ID] = calendar
+		}
+		for _, id := range entry.RemovedCalendars {
+			delete(s.calendars, id)
+		}
+		for _, hours := range entry.WorkingHours {
+			s.hours[hours.UserID] = hours
+		}
+		for _, record := range entry.Audit {
+			s.audit[record.EventID] = append(s.audit[record.EventID], record)
+		}
+		seq = entry.Seq
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return 0, 0, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	return seq, offset, nil
+}
+
+func (j *journal) write(entry journalEntry) error {
+	entry.Seq = j.seq + 1
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := j.file.Write(data); err != nil {
+		// Cut off the partially written entry, so it does not corrupt the ones after it.
+		j.truncate(j.size)
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		j.truncate(j.size)
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	j.seq = entry.Seq
+	j.size += int64(len(data))
+	return nil
+}
+
+func (j *journal) truncate(size int64) error {
+	if err := j.file.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	j.size = size
+	return nil
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot{}, nil
+	}
+	if err != nil {
+		return snapshot{}, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("read snapshot %s: %w", path, err)
+	}
+	return snap, nil
+}
+
+// writeSnapshot replaces the snapshot file atomically by renaming a fully written temporary file.
+func writeSnapshot(path string, snap snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
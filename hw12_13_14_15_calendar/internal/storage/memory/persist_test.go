@@ -0,0 +1,173 @@
+package memorystorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		t.Helper()
+
+		s, err := Open(t.TempDir())
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close(context.Background()) })
+		return s
+	})
+}
+
+func TestPersistentTenants(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	start := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	event := storage.Event{ID: "1", Title: "standup", StartAt: start, EndAt: start.Add(time.Hour), UserID: "user-1"}
+
+	s, err := Open(dir)
+	require.NoError(t, err)
+	acme, err := s.Tenant("acme")
+	require.NoError(t, err)
+	require.NoError(t, acme.CreateEvent(ctx, event))
+	require.NoError(t, s.Close(ctx))
+
+	s, err = Open(dir)
+	require.NoError(t, err)
+	defer s.Close(ctx)
+	_, err = s.GetEvent(ctx, "1")
+	require.ErrorIs(t, err, storage.ErrEventNotFound)
+	acme, err = s.Tenant("acme")
+	require.NoError(t, err)
+	got, err := acme.GetEvent(ctx, "1")
+	require.NoError(t, err)
+	event.Version = 1
+	require.Equal(t, event, got)
+
+	_, err = s.Tenant("../acme")
+	require.Error(t, err)
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	event := func(id string, hour int) storage.Event {
+		return storage.Event{
+			ID:      id,
+			Title:   "event " + id,
+			StartAt: start.Add(time.Duration(hour) * time.Hour),
+			EndAt:   start.Add(time.Duration(hour+1) * time.Hour),
+			UserID:  "user-1",
+		}
+	}
+	deletedAt := start.Add(-time.Hour)
+	work := storage.Calendar{ID: "work", Name: "Work", OwnerID: "user-1"}
+	hours := storage.WorkingHours{UserID: "user-1", TimeZone: "UTC", Days: []time.Weekday{time.Monday}, End: time.Hour}
+
+	// fill makes changes before and after a snapshot and leaves the storage open,
+	// as if the process crashed.
+	fill := func(t *testing.T, dir string) {
+		t.Helper()
+
+		s, err := Open(dir)
+		require.NoError(t, err)
+		require.NoError(t, s.CreateEvent(ctx, event("1", 0)))
+		require.NoError(t, s.CreateEvent(ctx, event("2", 1)))
+		require.NoError(t, s.AddAuditRecord(ctx, storage.AuditRecord{EventID: "1", Action: storage.AuditCreated}))
+		require.NoError(t, s.CreateCalendar(ctx, storage.Calendar{ID: "home", Name: "Home", OwnerID: "user-1"}))
+		require.NoError(t, s.SetWorkingHours(ctx, storage.WorkingHours{UserID: "user-1", TimeZone: "UTC"}))
+		require.NoError(t, s.Snapshot())
+
+		require.NoError(t, s.SetWorkingHours(ctx, hours))
+
+		require.NoError(t, s.CreateCalendar(ctx, work))
+		require.NoError(t, s.DeleteCalendar(ctx, "home"))
+
+		require.NoError(t, s.DeleteEvent(ctx, "2", deletedAt))
+		require.NoError(t, s.CreateEvent(ctx, event("3", 2)))
+		_, err = s.ApplyBatch(ctx, []storage.BatchOp{
+			{Kind: storage.BatchCreate, Event: event("4", 3)},
+			{Kind: storage.BatchCreate, Event: event("5", 3)},
+		}, true)
+		require.NoError(t, err)
+		purged, err := s.PurgeEvents(ctx, start.Add(90*time.Minute), time.Time{})
+		require.NoError(t, err)
+		require.Len(t, purged, 1)
+	}
+
+	check := func(t *testing.T, dir string) {
+		t.Helper()
+
+		s, err := Open(dir)
+		require.NoError(t, err)
+		defer s.Close(ctx)
+
+		events, err := s.ListEvents(ctx, "user-1", start, start.Add(24*time.Hour))
+		require.NoError(t, err)
+		created := event("3", 2)
+		created.Version = 1
+		require.Equal(t, []storage.Event{created}, events)
+
+		deleted, err := s.ListDeletedEvents(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+		require.Equal(t, "2", deleted[0].ID)
+
+		_, err = s.GetEvent(ctx, "4")
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+
+		records, err := s.ListAuditRecords(ctx, "1")
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+
+		calendars, err := s.ListCalendars(ctx, "user-1")
+		require.NoError(t, err)
+		require.Equal(t, []storage.Calendar{work}, calendars)
+
+		got, err := s.GetWorkingHours(ctx, "user-1")
+		require.NoError(t, err)
+		require.Equal(t, hours, got)
+	}
+
+	t.Run("journal replay", func(t *testing.T) {
+		dir := t.TempDir()
+		fill(t, dir)
+		check(t, dir)
+	})
+
+	t.Run("close takes snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		fill(t, dir)
+		check(t, dir)
+
+		info, err := os.Stat(filepath.Join(dir, journalFile))
+		require.NoError(t, err)
+		require.Zero(t, info.Size())
+		check(t, dir)
+	})
+
+	t.Run("torn last entry", func(t *testing.T) {
+		dir := t.TempDir()
+		fill(t, dir)
+
+		f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"Seq":100,"Events":[{"ID":"6"`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		check(t, dir)
+	})
+
+	t.Run("corrupted journal", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, journalFile), []byte("garbage\n"), 0o644))
+
+		_, err := Open(dir)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,28 @@
+package memorystorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// Stats counts the events; reminders are the ones with a notification time not before now.
+func (s *Storage) Stats(_ context.Context, now time.Time) (storage.Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats storage.Stats
+	for _, event := range s.events {
+		switch {
+		case event.IsDeleted():
+			stats.DeletedEvents++
+		default:
+			stats.Events++
+			if event.NotifyBefore > 0 && !event.NotifyAt().Before(now) {
+				stats.Reminders++
+			}
+		}
+	}
+	return stats, nil
+}
@@ -1,14 +1,396 @@
 package memorystorage
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
 
 type Storage struct {
-	// TODO
-	mu sync.RWMutex
+	mu        sync.RWMutex
+	events    map[string]storage.Event
+	calendars map[string]storage.Calendar
+	hours     map[string]storage.WorkingHours
+	audit     map[string][]storage.AuditRecord
+	// touched holds the state before the current operation of every event it changed,
+	// nil for events that did not exist.
+	touched map[string]*storage.Event
+	// journal receives every committed change; nil if the storage is not persistent.
+	journal *journal
+	// leases are not persisted, they only matter while the process runs.
+	leases map[string]lease
+	// tenants are the storages of the other tenants, see Tenant; dir is where s is persisted.
+	tenantsMu sync.Mutex
+	tenants   map[string]*Storage
+	dir       string
+	now       func() time.Time
 }
 
 func New() *Storage {
-	return &Storage{}
+	return &Storage{
+		events:    make(map[string]storage.Event),
+		calendars: make(map[string]storage.Calendar),
+		hours:     make(map[string]storage.WorkingHours),
+		audit:     make(map[string][]storage.AuditRecord),
+		touched:   make(map[string]*storage.Event),
+		leases:    make(map[string]lease),
+		tenants:   make(map[string]*Storage),
+		now:       time.Now,
+	}
+}
+
+func (s *Storage) CreateEvent(ctx context.Context, event storage.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(func() error {
+		if err := s.createEvent(event); err != nil {
+			return err
+		}
+		return s.checkEventLimit(ctx)
+	})
+}
+
+func (s *Storage) UpdateEvent(_ context.Context, event storage.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(func() error {
+		return s.updateEvent(event)
+	})
+}
+
+// DeleteEvent turns the event into a tombstone deleted at the given time.
+func (s *Storage) DeleteEvent(_ context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(func() error {
+		return s.deleteEvent(id, at, 0)
+	})
+}
+
+// ApplyBatch applies the operations in order and returns the error of each of them.
+// In atomic mode the batch is rolled back if any operation fails.
+func (s *Storage) ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(ops))
+	failed, created := false, false
+	for i, op := range ops {
+		switch op.Kind {
+		case storage.BatchCreate:
+			errs[i] = s.createEvent(op.Event)
+			created = created || errs[i] == nil
+		case storage.BatchUpdate:
+			errs[i] = s.updateEvent(op.Event)
+		case storage.BatchDelete:
+			errs[i] = s.deleteEvent(op.Event.ID, op.Event.DeletedAt, op.Event.Version)
+		default:
+			errs[i] = fmt.Errorf("unknown batch operation %d", op.Kind)
+		}
+		failed = failed || errs[i] != nil
+	}
+
+	if atomic && failed {
+		s.rollback()
+		return errs, nil
+	}
+	if created {
+		if err := s.checkEventLimit(ctx); err != nil {
+			s.rollback()
+			return nil, err
+		}
+	}
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// RestoreEvent brings a tombstoned event back if its time is still free.
+func (s *Storage) RestoreEvent(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(func() error {
+		event, ok := s.events[id]
+		if !ok || !event.IsDeleted() {
+			return storage.ErrEventNotFound
+		}
+		if s.isBusy(event) {
+			return storage.ErrDateBusy
+		}
+		event.DeletedAt = time.Time{}
+		event.Version++
+		s.set(event)
+		return s.checkEventLimit(ctx)
+	})
 }
 
-// TODO
+// PurgeEvents permanently removes events that ended before endedBefore
+// and tombstones deleted before deletedBefore. It returns the removed events.
+func (s *Storage) PurgeEvents(_ context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := make([]storage.Event, 0)
+	for id, event := range s.events {
+		if event.EndAt.Before(endedBefore) || (event.IsDeleted() && event.DeletedAt.Before(deletedBefore)) {
+			s.remove(id)
+			purged = append(purged, event)
+		}
+	}
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *Storage) GetEvent(_ context.Context, id string) (storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.events[id]
+	if !ok {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	return event, nil
+}
+
+// ListEvents returns live events of the user intersecting [from, to) ordered by start time.
+func (s *Storage) ListEvents(_ context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	period := storage.Event{UserID: userID, StartAt: from, EndAt: to}
+	events := make([]storage.Event, 0)
+	for _, event := range s.events {
+		if !event.IsDeleted() && event.Overlaps(period) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartAt.Before(events[j].StartAt)
+	})
+	return events, nil
+}
+
+// ListBusy returns the time taken by live events of the users intersecting [from, to)
+// in no particular order.
+func (s *Storage) ListBusy(_ context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		users[userID] = true
+	}
+	busy := make([]storage.Busy, 0)
+	for _, event := range s.events {
+		if users[event.UserID] && !event.IsDeleted() && event.StartAt.Before(to) && from.Before(event.EndAt) {
+			busy = append(busy, storage.Busy{UserID: event.UserID, StartAt: event.StartAt, EndAt: event.EndAt})
+		}
+	}
+	return busy, nil
+}
+
+// ListEventsToNotify returns live events with a notification time in [from, to).
+func (s *Storage) ListEventsToNotify(_ context.Context, from, to time.Time) ([]storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]storage.Event, 0)
+	for _, event := range s.events {
+		if event.IsDeleted() || event.NotifyBefore <= 0 {
+			continue
+		}
+		if notifyAt := event.NotifyAt(); !notifyAt.Before(from) && notifyAt.Before(to) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].NotifyAt().Before(events[j].NotifyAt())
+	})
+	return events, nil
+}
+
+// ListDeletedEvents returns tombstones of the user, most recently deleted first.
+func (s *Storage) ListDeletedEvents(_ context.Context, userID string) ([]storage.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]storage.Event, 0)
+	for _, event := range s.events {
+		if event.IsDeleted() && event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].DeletedAt.After(events[j].DeletedAt)
+	})
+	return events, nil
+}
+
+func (s *Storage) AddAuditRecord(_ context.Context, record storage.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal != nil {
+		if err := s.journal.write(journalEntry{Audit: []storage.AuditRecord{record}}); err != nil {
+			return err
+		}
+	}
+	s.audit[record.EventID] = append(s.audit[record.EventID], record)
+	return nil
+}
+
+// ListAuditRecords returns the history of the event in the order the changes were made.
+func (s *Storage) ListAuditRecords(_ context.Context, eventID string) ([]storage.AuditRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]storage.AuditRecord, len(s.audit[eventID]))
+	copy(records, s.audit[eventID])
+	return records, nil
+}
+
+func (s *Storage) createEvent(event storage.Event) error {
+	if _, ok := s.events[event.ID]; ok {
+		return storage.ErrEventExists
+	}
+	if s.isBusy(event) {
+		return storage.ErrDateBusy
+	}
+	event.Version = 1
+	s.set(event)
+	return nil
+}
+
+func (s *Storage) updateEvent(event storage.Event) error {
+	old, ok := s.events[event.ID]
+	if !ok || old.IsDeleted() {
+		return storage.ErrEventNotFound
+	}
+	if event.Version != 0 && event.Version != old.Version {
+		return storage.ErrEventChanged
+	}
+	if s.isBusy(event) {
+		return storage.ErrDateBusy
+	}
+	event.Version = old.Version + 1
+	s.set(event)
+	return nil
+}
+
+func (s *Storage) deleteEvent(id string, at time.Time, version int64) error {
+	event, ok := s.events[id]
+	if !ok || event.IsDeleted() {
+		return storage.ErrEventNotFound
+	}
+	if version != 0 && version != event.Version {
+		return storage.ErrEventChanged
+	}
+	event.DeletedAt = at
+	event.Version++
+	s.set(event)
+	return nil
+}
+
+// apply runs fn and commits its changes, discarding them if fn fails.
+func (s *Storage) apply(fn func() error) error {
+	if err := fn(); err != nil {
+		s.rollback()
+		return err
+	}
+	return s.commit()
+}
+
+// commit writes the changes of the current operation to the journal.
+// If that fails the changes are rolled back.
+func (s *Storage) commit() error {
+	if s.journal != nil && len(s.touched) > 0 {
+		var entry journalEntry
+		for id := range s.touched {
+			if event, ok := s.events[id]; ok {
+				entry.Events = append(entry.Events, event)
+			} else {
+				entry.Removed = append(entry.Removed, id)
+			}
+		}
+		if err := s.journal.write(entry); err != nil {
+			s.rollback()
+			return err
+		}
+	}
+	s.touched = make(map[string]*storage.Event)
+	return nil
+}
+
+// rollback returns the events changed by the current operation to their previous state.
+func (s *Storage) rollback() {
+	for id, old := range s.touched {
+		if old == nil {
+			delete(s.events, id)
+		} else {
+			s.events[id] = *old
+		}
+	}
+	s.touched = make(map[string]*storage.Event)
+}
+
+func (s *Storage) set(event storage.Event) {
+	s.touch(event.ID)
+	s.events[event.ID] = event
+}
+
+func (s *Storage) remove(id string) {
+	s.touch(id)
+	delete(s.events, id)
+}
+
+func (s *Storage) touch(id string) {
+	if _, ok := s.touched[id]; ok {
+		return
+	}
+	if old, ok := s.events[id]; ok {
+		s.touched[id] = &old
+	} else {
+		s.touched[id] = nil
+	}
+}
+
+// checkEventLimit fails if there are more live events than the limit set in ctx allows.
+func (s *Storage) checkEventLimit(ctx context.Context) error {
+	limit := storage.EventLimitFromContext(ctx)
+	if limit <= 0 {
+		return nil
+	}
+	live := 0
+	for _, event := range s.events {
+		if !event.IsDeleted() {
+			live++
+		}
+	}
+	if live > limit {
+		return fmt.Errorf("%w: at most %d live events are allowed", storage.ErrTooManyEvents, limit)
+	}
+	return nil
+}
+
+func (s *Storage) isBusy(event storage.Event) bool {
+	for _, other := range s.events {
+		if other.ID != event.ID && !other.IsDeleted() && other.Conflicts(event) {
+			return true
+		}
+	}
+	return false
+}
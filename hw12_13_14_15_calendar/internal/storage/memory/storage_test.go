@@ -1,7 +1,49 @@
 package memorystorage
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
 
 func TestStorage(t *testing.T) {
-	// TODO
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		t.Helper()
+		return New()
+	})
+}
+
+func TestTenants(t *testing.T) {
+	storagetest.RunTenants(t, func(t *testing.T) (storagetest.Storage, storagetest.Storage) {
+		t.Helper()
+
+		s := New()
+		a, err := s.Tenant("acme")
+		require.NoError(t, err)
+		return s, a
+	})
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s := New()
+	s.now = func() time.Time { return now }
+
+	ok, err := s.AcquireLease(ctx, "reminders", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	now = now.Add(time.Minute - time.Second)
+	ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	now = now.Add(time.Second)
+	ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok, "an expired lease is taken over")
 }
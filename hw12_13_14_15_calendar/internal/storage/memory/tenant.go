@@ -0,0 +1,69 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// tenantsDir is the directory of a persistent storage holding the directories of its tenants.
+const tenantsDir = "tenants"
+
+// Tenant returns the storage of the given tenant, creating it on first use. Tenants share nothing:
+// each has its own maps and, if s is persistent, its own snapshot and journal in a subdirectory
+// of the directory of s. s itself is the storage of the default tenant "".
+func (s *Storage) Tenant(id string) (*Storage, error) {
+	if id == "" {
+		return s, nil
+	}
+	if id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("invalid tenant ID %q", id)
+	}
+
+	s.tenantsMu.Lock()
+	defer s.tenantsMu.Unlock()
+
+	if t, ok := s.tenants[id]; ok {
+		return t, nil
+	}
+	t := New()
+	if s.dir != "" {
+		var err error
+		if t, err = Open(filepath.Join(s.dir, tenantsDir, id)); err != nil {
+			return nil, fmt.Errorf("open tenant %s: %w", id, err)
+		}
+	}
+	t.now = s.now
+	s.tenants[id] = t
+	return t, nil
+}
+
+// eachTenant calls fn with the storages of the tenants opened so far.
+func (s *Storage) eachTenant(fn func(id string, t *Storage) error) error {
+	s.tenantsMu.Lock()
+	tenants := make(map[string]*Storage, len(s.tenants))
+	for id, t := range s.tenants {
+		tenants[id] = t
+	}
+	s.tenantsMu.Unlock()
+
+	for id, t := range tenants {
+		if err := fn(id, t); err != nil {
+			return fmt.Errorf("tenant %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) snapshotTenants() error {
+	return s.eachTenant(func(_ string, t *Storage) error {
+		return t.Snapshot()
+	})
+}
+
+func (s *Storage) closeTenants(ctx context.Context) error {
+	return s.eachTenant(func(_ string, t *Storage) error {
+		return t.Close(ctx)
+	})
+}
@@ -0,0 +1,174 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+func (s *Storage) CreateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	return s.updateCalendar(ctx, calendar.ID, func(_ *view, old *storage.Calendar) (*storage.Calendar, error) {
+		if old != nil {
+			return nil, storage.ErrCalendarExists
+		}
+		return &calendar, nil
+	})
+}
+
+func (s *Storage) UpdateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	return s.updateCalendar(ctx, calendar.ID, func(_ *view, old *storage.Calendar) (*storage.Calendar, error) {
+		if old == nil {
+			return nil, storage.ErrCalendarNotFound
+		}
+		return &calendar, nil
+	})
+}
+
+// DeleteCalendar removes the calendar if it has no events, not even deleted ones. The events
+// of a calendar belong to its owner, whose indexes are watched, so the deletion is retried
+// if an event of the owner is changed concurrently.
+func (s *Storage) DeleteCalendar(ctx context.Context, id string) error {
+	return s.updateCalendar(ctx, id, func(v *view, old *storage.Calendar) (*storage.Calendar, error) {
+		if old == nil {
+			return nil, storage.ErrCalendarNotFound
+		}
+
+		var ids []string
+		for _, index := range []string{"events", "deleted"} {
+			indexed, err := v.rangeIDs(s.userKey(old.OwnerID, index), "-inf", "+inf")
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, indexed...)
+		}
+		events, err := v.getMany(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if event.CalendarID == id {
+				return nil, storage.ErrCalendarNotEmpty
+			}
+		}
+		return nil, nil
+	})
+}
+
+func (s *Storage) GetCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	calendar, err := s.getCalendar(ctx, s.client, id)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+	if calendar == nil {
+		return storage.Calendar{}, storage.ErrCalendarNotFound
+	}
+	return *calendar, nil
+}
+
+// ListCalendars returns the calendars the user owns or has been given access to, ordered by name.
+func (s *Storage) ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error) {
+	ids, err := s.client.SMembers(ctx, s.userKey(userID, "calendars")).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []storage.Calendar{}, nil
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, s.key("calendar", id))
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := make([]storage.Calendar, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var calendar storage.Calendar
+		if err := json.Unmarshal([]byte(data), &calendar); err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, calendar)
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		if calendars[i].Name != calendars[j].Name {
+			return calendars[i].Name < calendars[j].Name
+		}
+		return calendars[i].ID < calendars[j].ID
+	})
+	return calendars, nil
+}
+
+// updateCalendar replaces the calendar with the result of fn, or removes it if fn returns nil,
+// and moves the calendar between the indexes of its members. fn gets the current calendar
+// or nil if there is none, and a view of the events watched by the transaction.
+func (s *Storage) updateCalendar(ctx context.Context, id string,
+	fn func(v *view, old *storage.Calendar) (*storage.Calendar, error),
+) error {
+	key := s.key("calendar", id)
+	for i := 0; i < maxTxRetries; i++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			old, err := s.getCalendar(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			calendar, err := fn(newView(ctx, s, tx, tx), old)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if old != nil {
+					for _, userID := range old.Members() {
+						pipe.SRem(ctx, s.userKey(userID, "calendars"), id)
+					}
+				}
+				if calendar == nil {
+					pipe.Del(ctx, key)
+					return nil
+				}
+				data, err := json.Marshal(calendar)
+				if err != nil {
+					return err
+				}
+				pipe.Set(ctx, key, data, 0)
+				for _, userID := range calendar.Members() {
+					pipe.SAdd(ctx, s.userKey(userID, "calendars"), id)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// getCalendar returns nil if there is no calendar with the ID.
+func (s *Storage) getCalendar(ctx context.Context, cmd redis.Cmdable, id string) (*storage.Calendar, error) {
+	data, err := cmd.Get(ctx, s.key("calendar", id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var calendar storage.Calendar
+	if err := json.Unmarshal(data, &calendar); err != nil {
+		return nil, err
+	}
+	return &calendar, nil
+}
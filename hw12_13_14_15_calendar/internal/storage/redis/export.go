@@ -0,0 +1,57 @@
+package redisstorage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+// exportBatch is how many events are read in one round trip while exporting.
+const exportBatch = 500
+
+// ExportEvents passes live events intersecting [from, to) of the user, or of all users if userID
+// is empty, to fn in no particular order. The index of the user's events by start time, or of all
+// events by end time, is read page by page, so events changed during the export may be skipped.
+func (s *Storage) ExportEvents(ctx context.Context, userID string, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	key, by := s.key("ends"), &redis.ZRangeBy{Min: minScore(from), Max: "+inf"}
+	if userID != "" {
+		maxDuration, err := s.read(ctx).userMaxDuration(userID)
+		if err != nil {
+			return err
+		}
+		key = s.userKey(userID, "events")
+		by = &redis.ZRangeBy{
+			Min: strconv.FormatInt(from.Unix()-maxDuration-1, 10),
+			Max: strconv.FormatInt(to.Unix(), 10),
+		}
+	}
+
+	by.Count = exportBatch
+	for {
+		ids, err := s.client.ZRangeByScore(ctx, key, by).Result()
+		if err != nil {
+			return err
+		}
+		events, err := s.read(ctx).getMany(ids)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if event.IsDeleted() || (userID != "" && event.UserID != userID) ||
+				!event.StartAt.Before(to) || !from.Before(event.EndAt) {
+				continue
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if len(ids) < exportBatch {
+			return nil
+		}
+		by.Offset += exportBatch
+	}
+}
@@ -0,0 +1,35 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+// SetWorkingHours replaces the working hours of the user, kept as JSON under user:<id>:hours.
+func (s *Storage) SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error {
+	data, err := json.Marshal(hours)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.userKey(hours.UserID, "hours"), data, 0).Err()
+}
+
+func (s *Storage) GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error) {
+	data, err := s.client.Get(ctx, s.userKey(userID, "hours")).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return storage.WorkingHours{}, storage.ErrWorkingHoursNotFound
+	}
+	if err != nil {
+		return storage.WorkingHours{}, err
+	}
+
+	var hours storage.WorkingHours
+	if err := json.Unmarshal(data, &hours); err != nil {
+		return storage.WorkingHours{}, err
+	}
+	return hours, nil
+}
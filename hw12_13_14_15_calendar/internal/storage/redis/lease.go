@@ -0,0 +1,60 @@
+package redisstorage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AcquireLease gives the named lease to holder for ttl if it is free, has expired
+// or is already held by holder, in which case it is renewed. It reports whether holder has the lease.
+// Leases are kept under lease:<name> and expire by the clock of Redis.
+func (s *Storage) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+	err := s.watchLease(ctx, name, func(tx *redis.Tx, current string) error {
+		if current != "" && current != holder {
+			return nil
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, s.key("lease", name), holder, ttl)
+			return nil
+		})
+		acquired = err == nil
+		return err
+	})
+	return acquired, err
+}
+
+// ReleaseLease frees the named lease if it is held by holder, so another one can take it at once.
+func (s *Storage) ReleaseLease(ctx context.Context, name, holder string) error {
+	return s.watchLease(ctx, name, func(tx *redis.Tx, current string) error {
+		if current != holder {
+			return nil
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, s.key("lease", name))
+			return nil
+		})
+		return err
+	})
+}
+
+// watchLease runs fn with the current holder of the lease, empty if there is none,
+// in a transaction failing if the lease changes concurrently.
+func (s *Storage) watchLease(ctx context.Context, name string, fn func(tx *redis.Tx, current string) error) error {
+	key := s.key("lease", name)
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		return fn(tx, current)
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		// Someone else changed the lease first, so it is theirs now.
+		return nil
+	}
+	return err
+}
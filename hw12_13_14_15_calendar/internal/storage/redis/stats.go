@@ -0,0 +1,25 @@
+package redisstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// Stats counts the events in the indexes; reminders are the ones with a notification time
+// not before now, to the second.
+func (s *Storage) Stats(ctx context.Context, now time.Time) (storage.Stats, error) {
+	pipe := s.client.Pipeline()
+	all := pipe.ZCard(ctx, s.key("ends"))
+	deleted := pipe.ZCard(ctx, s.key("deleted"))
+	reminders := pipe.ZCount(ctx, s.key("notify"), minScore(now), "+inf")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return storage.Stats{}, err
+	}
+	return storage.Stats{
+		Events:        int(all.Val() - deleted.Val()),
+		DeletedEvents: int(deleted.Val()),
+		Reminders:     int(reminders.Val()),
+	}, nil
+}
@@ -0,0 +1,369 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+// maxTxRetries is how many times a transaction is retried when a watched key changes under it.
+const maxTxRetries = 1000
+
+var ErrConflict = errors.New("too many concurrent changes, try again")
+
+// errBatchFailed aborts the transaction of an atomic batch with a failed operation.
+var errBatchFailed = errors.New("batch failed")
+
+// Storage keeps every event as JSON under event:<id> and indexes them with sorted sets:
+// live events of a user by start time, tombstones of a user by deletion time, and all
+// events by end time, deletion time and notification time for cleanup and reminders.
+// Calendars are kept under calendar:<id> and indexed with a set per user they are visible to.
+// Changes are made in optimistic transactions watching every key they read.
+type Storage struct {
+	client *redis.Client
+	prefix string
+}
+
+// New returns a storage using the given Redis connection options.
+// Keys of different calendars sharing a database are separated by prefix.
+func New(options *redis.Options, prefix string) *Storage {
+	return &Storage{client: redis.NewClient(options), prefix: prefix}
+}
+
+// Tenant returns a storage of the given tenant sharing the connection of s, whose keys are
+// under the prefix <prefix>:tenant:<id>. s itself is the storage of the default tenant "".
+// Only s is to be closed.
+func (s *Storage) Tenant(id string) *Storage {
+	if id == "" {
+		return s
+	}
+	return &Storage{client: s.client, prefix: s.key("tenant", id)}
+}
+
+func (s *Storage) Connect(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// IsTransient reports whether err is a failure after which the call had no effect:
+// redis could not be connected to, was loading its dataset or failing over,
+// or the keys of the transaction kept changing concurrently.
+func (s *Storage) IsTransient(err error) bool {
+	if errors.Is(err, ErrConflict) {
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		msg := redisErr.Error()
+		return strings.HasPrefix(msg, "LOADING ") || strings.HasPrefix(msg, "MASTERDOWN ") ||
+			strings.HasPrefix(msg, "TRYAGAIN ")
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (s *Storage) Close(_ context.Context) error {
+	return s.client.Close()
+}
+
+func (s *Storage) CreateEvent(ctx context.Context, event storage.Event) error {
+	return s.update(ctx, func(v *view) error {
+		if err := v.createEvent(event); err != nil {
+			return err
+		}
+		return v.checkEventLimit()
+	})
+}
+
+func (s *Storage) UpdateEvent(ctx context.Context, event storage.Event) error {
+	return s.update(ctx, func(v *view) error {
+		return v.updateEvent(event)
+	})
+}
+
+// DeleteEvent turns the event into a tombstone deleted at the given time.
+func (s *Storage) DeleteEvent(ctx context.Context, id string, at time.Time) error {
+	return s.update(ctx, func(v *view) error {
+		return v.deleteEvent(id, at, 0)
+	})
+}
+
+// RestoreEvent brings a tombstoned event back if its time is still free.
+func (s *Storage) RestoreEvent(ctx context.Context, id string) error {
+	return s.update(ctx, func(v *view) error {
+		event, err := v.get(id)
+		if err != nil {
+			return err
+		}
+		if !event.IsDeleted() {
+			return storage.ErrEventNotFound
+		}
+		event.DeletedAt = time.Time{}
+		event.Version++
+		if err := v.checkBusy(event); err != nil {
+			return err
+		}
+		if err := v.put(event); err != nil {
+			return err
+		}
+		return v.checkEventLimit()
+	})
+}
+
+// ApplyBatch applies the operations in order in one transaction and returns the error
+// of each of them. In atomic mode nothing is written if any operation fails.
+func (s *Storage) ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error) {
+	var errs []error
+	err := s.update(ctx, func(v *view) error {
+		errs = make([]error, len(ops))
+		failed, created := false, false
+		for i, op := range ops {
+			switch op.Kind {
+			case storage.BatchCreate:
+				errs[i] = v.createEvent(op.Event)
+				created = created || errs[i] == nil
+			case storage.BatchUpdate:
+				errs[i] = v.updateEvent(op.Event)
+			case storage.BatchDelete:
+				errs[i] = v.deleteEvent(op.Event.ID, op.Event.DeletedAt, op.Event.Version)
+			default:
+				errs[i] = fmt.Errorf("unknown batch operation %d", op.Kind)
+			}
+			if errs[i] != nil && !isBusinessError(errs[i]) {
+				return errs[i]
+			}
+			failed = failed || errs[i] != nil
+		}
+		if atomic && failed {
+			return errBatchFailed
+		}
+		if created {
+			return v.checkEventLimit()
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// PurgeEvents permanently removes events that ended before endedBefore
+// and tombstones deleted before deletedBefore. It returns the removed events.
+func (s *Storage) PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error) {
+	var purged []storage.Event
+	err := s.update(ctx, func(v *view) error {
+		purged = make([]storage.Event, 0)
+		ended, err := v.rangeIDs(s.key("ends"), "-inf", maxScore(endedBefore))
+		if err != nil {
+			return err
+		}
+		deleted, err := v.rangeIDs(s.key("deleted"), "-inf", maxScore(deletedBefore))
+		if err != nil {
+			return err
+		}
+
+		events, err := v.getMany(append(ended, deleted...))
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if event.EndAt.Before(endedBefore) || (event.IsDeleted() && event.DeletedAt.Before(deletedBefore)) {
+				v.remove(event.ID)
+				purged = append(purged, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *Storage) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	return s.read(ctx).get(id)
+}
+
+// ListEvents returns live events of the user intersecting [from, to) ordered by start time.
+func (s *Storage) ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	return s.read(ctx).listEvents(userID, from, to)
+}
+
+// ListBusy returns the time taken by live events of the users intersecting [from, to)
+// in no particular order. Indexes of all users are read in two pipelined round trips.
+func (s *Storage) ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error) {
+	if len(userIDs) == 0 {
+		return []storage.Busy{}, nil
+	}
+
+	pipe := s.client.Pipeline()
+	durations := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		durations[i] = pipe.Get(ctx, s.userKey(userID, "maxduration"))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	ranges := make([]*redis.StringSliceCmd, len(userIDs))
+	for i, userID := range userIDs {
+		maxDuration, err := durations[i].Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		ranges[i] = pipe.ZRangeByScore(ctx, s.userKey(userID, "events"), &redis.ZRangeBy{
+			Min: strconv.FormatInt(from.Unix()-maxDuration-1, 10),
+			Max: strconv.FormatInt(to.Unix(), 10),
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, cmd := range ranges {
+		ids = append(ids, cmd.Val()...)
+	}
+	events, err := s.read(ctx).getMany(ids)
+	if err != nil {
+		return nil, err
+	}
+	busy := make([]storage.Busy, 0, len(events))
+	for _, event := range events {
+		if !event.IsDeleted() && event.StartAt.Before(to) && from.Before(event.EndAt) {
+			busy = append(busy, storage.Busy{UserID: event.UserID, StartAt: event.StartAt, EndAt: event.EndAt})
+		}
+	}
+	return busy, nil
+}
+
+// ListEventsToNotify returns live events with a notification time in [from, to).
+func (s *Storage) ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error) {
+	v := s.read(ctx)
+	ids, err := v.rangeIDs(s.key("notify"), minScore(from), maxScore(to))
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := v.getMany(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]storage.Event, 0, len(candidates))
+	for _, event := range candidates {
+		if notifyAt := event.NotifyAt(); !notifyAt.Before(from) && notifyAt.Before(to) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].NotifyAt().Before(events[j].NotifyAt())
+	})
+	return events, nil
+}
+
+// ListDeletedEvents returns tombstones of the user, most recently deleted first.
+func (s *Storage) ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error) {
+	v := s.read(ctx)
+	ids, err := s.client.ZRevRange(ctx, s.userKey(userID, "deleted"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	events, err := v.getMany(ids)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].DeletedAt.After(events[j].DeletedAt)
+	})
+	return events, nil
+}
+
+func (s *Storage) AddAuditRecord(ctx context.Context, record storage.AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.RPush(ctx, s.key("audit", record.EventID), data).Err()
+}
+
+// ListAuditRecords returns the history of the event in the order the changes were made.
+func (s *Storage) ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error) {
+	values, err := s.client.LRange(ctx, s.key("audit", eventID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]storage.AuditRecord, 0, len(values))
+	for _, value := range values {
+		var record storage.AuditRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// update runs fn in a transaction and commits the changes it made to the view,
+// retrying from scratch while keys it read are modified concurrently.
+func (s *Storage) update(ctx context.Context, fn func(v *view) error) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			v := newView(ctx, s, tx, tx)
+			if err := fn(v); err != nil {
+				return err
+			}
+			_, err := tx.TxPipelined(ctx, v.commit)
+			return err
+		})
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+func (s *Storage) read(ctx context.Context) *view {
+	return newView(ctx, s, s.client, nil)
+}
+
+func (s *Storage) key(parts ...string) string {
+	key := s.prefix
+	for _, part := range parts {
+		key += ":" + part
+	}
+	return key
+}
+
+func (s *Storage) userKey(userID, index string) string {
+	return s.key("user", userID, index)
+}
+
+func isBusinessError(err error) bool {
+	return errors.Is(err, storage.ErrEventNotFound) ||
+		errors.Is(err, storage.ErrEventExists) ||
+		errors.Is(err, storage.ErrDateBusy) ||
+		errors.Is(err, storage.ErrEventChanged)
+}
+
+// Scores are Unix seconds; ranges are widened to whole seconds and results filtered precisely.
+func score(t time.Time) float64 {
+	return float64(t.Unix())
+}
+
+func minScore(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func maxScore(t time.Time) string {
+	return strconv.FormatInt(t.Unix()+1, 10)
+}
@@ -0,0 +1,57 @@
+package redisstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/storagetest"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		t.Helper()
+
+		server := miniredis.RunT(t)
+		s := New(&redis.Options{Addr: server.Addr()}, "calendar")
+		require.NoError(t, s.Connect(context.Background()))
+		t.Cleanup(func() { s.Close(context.Background()) })
+		return s
+	})
+}
+
+func TestTenants(t *testing.T) {
+	storagetest.RunTenants(t, func(t *testing.T) (storagetest.Storage, storagetest.Storage) {
+		t.Helper()
+
+		server := miniredis.RunT(t)
+		s := New(&redis.Options{Addr: server.Addr()}, "calendar")
+		require.NoError(t, s.Connect(context.Background()))
+		t.Cleanup(func() { s.Close(context.Background()) })
+		return s, s.Tenant("acme")
+	})
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	s := New(&redis.Options{Addr: server.Addr()}, "calendar")
+	defer s.Close(ctx)
+
+	ok, err := s.AcquireLease(ctx, "reminders", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	server.FastForward(time.Minute - time.Second)
+	ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	server.FastForward(time.Second)
+	ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok, "an expired lease is taken over")
+}
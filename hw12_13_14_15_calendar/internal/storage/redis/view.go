@@ -0,0 +1,355 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/go-redis/redis/v8"
+)
+
+// view is the state of the storage as seen by one operation. In a transaction it
+// watches every key it reads and collects the changes to be committed at the end.
+type view struct {
+	ctx context.Context
+	s   *Storage
+	cmd redis.Cmdable
+	tx  *redis.Tx // nil for read-only views
+
+	watched  map[string]bool
+	state    map[string]*storage.Event // loaded or changed events, nil if there is none
+	original map[string]*storage.Event // events as they were before the transaction
+	dirty    map[string]bool
+	// maxDuration is the longest live event of a user in seconds, which bounds
+	// how early an event intersecting a period may start.
+	maxDuration      map[string]int64
+	dirtyMaxDuration map[string]bool
+}
+
+func newView(ctx context.Context, s *Storage, cmd redis.Cmdable, tx *redis.Tx) *view {
+	return &view{
+		ctx:              ctx,
+		s:                s,
+		cmd:              cmd,
+		tx:               tx,
+		watched:          make(map[string]bool),
+		state:            make(map[string]*storage.Event),
+		original:         make(map[string]*storage.Event),
+		dirty:            make(map[string]bool),
+		maxDuration:      make(map[string]int64),
+		dirtyMaxDuration: make(map[string]bool),
+	}
+}
+
+func (v *view) createEvent(event storage.Event) error {
+	if err := v.load([]string{event.ID}); err != nil {
+		return err
+	}
+	if v.state[event.ID] != nil {
+		return storage.ErrEventExists
+	}
+	if err := v.checkBusy(event); err != nil {
+		return err
+	}
+	event.Version = 1
+	return v.put(event)
+}
+
+func (v *view) updateEvent(event storage.Event) error {
+	old, err := v.get(event.ID)
+	if err != nil {
+		return err
+	}
+	if old.IsDeleted() {
+		return storage.ErrEventNotFound
+	}
+	if event.Version != 0 && event.Version != old.Version {
+		return storage.ErrEventChanged
+	}
+	if err := v.checkBusy(event); err != nil {
+		return err
+	}
+	event.Version = old.Version + 1
+	return v.put(event)
+}
+
+func (v *view) deleteEvent(id string, at time.Time, version int64) error {
+	event, err := v.get(id)
+	if err != nil {
+		return err
+	}
+	if event.IsDeleted() {
+		return storage.ErrEventNotFound
+	}
+	if version != 0 && version != event.Version {
+		return storage.ErrEventChanged
+	}
+	event.DeletedAt = at
+	event.Version++
+	return v.put(event)
+}
+
+func (v *view) checkBusy(event storage.Event) error {
+	events, err := v.listEvents(event.UserID, event.StartAt, event.EndAt)
+	if err != nil {
+		return err
+	}
+	for _, other := range events {
+		if other.ID != event.ID && other.Conflicts(event) {
+			return storage.ErrDateBusy
+		}
+	}
+	return nil
+}
+
+func (v *view) listEvents(userID string, from, to time.Time) ([]storage.Event, error) {
+	maxDuration, err := v.userMaxDuration(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := v.rangeIDs(v.s.userKey(userID, "events"),
+		strconv.FormatInt(from.Unix()-maxDuration-1, 10), strconv.FormatInt(to.Unix(), 10))
+	if err != nil {
+		return nil, err
+	}
+	for id := range v.dirty {
+		if event := v.state[id]; event != nil && event.UserID == userID {
+			ids = append(ids, id)
+		}
+	}
+
+	candidates, err := v.getMany(ids)
+	if err != nil {
+		return nil, err
+	}
+	period := storage.Event{UserID: userID, StartAt: from, EndAt: to}
+	events := make([]storage.Event, 0, len(candidates))
+	for _, event := range candidates {
+		if !event.IsDeleted() && event.Overlaps(period) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartAt.Before(events[j].StartAt)
+	})
+	return events, nil
+}
+
+func (v *view) get(id string) (storage.Event, error) {
+	if err := v.load([]string{id}); err != nil {
+		return storage.Event{}, err
+	}
+	event := v.state[id]
+	if event == nil {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	return *event, nil
+}
+
+// getMany returns the existing events among ids, each one once, in the order of ids.
+func (v *view) getMany(ids []string) ([]storage.Event, error) {
+	if err := v.load(ids); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(ids))
+	events := make([]storage.Event, 0, len(ids))
+	for _, id := range ids {
+		if event := v.state[id]; event != nil && !seen[id] {
+			seen[id] = true
+			events = append(events, *event)
+		}
+	}
+	return events, nil
+}
+
+func (v *view) load(ids []string) error {
+	missing := make([]string, 0, len(ids))
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := v.state[id]; !ok {
+			missing = append(missing, id)
+			keys = append(keys, v.s.key("event", id))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := v.watch(keys...); err != nil {
+		return err
+	}
+
+	values, err := v.cmd.MGet(v.ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			v.state[missing[i]] = nil
+			v.original[missing[i]] = nil
+			continue
+		}
+		var event storage.Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		original := event
+		v.state[missing[i]] = &event
+		v.original[missing[i]] = &original
+	}
+	return nil
+}
+
+// checkEventLimit fails if, with the changes made to the view, there are more live events
+// than the limit set in the context allows. The indexes of all events are watched, so
+// the transaction is retried if any event is changed concurrently.
+func (v *view) checkEventLimit() error {
+	limit := storage.EventLimitFromContext(v.ctx)
+	if limit <= 0 {
+		return nil
+	}
+	ends, deleted := v.s.key("ends"), v.s.key("deleted")
+	if err := v.watch(ends, deleted); err != nil {
+		return err
+	}
+	all, err := v.cmd.ZCard(v.ctx, ends).Result()
+	if err != nil {
+		return err
+	}
+	tombstones, err := v.cmd.ZCard(v.ctx, deleted).Result()
+	if err != nil {
+		return err
+	}
+
+	live := int(all - tombstones)
+	for id := range v.dirty {
+		if isLive(v.original[id]) {
+			live--
+		}
+		if isLive(v.state[id]) {
+			live++
+		}
+	}
+	if live > limit {
+		return fmt.Errorf("%w: at most %d live events are allowed", storage.ErrTooManyEvents, limit)
+	}
+	return nil
+}
+
+func isLive(event *storage.Event) bool {
+	return event != nil && !event.IsDeleted()
+}
+
+func (v *view) rangeIDs(key, min, max string) ([]string, error) {
+	if err := v.watch(key); err != nil {
+		return nil, err
+	}
+	return v.cmd.ZRangeByScore(v.ctx, key, &redis.ZRangeBy{Min: min, Max: max}).Result()
+}
+
+func (v *view) userMaxDuration(userID string) (int64, error) {
+	if d, ok := v.maxDuration[userID]; ok {
+		return d, nil
+	}
+	key := v.s.userKey(userID, "maxduration")
+	if err := v.watch(key); err != nil {
+		return 0, err
+	}
+
+	d, err := v.cmd.Get(v.ctx, key).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	v.maxDuration[userID] = d
+	return d, nil
+}
+
+func (v *view) watch(keys ...string) error {
+	if v.tx == nil {
+		return nil
+	}
+	fresh := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !v.watched[key] {
+			v.watched[key] = true
+			fresh = append(fresh, key)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	return v.tx.Watch(v.ctx, fresh...).Err()
+}
+
+func (v *view) put(event storage.Event) error {
+	if err := v.load([]string{event.ID}); err != nil {
+		return err
+	}
+	v.state[event.ID] = &event
+	v.dirty[event.ID] = true
+
+	if event.IsDeleted() {
+		return nil
+	}
+	maxDuration, err := v.userMaxDuration(event.UserID)
+	if err != nil {
+		return err
+	}
+	if d := int64(math.Ceil(event.EndAt.Sub(event.StartAt).Seconds())); d > maxDuration {
+		v.maxDuration[event.UserID] = d
+		v.dirtyMaxDuration[event.UserID] = true
+	}
+	return nil
+}
+
+func (v *view) remove(id string) {
+	v.state[id] = nil
+	v.dirty[id] = true
+}
+
+// commit queues the writes of all changes made to the view.
+func (v *view) commit(pipe redis.Pipeliner) error {
+	for id := range v.dirty {
+		if old := v.original[id]; old != nil {
+			pipe.ZRem(v.ctx, v.s.userKey(old.UserID, "events"), id)
+			pipe.ZRem(v.ctx, v.s.userKey(old.UserID, "deleted"), id)
+			pipe.ZRem(v.ctx, v.s.key("ends"), id)
+			pipe.ZRem(v.ctx, v.s.key("deleted"), id)
+			pipe.ZRem(v.ctx, v.s.key("notify"), id)
+		}
+
+		event := v.state[id]
+		if event == nil {
+			pipe.Del(v.ctx, v.s.key("event", id))
+			continue
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		pipe.Set(v.ctx, v.s.key("event", id), data, 0)
+		pipe.ZAdd(v.ctx, v.s.key("ends"), &redis.Z{Score: score(event.EndAt), Member: id})
+		if event.IsDeleted() {
+			deleted := &redis.Z{Score: score(event.DeletedAt), Member: id}
+			pipe.ZAdd(v.ctx, v.s.userKey(event.UserID, "deleted"), deleted)
+			pipe.ZAdd(v.ctx, v.s.key("deleted"), deleted)
+			continue
+		}
+		pipe.ZAdd(v.ctx, v.s.userKey(event.UserID, "events"), &redis.Z{Score: score(event.StartAt), Member: id})
+		if event.NotifyBefore > 0 {
+			pipe.ZAdd(v.ctx, v.s.key("notify"), &redis.Z{Score: score(event.NotifyAt()), Member: id})
+		}
+	}
+
+	for userID := range v.dirtyMaxDuration {
+		pipe.Set(v.ctx, v.s.userKey(userID, "maxduration"), v.maxDuration[userID], 0)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/lib/pq"
+)
+
+func (s *Storage) CreateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO calendars (id, name, owner_id, tenant_id) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, id) DO NOTHING`, calendar.ID, calendar.Name, calendar.OwnerID, s.tenant)
+		if err := checkAffected(res, err, storage.ErrCalendarExists); err != nil {
+			return err
+		}
+		return s.insertShares(ctx, tx, calendar)
+	})
+}
+
+func (s *Storage) UpdateCalendar(ctx context.Context, calendar storage.Calendar) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE calendars SET name = $2, owner_id = $3 WHERE id = $1 AND tenant_id = $4`,
+			calendar.ID, calendar.Name, calendar.OwnerID, s.tenant)
+		if err := checkAffected(res, err, storage.ErrCalendarNotFound); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM calendar_shares WHERE calendar_id = $1 AND tenant_id = $2`,
+			calendar.ID, s.tenant)
+		if err != nil {
+			return err
+		}
+		return s.insertShares(ctx, tx, calendar)
+	})
+}
+
+// DeleteCalendar removes the calendar if it has no events, not even deleted ones. The events
+// of a calendar belong to its owner, so the lock taken by changes of the events of the owner
+// keeps events from being added to the calendar while it is checked.
+func (s *Storage) DeleteCalendar(ctx context.Context, id string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var ownerID string
+		err := tx.QueryRowContext(ctx, `SELECT owner_id FROM calendars WHERE id = $1 AND tenant_id = $2 FOR UPDATE`,
+			id, s.tenant).Scan(&ownerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrCalendarNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.lockUser(ctx, tx, ownerID); err != nil {
+			return err
+		}
+
+		var used bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM events WHERE tenant_id = $2 AND calendar_id = $1)`,
+			id, s.tenant).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used {
+			return storage.ErrCalendarNotEmpty
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM calendars WHERE id = $1 AND tenant_id = $2`, id, s.tenant)
+		return err
+	})
+}
+
+func (s *Storage) GetCalendar(ctx context.Context, id string) (storage.Calendar, error) {
+	calendars, err := s.queryCalendars(ctx, `SELECT id, name, owner_id FROM calendars
+		WHERE id = $1 AND tenant_id = $2`, id, s.tenant)
+	if err != nil {
+		return storage.Calendar{}, err
+	}
+	if len(calendars) == 0 {
+		return storage.Calendar{}, storage.ErrCalendarNotFound
+	}
+	return calendars[0], nil
+}
+
+// ListCalendars returns the calendars the user owns or has been given access to, ordered by name.
+func (s *Storage) ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error) {
+	return s.queryCalendars(ctx, `SELECT id, name, owner_id FROM calendars
+		WHERE tenant_id = $2 AND (owner_id = $1 OR id IN (SELECT calendar_id FROM calendar_shares
+		WHERE tenant_id = $2 AND user_id = $1))
+		ORDER BY name, id`, userID, s.tenant)
+}
+
+// queryCalendars reads the calendars selected by query together with their shares.
+func (s *Storage) queryCalendars(ctx context.Context, query string, args ...interface{}) ([]storage.Calendar, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calendars := make([]storage.Calendar, 0)
+	ids := make([]string, 0)
+	for rows.Next() {
+		var calendar storage.Calendar
+		if err := rows.Scan(&calendar.ID, &calendar.Name, &calendar.OwnerID); err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, calendar)
+		ids = append(ids, calendar.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(calendars) == 0 {
+		return calendars, nil
+	}
+
+	shares, err := s.db.QueryContext(ctx, `SELECT calendar_id, user_id, role FROM calendar_shares
+		WHERE tenant_id = $2 AND calendar_id = ANY($1)`, pq.Array(ids), s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer shares.Close()
+
+	byID := make(map[string]*storage.Calendar, len(calendars))
+	for i := range calendars {
+		byID[calendars[i].ID] = &calendars[i]
+	}
+	for shares.Next() {
+		var (
+			calendarID, userID string
+			role               storage.Role
+		)
+		if err := shares.Scan(&calendarID, &userID, &role); err != nil {
+			return nil, err
+		}
+		calendar := byID[calendarID]
+		if calendar.Shares == nil {
+			calendar.Shares = make(map[string]storage.Role)
+		}
+		calendar.Shares[userID] = role
+	}
+	return calendars, shares.Err()
+}
+
+func (s *Storage) insertShares(ctx context.Context, tx *sql.Tx, calendar storage.Calendar) error {
+	for userID, role := range calendar.Shares {
+		_, err := tx.ExecContext(ctx, `INSERT INTO calendar_shares (calendar_id, user_id, role, tenant_id)
+			VALUES ($1, $2, $3, $4)`, calendar.ID, userID, string(role), s.tenant)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAffected returns errNone if the statement with result res changed no rows.
+func checkAffected(res sql.Result, err error, errNone error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNone
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package sqlstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// ExportEvents passes live events intersecting [from, to) of the user, or of all users if userID
+// is empty, to fn ordered by start time. Rows are streamed from a single query, which holds
+// a connection until the export ends.
+func (s *Storage) ExportEvents(ctx context.Context, userID string, from, to time.Time,
+	fn func(event storage.Event) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+eventColumns+` FROM events
+		WHERE tenant_id = $4 AND ($1 = '' OR user_id = $1) AND deleted_at IS NULL AND start_at < $3 AND end_at > $2
+		ORDER BY start_at`, userID, from, to, s.tenant)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,49 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/lib/pq"
+)
+
+// SetWorkingHours replaces the working hours of the user. Start and end are kept in microseconds.
+func (s *Storage) SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error {
+	days := make([]int64, 0, len(hours.Days))
+	for _, day := range hours.Days {
+		days = append(days, int64(day))
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO working_hours
+		(user_id, time_zone, days, start_at, end_at, holidays, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (tenant_id, user_id) DO UPDATE SET time_zone = EXCLUDED.time_zone, days = EXCLUDED.days,
+		start_at = EXCLUDED.start_at, end_at = EXCLUDED.end_at, holidays = EXCLUDED.holidays`,
+		hours.UserID, hours.TimeZone, pq.Array(days), hours.Start.Microseconds(), hours.End.Microseconds(),
+		hours.Holidays, s.tenant)
+	return err
+}
+
+func (s *Storage) GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error) {
+	var (
+		hours      = storage.WorkingHours{UserID: userID}
+		days       []int64
+		start, end int64
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT time_zone, days, start_at, end_at, holidays
+		FROM working_hours WHERE user_id = $1 AND tenant_id = $2`, userID, s.tenant).
+		Scan(&hours.TimeZone, pq.Array(&days), &start, &end, &hours.Holidays)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.WorkingHours{}, storage.ErrWorkingHoursNotFound
+	}
+	if err != nil {
+		return storage.WorkingHours{}, err
+	}
+
+	for _, day := range days {
+		hours.Days = append(hours.Days, time.Weekday(day))
+	}
+	hours.Start, hours.End = time.Duration(start)*time.Microsecond, time.Duration(end)*time.Microsecond
+	return hours, nil
+}
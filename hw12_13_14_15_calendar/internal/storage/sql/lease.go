@@ -0,0 +1,31 @@
+package sqlstorage
+
+import (
+	"context"
+	"time"
+)
+
+// AcquireLease gives the named lease to holder for ttl if it is free, has expired
+// or is already held by holder, in which case it is renewed. It reports whether holder has the lease.
+// Expiry is checked by the clock of the database, so the clocks of the holders do not matter.
+func (s *Storage) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO leases (name, holder, expires_at)
+		VALUES ($1, $2, now() + $3 * INTERVAL '1 microsecond')
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at <= now()`,
+		name, holder, ttl.Microseconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ReleaseLease frees the named lease if it is held by holder, so another one can take it at once.
+func (s *Storage) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM leases WHERE name = $1 AND holder = $2`, name, holder)
+	return err
+}
@@ -0,0 +1,107 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/lib/pq"
+)
+
+// ConsumeReminders passes up to limit events whose reminders are due at until, earliest first,
+// to fn and removes their jobs from the outbox if fn succeeds. If fn fails the jobs stay
+// and are passed again on the next call, so no reminder is lost. Jobs being consumed are locked,
+// so concurrent consumers get different ones. It returns the number of consumed jobs.
+func (s *Storage) ConsumeReminders(ctx context.Context, until time.Time, limit int,
+	fn func(events []storage.Event) error,
+) (int, error) {
+	consumed := 0
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT event_id, event FROM reminder_outbox
+			WHERE tenant_id = $3 AND notify_at <= $1 ORDER BY notify_at LIMIT $2 FOR UPDATE SKIP LOCKED`,
+			until, limit, s.tenant)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var (
+			ids    []string
+			events []storage.Event
+		)
+		for rows.Next() {
+			var (
+				id    string
+				data  []byte
+				event storage.Event
+			)
+			if err := rows.Scan(&id, &data); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			events = append(events, event)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		if err := fn(events); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM reminder_outbox WHERE tenant_id = $2 AND event_id = ANY($1)`,
+			pq.Array(ids), s.tenant)
+		consumed = len(ids)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return consumed, nil
+}
+
+// syncReminder updates the reminder job of an event changed from old to event; old is the zero
+// Event for a new one. A job is removed once it has been consumed, so a new job is only written
+// if the notification time has changed. Otherwise a pending job just gets the new state of the event.
+func (s *Storage) syncReminder(ctx context.Context, tx *sql.Tx, old, event storage.Event) error {
+	switch {
+	case event.NotifyBefore <= 0:
+		return s.cancelReminder(ctx, tx, event.ID)
+	case old.NotifyBefore <= 0 || !old.NotifyAt().Equal(event.NotifyAt()):
+		return s.putReminder(ctx, tx, event)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE reminder_outbox SET event = $2 WHERE event_id = $1 AND tenant_id = $3`,
+		event.ID, data, s.tenant)
+	return err
+}
+
+// putReminder writes a job to notify about the event at its notification time,
+// replacing the pending one if there is any.
+func (s *Storage) putReminder(ctx context.Context, tx *sql.Tx, event storage.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO reminder_outbox (event_id, notify_at, event, tenant_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, event_id) DO UPDATE SET notify_at = EXCLUDED.notify_at, event = EXCLUDED.event`,
+		event.ID, event.NotifyAt(), data, s.tenant)
+	return err
+}
+
+func (s *Storage) cancelReminder(ctx context.Context, tx *sql.Tx, id string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM reminder_outbox WHERE event_id = $1 AND tenant_id = $2`, id, s.tenant)
+	return err
+}
@@ -0,0 +1,105 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)
+	event := storage.Event{
+		ID: "1", Title: "standup", StartAt: start, EndAt: start.Add(time.Hour),
+		UserID: "alice", NotifyBefore: 15 * time.Minute,
+	}
+	// Jobs keep the event as stored, with the version given on creation.
+	job := event
+	job.Version = 1
+	data, err := json.Marshal(job)
+	require.NoError(t, err)
+
+	newStorage := func(t *testing.T) (*Storage, sqlmock.Sqlmock) {
+		t.Helper()
+
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, mock.ExpectationsWereMet())
+			db.Close()
+		})
+		return (&Storage{db: db}).Tenant("acme"), mock
+	}
+
+	t.Run("event change writes job in same transaction", func(t *testing.T) {
+		s, mock := newStorage(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM events WHERE id = \$1 AND tenant_id = \$2 FOR UPDATE`).WithArgs("1", "acme").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(`pg_advisory_xact_lock`).WithArgs("acme", "alice").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO reminder_outbox`).
+			WithArgs("1", start.Add(-15*time.Minute), data, "acme").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, s.CreateEvent(ctx, event))
+	})
+
+	t.Run("failed outbox write rolls back event change", func(t *testing.T) {
+		s, mock := newStorage(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM events WHERE id = \$1 AND tenant_id = \$2 FOR UPDATE`).WithArgs("1", "acme").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(`pg_advisory_xact_lock`).WithArgs("acme", "alice").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO reminder_outbox`).WillReturnError(errors.New("disk full"))
+		mock.ExpectRollback()
+
+		require.Error(t, s.CreateEvent(ctx, event))
+	})
+
+	t.Run("consumed jobs are removed", func(t *testing.T) {
+		s, mock := newStorage(t)
+		until := start
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`FOR UPDATE SKIP LOCKED`)).WithArgs(until, 10, "acme").
+			WillReturnRows(sqlmock.NewRows([]string{"event_id", "event"}).AddRow("1", data))
+		mock.ExpectExec(`DELETE FROM reminder_outbox WHERE tenant_id = \$2 AND event_id = ANY`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		var got []storage.Event
+		n, err := s.ConsumeReminders(ctx, until, 10, func(events []storage.Event) error {
+			got = events
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		require.Equal(t, []storage.Event{job}, got)
+	})
+
+	t.Run("failed consumer keeps jobs", func(t *testing.T) {
+		s, mock := newStorage(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`FOR UPDATE SKIP LOCKED`)).
+			WillReturnRows(sqlmock.NewRows([]string{"event_id", "event"}).AddRow("1", data))
+		mock.ExpectRollback()
+
+		n, err := s.ConsumeReminders(ctx, start, 10, func([]storage.Event) error {
+			return errors.New("feed closed")
+		})
+		require.Error(t, err)
+		require.Zero(t, n)
+	})
+}
@@ -0,0 +1,23 @@
+package sqlstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+)
+
+// Stats counts the events; reminders are the jobs in the outbox, including due ones
+// not consumed yet, so now is not needed.
+func (s *Storage) Stats(ctx context.Context, _ time.Time) (storage.Stats, error) {
+	var stats storage.Stats
+	err := s.db.QueryRowContext(ctx, `SELECT
+		(SELECT count(*) FROM events WHERE tenant_id = $1 AND deleted_at IS NULL),
+		(SELECT count(*) FROM events WHERE tenant_id = $1 AND deleted_at IS NOT NULL),
+		(SELECT count(*) FROM reminder_outbox WHERE tenant_id = $1)`, s.tenant).
+		Scan(&stats.Events, &stats.DeletedEvents, &stats.Reminders)
+	if err != nil {
+		return storage.Stats{}, err
+	}
+	return stats, nil
+}
@@ -1,20 +1,520 @@
 package sqlstorage
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
 
-type Storage struct { // TODO
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/lib/pq"
+)
+
+// errBatchFailed rolls back the transaction of an atomic batch with a failed operation.
+var errBatchFailed = errors.New("batch failed")
+
+const (
+	eventColumns = "id, title, start_at, end_at, description, user_id, calendar_id, notify_before, deleted_at, " +
+		"links, attachments, version"
+	// notifyAt computes storage.Event.NotifyAt from the columns; notify_before is in nanoseconds.
+	notifyAt = "(start_at - notify_before / 1000 * INTERVAL '1 microsecond')"
+)
+
+// Storage keeps events in PostgreSQL; the schema is created by the migrations.
+// Every change of an event also updates its reminder job in the reminder_outbox table
+// in the same transaction, see ConsumeReminders.
+// Every row belongs to a tenant and every query of a Storage filters on its tenant, see Tenant.
+type Storage struct {
+	dsn    string
+	db     *sql.DB
+	tenant string
 }
 
-func New() *Storage {
-	return &Storage{}
+func New(dsn string) *Storage {
+	return &Storage{dsn: dsn}
 }
 
 func (s *Storage) Connect(ctx context.Context) error {
-	// TODO
+	db, err := sql.Open("postgres", s.dsn)
+	if err != nil {
+		return err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+	s.db = db
+	return nil
+}
+
+// Tenant returns a storage of the rows of the given tenant sharing the connections of s,
+// which must be connected. A storage returned by New belongs to the default tenant "".
+func (s *Storage) Tenant(id string) *Storage {
+	return &Storage{dsn: s.dsn, db: s.db, tenant: id}
+}
+
+func (s *Storage) Close(_ context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// IsTransient reports whether err is a failure after which the call had no effect:
+// the database could not be connected to or rolled the transaction back on a serialization
+// failure or a deadlock.
+func (s *Storage) IsTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", // serialization_failure, deadlock_detected
+			"08001", "08004", // the connection could not be established or was rejected
+			"53300", "57P03": // too_many_connections, cannot_connect_now
+			return true
+		}
+		return false
+	}
+	var opErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (s *Storage) CreateEvent(ctx context.Context, event storage.Event) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := s.createEvent(ctx, tx, event); err != nil {
+			return err
+		}
+		return s.checkEventLimit(ctx, tx)
+	})
+}
+
+func (s *Storage) UpdateEvent(ctx context.Context, event storage.Event) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.updateEvent(ctx, tx, event)
+	})
+}
+
+// DeleteEvent turns the event into a tombstone deleted at the given time.
+func (s *Storage) DeleteEvent(ctx context.Context, id string, at time.Time) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.deleteEvent(ctx, tx, id, at, 0)
+	})
+}
+
+// RestoreEvent brings a tombstoned event back if its time is still free. Its reminder
+// is scheduled again unless it had been due before the event was deleted.
+func (s *Storage) RestoreEvent(ctx context.Context, id string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		deleted, err := s.getEvent(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if !deleted.IsDeleted() {
+			return storage.ErrEventNotFound
+		}
+
+		event := deleted
+		event.DeletedAt = time.Time{}
+		if err := s.checkBusy(ctx, tx, event); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE events SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND tenant_id = $2`, id, s.tenant)
+		if err != nil {
+			return err
+		}
+		if event.NotifyBefore > 0 && !event.NotifyAt().Before(deleted.DeletedAt) {
+			if err := s.putReminder(ctx, tx, event); err != nil {
+				return err
+			}
+		}
+		return s.checkEventLimit(ctx, tx)
+	})
+}
+
+// ApplyBatch applies the operations in order in one transaction and returns the error
+// of each of them. In atomic mode nothing is written if any operation fails.
+func (s *Storage) ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error) {
+	errs := make([]error, len(ops))
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		failed, created := false, false
+		for i, op := range ops {
+			switch op.Kind {
+			case storage.BatchCreate:
+				errs[i] = s.createEvent(ctx, tx, op.Event)
+				created = created || errs[i] == nil
+			case storage.BatchUpdate:
+				errs[i] = s.updateEvent(ctx, tx, op.Event)
+			case storage.BatchDelete:
+				errs[i] = s.deleteEvent(ctx, tx, op.Event.ID, op.Event.DeletedAt, op.Event.Version)
+			default:
+				errs[i] = fmt.Errorf("unknown batch operation %d", op.Kind)
+			}
+			// Business errors are detected before anything is written, so the transaction
+			// can go on; any other error aborts it.
+			if errs[i] != nil && !isBusinessError(errs[i]) {
+				return errs[i]
+			}
+			failed = failed || errs[i] != nil
+		}
+		if atomic && failed {
+			return errBatchFailed
+		}
+		if created {
+			return s.checkEventLimit(ctx, tx)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// PurgeEvents permanently removes events that ended before endedBefore
+// and tombstones deleted before deletedBefore. It returns the removed events.
+func (s *Storage) PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error) {
+	const purged = "tenant_id = $3 AND (end_at < $1 OR deleted_at < $2)"
+
+	var events []storage.Event
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM reminder_outbox
+			WHERE tenant_id = $3 AND event_id IN (SELECT id FROM events WHERE `+purged+`)`,
+			endedBefore, deletedBefore, s.tenant)
+		if err != nil {
+			return err
+		}
+		events, err = queryEvents(ctx, tx, `DELETE FROM events WHERE `+purged+` RETURNING `+eventColumns,
+			endedBefore, deletedBefore, s.tenant)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *Storage) GetEvent(ctx context.Context, id string) (storage.Event, error) {
+	return s.getEvent(ctx, s.db, id, false)
+}
+
+// ListEvents returns live events of the user intersecting [from, to) ordered by start time.
+func (s *Storage) ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error) {
+	return s.queryEvents(ctx, `SELECT `+eventColumns+` FROM events
+		WHERE tenant_id = $4 AND user_id = $1 AND deleted_at IS NULL AND start_at < $3 AND end_at > $2
+		ORDER BY start_at`, userID, from, to, s.tenant)
+}
+
+// ListBusy returns the time taken by live events of the users intersecting [from, to)
+// in no particular order.
+func (s *Storage) ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, start_at, end_at FROM events
+		WHERE tenant_id = $4 AND user_id = ANY($1) AND deleted_at IS NULL AND start_at < $3 AND end_at > $2`,
+		pq.Array(userIDs), from, to, s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	busy := make([]storage.Busy, 0)
+	for rows.Next() {
+		var b storage.Busy
+		if err := rows.Scan(&b.UserID, &b.StartAt, &b.EndAt); err != nil {
+			return nil, err
+		}
+		b.StartAt, b.EndAt = b.StartAt.UTC(), b.EndAt.UTC()
+		busy = append(busy, b)
+	}
+	return busy, rows.Err()
+}
+
+// ListEventsToNotify returns live events with a notification time in [from, to).
+func (s *Storage) ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error) {
+	return s.queryEvents(ctx, `SELECT `+eventColumns+` FROM events
+		WHERE tenant_id = $3 AND deleted_at IS NULL AND notify_before > 0
+		AND `+notifyAt+` >= $1 AND `+notifyAt+` < $2
+		ORDER BY `+notifyAt, from, to, s.tenant)
+}
+
+// ListDeletedEvents returns tombstones of the user, most recently deleted first.
+func (s *Storage) ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error) {
+	return s.queryEvents(ctx, `SELECT `+eventColumns+` FROM events
+		WHERE tenant_id = $2 AND user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC`, userID, s.tenant)
+}
+
+func (s *Storage) AddAuditRecord(ctx context.Context, record storage.AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO audit_records (tenant_id, event_id, record) VALUES ($1, $2, $3)`,
+		s.tenant, record.EventID, data)
+	return err
+}
+
+// ListAuditRecords returns the history of the event in the order the changes were made.
+func (s *Storage) ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT record FROM audit_records WHERE tenant_id = $2 AND event_id = $1
+		ORDER BY id`, eventID, s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]storage.AuditRecord, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var record storage.AuditRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// inTx runs fn in a transaction which is committed if fn succeeds and rolled back otherwise.
+func (s *Storage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) queryEvents(ctx context.Context, query string, args ...interface{}) ([]storage.Event, error) {
+	return queryEvents(ctx, s.db, query, args...)
+}
+
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryEvents(ctx context.Context, q rowsQuerier, query string, args ...interface{}) ([]storage.Event, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]storage.Event, 0)
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *Storage) createEvent(ctx context.Context, tx *sql.Tx, event storage.Event) error {
+	_, err := s.getEvent(ctx, tx, event.ID, true)
+	switch {
+	case err == nil:
+		return storage.ErrEventExists
+	case !errors.Is(err, storage.ErrEventNotFound):
+		return err
+	}
+	if err := s.checkBusy(ctx, tx, event); err != nil {
+		return err
+	}
+
+	event.Version = 1
+	args, err := eventArgs(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO events (`+eventColumns+`, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`, append(args, s.tenant)...)
+	if err != nil {
+		return err
+	}
+	return s.syncReminder(ctx, tx, storage.Event{}, event)
+}
+
+func (s *Storage) updateEvent(ctx context.Context, tx *sql.Tx, event storage.Event) error {
+	old, err := s.getEvent(ctx, tx, event.ID, true)
+	if err != nil {
+		return err
+	}
+	if old.IsDeleted() {
+		return storage.ErrEventNotFound
+	}
+	if event.Version != 0 && event.Version != old.Version {
+		return storage.ErrEventChanged
+	}
+	if err := s.checkBusy(ctx, tx, event); err != nil {
+		return err
+	}
+
+	event.Version = old.Version + 1
+	args, err := eventArgs(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE events SET title = $2, start_at = $3, end_at = $4, description = $5,
+		user_id = $6, calendar_id = $7, notify_before = $8, deleted_at = $9, links = $10, attachments = $11,
+		version = $12 WHERE id = $1 AND tenant_id = $13`, append(args, s.tenant)...)
+	if err != nil {
+		return err
+	}
+	return s.syncReminder(ctx, tx, old, event)
+}
+
+func (s *Storage) deleteEvent(ctx context.Context, tx *sql.Tx, id string, at time.Time, version int64) error {
+	event, err := s.getEvent(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+	if event.IsDeleted() {
+		return storage.ErrEventNotFound
+	}
+	if version != 0 && version != event.Version {
+		return storage.ErrEventChanged
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE events SET deleted_at = $2, version = version + 1
+		WHERE id = $1 AND tenant_id = $3`, id, at, s.tenant)
+	if err != nil {
+		return err
+	}
+	return s.cancelReminder(ctx, tx, id)
+}
+
+// checkEventLimit fails if, with the changes made in tx, the tenant has more live events than
+// the limit set in ctx allows. Transactions checking the limit of a tenant are serialized with
+// a transaction-level advisory lock, so each of them counts the events the others have committed.
+func (s *Storage) checkEventLimit(ctx context.Context, tx *sql.Tx) error {
+	limit := storage.EventLimitFromContext(ctx)
+	if limit <= 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, s.tenant); err != nil {
+		return err
+	}
+
+	var live int
+	err := tx.QueryRowContext(ctx, `SELECT count(*) FROM events WHERE tenant_id = $1 AND deleted_at IS NULL`,
+		s.tenant).Scan(&live)
+	if err != nil {
+		return err
+	}
+	if live > limit {
+		return fmt.Errorf("%w: at most %d live events are allowed", storage.ErrTooManyEvents, limit)
+	}
 	return nil
 }
 
-func (s *Storage) Close(ctx context.Context) error {
-	// TODO
+// lockUser serializes changes of the events of a user with a transaction-level advisory lock.
+func (s *Storage) lockUser(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || '/' || $2))`, s.tenant, userID)
+	return err
+}
+
+// checkBusy locks the events of the user of the event, so concurrent transactions cannot
+// both take the same time.
+func (s *Storage) checkBusy(ctx context.Context, tx *sql.Tx, event storage.Event) error {
+	if err := s.lockUser(ctx, tx, event.UserID); err != nil {
+		return err
+	}
+
+	var busy bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM events
+		WHERE tenant_id = $6 AND user_id = $1 AND COALESCE(NULLIF(calendar_id, ''), user_id) = $2 AND id <> $3
+		AND deleted_at IS NULL AND start_at < $5 AND end_at > $4)`,
+		event.UserID, event.Calendar(), event.ID, event.StartAt, event.EndAt, s.tenant).Scan(&busy)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return storage.ErrDateBusy
+	}
 	return nil
 }
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// getEvent reads the event, locking its row until the end of the transaction if forUpdate is set.
+func (s *Storage) getEvent(ctx context.Context, q querier, id string, forUpdate bool) (storage.Event, error) {
+	query := `SELECT ` + eventColumns + ` FROM events WHERE id = $1 AND tenant_id = $2`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	event, err := scanEvent(q.QueryRowContext(ctx, query, id, s.tenant))
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Event{}, storage.ErrEventNotFound
+	}
+	return event, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row scanner) (storage.Event, error) {
+	var (
+		event        storage.Event
+		notifyBefore int64
+		deletedAt    sql.NullTime
+		links        []byte
+		attachments  []byte
+	)
+	err := row.Scan(&event.ID, &event.Title, &event.StartAt, &event.EndAt, &event.Description,
+		&event.UserID, &event.CalendarID, &notifyBefore, &deletedAt, &links, &attachments, &event.Version)
+	if err != nil {
+		return storage.Event{}, err
+	}
+	if err := json.Unmarshal(links, &event.Links); err != nil {
+		return storage.Event{}, err
+	}
+	if err := json.Unmarshal(attachments, &event.Attachments); err != nil {
+		return storage.Event{}, err
+	}
+	event.StartAt, event.EndAt = event.StartAt.UTC(), event.EndAt.UTC()
+	event.NotifyBefore = time.Duration(notifyBefore)
+	if deletedAt.Valid {
+		event.DeletedAt = deletedAt.Time.UTC()
+	}
+	return event, nil
+}
+
+// eventArgs returns the values of eventColumns for the event.
+func eventArgs(event storage.Event) ([]interface{}, error) {
+	links, err := json.Marshal(event.Links)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := json.Marshal(event.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		event.ID, event.Title, event.StartAt, event.EndAt, event.Description, event.UserID,
+		event.CalendarID, int64(event.NotifyBefore), nullTime(event.DeletedAt), links, attachments, event.Version,
+	}, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func isBusinessError(err error) bool {
+	return errors.Is(err, storage.ErrEventNotFound) ||
+		errors.Is(err, storage.ErrEventExists) ||
+		errors.Is(err, storage.ErrDateBusy) ||
+		errors.Is(err, storage.ErrEventChanged)
+}
@@ -0,0 +1,90 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage/storagetest"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorage runs against the PostgreSQL database from CALENDAR_TEST_POSTGRES_DSN;
+// its tables are recreated by the migrations for every test.
+func TestStorage(t *testing.T) {
+	dsn := os.Getenv("CALENDAR_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("CALENDAR_TEST_POSTGRES_DSN is not set")
+	}
+	ups, downs := migrations(t)
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		t.Helper()
+		return connect(t, dsn, ups, downs)
+	})
+	storagetest.RunTenants(t, func(t *testing.T) (storagetest.Storage, storagetest.Storage) {
+		t.Helper()
+
+		s := connect(t, dsn, ups, downs)
+		return s, s.Tenant("acme")
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	s := New("")
+
+	for err, transient := range map[error]bool{
+		&pq.Error{Code: "40001"}: true,
+		&pq.Error{Code: "57P03"}: true,
+		&pq.Error{Code: "23505"}: false,
+		fmt.Errorf("begin: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}): true,
+		&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}:                    false,
+		driver.ErrBadConn:        true,
+		storage.ErrEventNotFound: false,
+		context.Canceled:         false,
+	} {
+		require.Equal(t, transient, s.IsTransient(err), err.Error())
+	}
+}
+
+// connect returns a storage of the database with freshly migrated tables.
+func connect(t *testing.T, dsn string, ups, downs []string) *Storage {
+	t.Helper()
+
+	s := New(dsn)
+	require.NoError(t, s.Connect(context.Background()))
+	for _, down := range downs {
+		s.db.ExecContext(context.Background(), down)
+	}
+	for _, up := range ups {
+		_, err := s.db.ExecContext(context.Background(), up)
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+// migrations returns the up migrations in the order to apply them and the down ones in reverse.
+func migrations(t *testing.T) (ups, downs []string) {
+	t.Helper()
+
+	files, err := filepath.Glob("../../../migrations/*.sql")
+	require.NoError(t, err)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		require.NoError(t, err)
+		parts := strings.SplitN(string(migration), "-- +goose Down", 2)
+		require.Len(t, parts, 2, file)
+		ups = append(ups, parts[0])
+		downs = append([]string{parts[1]}, downs...)
+	}
+	return ups, downs
+}
@@ -0,0 +1,9 @@
+package storage
+
+// Stats are counts of what a storage holds, for operators.
+type Stats struct {
+	Events        int
+	DeletedEvents int
+	// Reminders is how many reminders are waiting to be sent.
+	Reminders int
+}
@@ -0,0 +1,733 @@
+// Package storagetest is a conformance test suite for implementations of the event storage.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fixme_my_friend/hw12_13_14_15_calendar/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage is the storage interface the application relies on.
+type Storage interface {
+	CreateEvent(ctx context.Context, event storage.Event) error
+	UpdateEvent(ctx context.Context, event storage.Event) error
+	DeleteEvent(ctx context.Context, id string, at time.Time) error
+	RestoreEvent(ctx context.Context, id string) error
+	PurgeEvents(ctx context.Context, endedBefore, deletedBefore time.Time) ([]storage.Event, error)
+	GetEvent(ctx context.Context, id string) (storage.Event, error)
+	ListEvents(ctx context.Context, userID string, from, to time.Time) ([]storage.Event, error)
+	ListDeletedEvents(ctx context.Context, userID string) ([]storage.Event, error)
+	ListEventsToNotify(ctx context.Context, from, to time.Time) ([]storage.Event, error)
+	ListBusy(ctx context.Context, userIDs []string, from, to time.Time) ([]storage.Busy, error)
+	ApplyBatch(ctx context.Context, ops []storage.BatchOp, atomic bool) ([]error, error)
+	AddAuditRecord(ctx context.Context, record storage.AuditRecord) error
+	ListAuditRecords(ctx context.Context, eventID string) ([]storage.AuditRecord, error)
+	CreateCalendar(ctx context.Context, calendar storage.Calendar) error
+	UpdateCalendar(ctx context.Context, calendar storage.Calendar) error
+	DeleteCalendar(ctx context.Context, id string) error
+	GetCalendar(ctx context.Context, id string) (storage.Calendar, error)
+	ListCalendars(ctx context.Context, userID string) ([]storage.Calendar, error)
+	SetWorkingHours(ctx context.Context, hours storage.WorkingHours) error
+	GetWorkingHours(ctx context.Context, userID string) (storage.WorkingHours, error)
+	ExportEvents(ctx context.Context, userID string, from, to time.Time, fn func(event storage.Event) error) error
+	Stats(ctx context.Context, now time.Time) (storage.Stats, error)
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+var day = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func event(id, userID string, startHour, hours int) storage.Event {
+	start := day.Add(time.Duration(startHour) * time.Hour)
+	return storage.Event{
+		ID:      id,
+		Title:   "event " + id,
+		StartAt: start,
+		EndAt:   start.Add(time.Duration(hours) * time.Hour),
+		UserID:  userID,
+	}
+}
+
+// Run checks that the storage created by newStorage behaves like every other implementation.
+// newStorage is called for every subtest and must return an empty storage.
+func Run(t *testing.T, newStorage func(t *testing.T) Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("crud", func(t *testing.T) {
+		s := newStorage(t)
+
+		e := event("1", "user-1", 10, 1)
+		require.NoError(t, s.CreateEvent(ctx, e))
+		require.ErrorIs(t, s.CreateEvent(ctx, e), storage.ErrEventExists)
+
+		got, err := s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		e.Version = 1
+		require.Equal(t, e, got)
+
+		e.Title = "renamed"
+		e.Links = []storage.Link{{Title: "agenda", URL: "https://example.com/agenda"}}
+		e.Attachments = []storage.Attachment{{ID: "a", Name: "notes.txt", ContentType: "text/plain", Size: 5}}
+		require.NoError(t, s.UpdateEvent(ctx, e))
+		got, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		e.Version = 2
+		require.Equal(t, e, got)
+
+		deletedAt := day.Add(time.Hour)
+		require.NoError(t, s.DeleteEvent(ctx, "1", deletedAt))
+		got, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, deletedAt, got.DeletedAt)
+		require.Equal(t, int64(3), got.Version)
+		require.ErrorIs(t, s.DeleteEvent(ctx, "1", deletedAt), storage.ErrEventNotFound)
+		require.ErrorIs(t, s.UpdateEvent(ctx, e), storage.ErrEventNotFound)
+		require.ErrorIs(t, s.CreateEvent(ctx, e), storage.ErrEventExists)
+
+		_, err = s.GetEvent(ctx, "2")
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		s := newStorage(t)
+
+		e := event("1", "user-1", 10, 1)
+		e.Version = 7
+		require.NoError(t, s.CreateEvent(ctx, e))
+		got, err := s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), got.Version, "the version is set by the storage")
+
+		stale := got
+		got.Title = "renamed"
+		require.NoError(t, s.UpdateEvent(ctx, got))
+		stale.Title = "lost"
+		require.ErrorIs(t, s.UpdateEvent(ctx, stale), storage.ErrEventChanged)
+		errs, err := s.ApplyBatch(ctx, []storage.BatchOp{{Kind: storage.BatchUpdate, Event: stale}}, false)
+		require.NoError(t, err)
+		require.ErrorIs(t, errs[0], storage.ErrEventChanged)
+
+		got, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, "renamed", got.Title)
+		require.Equal(t, int64(2), got.Version)
+
+		got.Version = 0
+		got.Title = "unconditional"
+		require.NoError(t, s.UpdateEvent(ctx, got), "updates without a version are not checked")
+		require.NoError(t, s.DeleteEvent(ctx, "1", day))
+		require.NoError(t, s.RestoreEvent(ctx, "1"))
+		got, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, "unconditional", got.Title)
+		require.Equal(t, int64(5), got.Version, "deletion and restoration are changes")
+
+		deleted := got
+		deleted.DeletedAt = day
+		deleted.Version = 4
+		errs, err = s.ApplyBatch(ctx, []storage.BatchOp{{Kind: storage.BatchDelete, Event: deleted}}, false)
+		require.NoError(t, err)
+		require.ErrorIs(t, errs[0], storage.ErrEventChanged)
+		deleted.Version = 5
+		errs, err = s.ApplyBatch(ctx, []storage.BatchOp{{Kind: storage.BatchDelete, Event: deleted}}, false)
+		require.NoError(t, err)
+		require.NoError(t, errs[0])
+		got, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.True(t, got.IsDeleted())
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("1", "user-1", 10, 1)))
+		require.ErrorIs(t, s.RestoreEvent(ctx, "1"), storage.ErrEventNotFound)
+		require.NoError(t, s.DeleteEvent(ctx, "1", day))
+
+		events, err := s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Empty(t, events)
+		deleted, err := s.ListDeletedEvents(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+		deleted, err = s.ListDeletedEvents(ctx, "user-2")
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+
+		require.NoError(t, s.CreateEvent(ctx, event("2", "user-1", 10, 1)), "tombstones do not take time")
+		require.ErrorIs(t, s.RestoreEvent(ctx, "1"), storage.ErrDateBusy)
+
+		require.NoError(t, s.DeleteEvent(ctx, "2", day))
+		require.NoError(t, s.RestoreEvent(ctx, "1"))
+		events, err = s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, "1", events[0].ID)
+		require.False(t, events[0].IsDeleted())
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("old", "user-1", -48, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("live", "user-1", 10, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("recently deleted", "user-1", 12, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("long deleted", "user-1", 14, 1)))
+		require.NoError(t, s.DeleteEvent(ctx, "recently deleted", day))
+		require.NoError(t, s.DeleteEvent(ctx, "long deleted", day.Add(-time.Hour)))
+
+		purged, err := s.PurgeEvents(ctx, day.Add(-24*time.Hour), day.Add(-time.Minute))
+		require.NoError(t, err)
+		sort.Slice(purged, func(i, j int) bool { return purged[i].ID < purged[j].ID })
+		require.Len(t, purged, 2)
+		require.Equal(t, "long deleted", purged[0].ID)
+		require.Equal(t, day.Add(-time.Hour), purged[0].DeletedAt)
+		require.Equal(t, event("old", "user-1", -48, 1).Title, purged[1].Title, "purged events are returned")
+
+		_, err = s.GetEvent(ctx, "old")
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+		_, err = s.GetEvent(ctx, "long deleted")
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+		_, err = s.GetEvent(ctx, "recently deleted")
+		require.NoError(t, err)
+		_, err = s.GetEvent(ctx, "live")
+		require.NoError(t, err)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("1", "user-1", 10, 1)))
+		moved := event("1", "user-1", 14, 1)
+		deleted := event("1", "user-1", 14, 1)
+		deleted.DeletedAt = day
+		ops := []storage.BatchOp{
+			{Kind: storage.BatchCreate, Event: event("2", "user-1", 12, 1)},
+			{Kind: storage.BatchUpdate, Event: moved},
+			{Kind: storage.BatchCreate, Event: event("3", "user-1", 12, 1)},
+		}
+
+		errs, err := s.ApplyBatch(ctx, ops, true)
+		require.NoError(t, err)
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		require.ErrorIs(t, errs[2], storage.ErrDateBusy)
+		_, err = s.GetEvent(ctx, "2")
+		require.ErrorIs(t, err, storage.ErrEventNotFound, "atomic batch is rolled back")
+		got, err := s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		created := event("1", "user-1", 10, 1)
+		created.Version = 1
+		require.Equal(t, created, got)
+
+		errs, err = s.ApplyBatch(ctx, append(ops, storage.BatchOp{Kind: storage.BatchDelete, Event: deleted}), false)
+		require.NoError(t, err)
+		require.Len(t, errs, 4)
+		require.ErrorIs(t, errs[2], storage.ErrDateBusy)
+		require.NoError(t, errs[3])
+		got, err = s.GetEvent(ctx, "2")
+		require.NoError(t, err)
+		require.Equal(t, "2", got.ID)
+		got, err = s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		deleted.Version = 3
+		require.Equal(t, deleted, got)
+	})
+
+	t.Run("date busy", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("1", "user-1", 10, 2)))
+		require.ErrorIs(t, s.CreateEvent(ctx, event("2", "user-1", 11, 2)), storage.ErrDateBusy)
+		require.NoError(t, s.CreateEvent(ctx, event("3", "user-1", 12, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("4", "user-2", 11, 2)))
+
+		require.ErrorIs(t, s.UpdateEvent(ctx, event("3", "user-1", 9, 2)), storage.ErrDateBusy)
+		require.NoError(t, s.UpdateEvent(ctx, event("1", "user-1", 9, 3)))
+
+		work := event("5", "user-1", 10, 1)
+		work.CalendarID = "work"
+		require.NoError(t, s.CreateEvent(ctx, work), "calendars of a user do not conflict")
+		work.ID = "6"
+		require.ErrorIs(t, s.CreateEvent(ctx, work), storage.ErrDateBusy)
+		work.ID, work.CalendarID = "7", "user-1"
+		require.ErrorIs(t, s.CreateEvent(ctx, work), storage.ErrDateBusy, "user ID is the default calendar")
+
+		events, err := s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, events, 3, "events of all calendars are listed")
+	})
+
+	t.Run("list boundaries", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("ends at from", "user-1", -1, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("starts at from", "user-1", 0, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("ends at to", "user-1", 23, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("starts at to", "user-1", 24, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("covers period", "user-2", -1, 26)))
+
+		events, err := s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Equal(t, []string{"starts at from", "ends at to"}, ids(events))
+
+		events, err = s.ListEvents(ctx, "user-2", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Equal(t, []string{"covers period"}, ids(events))
+
+		events, err = s.ListEvents(ctx, "user-1", day.Add(time.Hour), day.Add(23*time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("late", "user-1", 30, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("early", "user-1", 1, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("overnight", "user-1", 23, 2)))
+		require.NoError(t, s.CreateEvent(ctx, event("other", "user-2", 5, 1)))
+
+		events, err := s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		require.Equal(t, "early", events[0].ID)
+		require.Equal(t, "overnight", events[1].ID)
+
+		events, err = s.ListEvents(ctx, "user-3", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("events to notify", func(t *testing.T) {
+		s := newStorage(t)
+
+		notified := event("notified", "user-1", 10, 1)
+		notified.NotifyBefore = time.Hour
+		deleted := event("deleted", "user-1", 12, 1)
+		deleted.NotifyBefore = time.Hour
+		require.NoError(t, s.CreateEvent(ctx, notified))
+		require.NoError(t, s.CreateEvent(ctx, deleted))
+		require.NoError(t, s.CreateEvent(ctx, event("silent", "user-1", 11, 1)))
+		require.NoError(t, s.DeleteEvent(ctx, "deleted", day))
+
+		events, err := s.ListEventsToNotify(ctx, day.Add(8*time.Hour), day.Add(12*time.Hour))
+		require.NoError(t, err)
+		notified.Version = 1
+		require.Equal(t, []storage.Event{notified}, events)
+
+		events, err = s.ListEventsToNotify(ctx, day.Add(9*time.Hour+time.Minute), day.Add(12*time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("busy", func(t *testing.T) {
+		s := newStorage(t)
+
+		require.NoError(t, s.CreateEvent(ctx, event("long", "user-1", -20, 21)))
+		require.NoError(t, s.CreateEvent(ctx, event("ends at from", "user-2", -1, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("meeting", "user-2", 10, 2)))
+		require.NoError(t, s.CreateEvent(ctx, event("deleted", "user-2", 14, 1)))
+		require.NoError(t, s.DeleteEvent(ctx, "deleted", day))
+		require.NoError(t, s.CreateEvent(ctx, event("starts at to", "user-2", 24, 1)))
+		require.NoError(t, s.CreateEvent(ctx, event("other", "user-3", 10, 1)))
+
+		busy, err := s.ListBusy(ctx, []string{"user-1", "user-2", "user-4"}, day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		sort.Slice(busy, func(i, j int) bool {
+			return busy[i].StartAt.Before(busy[j].StartAt)
+		})
+		require.Equal(t, []storage.Busy{
+			{UserID: "user-1", StartAt: day.Add(-20 * time.Hour), EndAt: day.Add(time.Hour)},
+			{UserID: "user-2", StartAt: day.Add(10 * time.Hour), EndAt: day.Add(12 * time.Hour)},
+		}, busy)
+
+		busy, err = s.ListBusy(ctx, nil, day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Empty(t, busy)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		s := newStorage(t)
+
+		records, err := s.ListAuditRecords(ctx, "1")
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		created := storage.AuditRecord{EventID: "1", Actor: "user-1", Action: storage.AuditCreated}
+		deleted := storage.AuditRecord{EventID: "1", Actor: "user-1", Action: storage.AuditDeleted}
+		require.NoError(t, s.AddAuditRecord(ctx, created))
+		require.NoError(t, s.AddAuditRecord(ctx, storage.AuditRecord{EventID: "2"}))
+		require.NoError(t, s.AddAuditRecord(ctx, deleted))
+
+		records, err = s.ListAuditRecords(ctx, "1")
+		require.NoError(t, err)
+		require.Equal(t, []storage.AuditRecord{created, deleted}, records)
+	})
+
+	t.Run("calendars", func(t *testing.T) {
+		s := newStorage(t)
+
+		work := storage.Calendar{ID: "work", Name: "Work", OwnerID: "user-1"}
+		team := storage.Calendar{
+			ID:      "team",
+			Name:    "Team",
+			OwnerID: "user-2",
+			Shares:  map[string]storage.Role{"user-1": storage.RoleWriter, "user-3": storage.RoleReader},
+		}
+		require.NoError(t, s.CreateCalendar(ctx, work))
+		require.NoError(t, s.CreateCalendar(ctx, team))
+		require.ErrorIs(t, s.CreateCalendar(ctx, work), storage.ErrCalendarExists)
+
+		got, err := s.GetCalendar(ctx, "team")
+		require.NoError(t, err)
+		require.Equal(t, team, got)
+		_, err = s.GetCalendar(ctx, "home")
+		require.ErrorIs(t, err, storage.ErrCalendarNotFound)
+
+		calendars, err := s.ListCalendars(ctx, "user-1")
+		require.NoError(t, err)
+		require.Equal(t, []storage.Calendar{team, work}, calendars)
+		calendars, err = s.ListCalendars(ctx, "user-3")
+		require.NoError(t, err)
+		require.Equal(t, []storage.Calendar{team}, calendars)
+
+		team.Name = "Squad"
+		team.Shares = map[string]storage.Role{"user-4": storage.RoleReader}
+		require.NoError(t, s.UpdateCalendar(ctx, team))
+		require.ErrorIs(t, s.UpdateCalendar(ctx, storage.Calendar{ID: "home"}), storage.ErrCalendarNotFound)
+		calendars, err = s.ListCalendars(ctx, "user-1")
+		require.NoError(t, err)
+		require.Equal(t, []storage.Calendar{work}, calendars, "unshared calendar is not listed")
+		calendars, err = s.ListCalendars(ctx, "user-4")
+		require.NoError(t, err)
+		require.Equal(t, []storage.Calendar{team}, calendars)
+
+		planning := event("1", "user-2", 10, 1)
+		planning.CalendarID = "team"
+		require.NoError(t, s.CreateEvent(ctx, planning))
+		require.ErrorIs(t, s.DeleteCalendar(ctx, "team"), storage.ErrCalendarNotEmpty)
+		require.NoError(t, s.DeleteEvent(ctx, "1", day))
+		require.ErrorIs(t, s.DeleteCalendar(ctx, "team"), storage.ErrCalendarNotEmpty, "deleted events count")
+		_, err = s.PurgeEvents(ctx, time.Time{}, day.Add(time.Hour))
+		require.NoError(t, err)
+
+		require.NoError(t, s.DeleteCalendar(ctx, "team"))
+		require.ErrorIs(t, s.DeleteCalendar(ctx, "team"), storage.ErrCalendarNotFound)
+		_, err = s.GetCalendar(ctx, "team")
+		require.ErrorIs(t, err, storage.ErrCalendarNotFound)
+		calendars, err = s.ListCalendars(ctx, "user-2")
+		require.NoError(t, err)
+		require.Empty(t, calendars)
+	})
+
+	t.Run("working hours", func(t *testing.T) {
+		s := newStorage(t)
+
+		_, err := s.GetWorkingHours(ctx, "user-1")
+		require.ErrorIs(t, err, storage.ErrWorkingHoursNotFound)
+
+		hours := storage.WorkingHours{
+			UserID:   "user-1",
+			TimeZone: "Europe/Berlin",
+			Days:     []time.Weekday{time.Monday, time.Tuesday},
+			Start:    9 * time.Hour,
+			End:      17*time.Hour + 30*time.Minute,
+			Holidays: "de",
+		}
+		require.NoError(t, s.SetWorkingHours(ctx, hours))
+		got, err := s.GetWorkingHours(ctx, "user-1")
+		require.NoError(t, err)
+		require.Equal(t, hours, got)
+
+		hours.Days = []time.Weekday{time.Sunday}
+		hours.Holidays = ""
+		require.NoError(t, s.SetWorkingHours(ctx, hours))
+		got, err = s.GetWorkingHours(ctx, "user-1")
+		require.NoError(t, err)
+		require.Equal(t, hours, got)
+		_, err = s.GetWorkingHours(ctx, "user-2")
+		require.ErrorIs(t, err, storage.ErrWorkingHoursNotFound)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		s := newStorage(t)
+
+		stats, err := s.Stats(ctx, day)
+		require.NoError(t, err)
+		require.Equal(t, storage.Stats{}, stats)
+
+		reminded := event("1", "user-1", 10, 1)
+		reminded.NotifyBefore = time.Hour
+		require.NoError(t, s.CreateEvent(ctx, reminded))
+		require.NoError(t, s.CreateEvent(ctx, event("2", "user-1", 11, 1)))
+		deleted := event("3", "user-2", 10, 1)
+		deleted.NotifyBefore = time.Hour
+		require.NoError(t, s.CreateEvent(ctx, deleted))
+		require.NoError(t, s.DeleteEvent(ctx, "3", day))
+
+		stats, err = s.Stats(ctx, day)
+		require.NoError(t, err)
+		require.Equal(t, storage.Stats{Events: 2, DeletedEvents: 1, Reminders: 1}, stats)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		s := newStorage(t)
+
+		for i := 0; i < 30; i++ {
+			require.NoError(t, s.CreateEvent(ctx, event(strconv.Itoa(i), "user-"+strconv.Itoa(i%3), i, 1)))
+		}
+		require.NoError(t, s.DeleteEvent(ctx, "13", day))
+
+		export := func(userID string, from, to time.Time) []string {
+			ids := make([]string, 0)
+			err := s.ExportEvents(ctx, userID, from, to, func(event storage.Event) error {
+				ids = append(ids, event.ID)
+				return nil
+			})
+			require.NoError(t, err)
+			sort.Strings(ids)
+			return ids
+		}
+		require.Equal(t, []string{"1", "10", "16", "19", "22", "25", "28", "4", "7"},
+			export("user-1", day, day.Add(48*time.Hour)), "deleted events are not exported")
+		require.Equal(t, []string{"10", "11", "12", "14"},
+			export("", day.Add(10*time.Hour+30*time.Minute), day.Add(15*time.Hour)))
+		require.Empty(t, export("user-3", day, day.Add(48*time.Hour)))
+
+		stop := errors.New("stop")
+		calls := 0
+		err := s.ExportEvents(ctx, "", day, day.Add(48*time.Hour), func(storage.Event) error {
+			calls++
+			return stop
+		})
+		require.ErrorIs(t, err, stop)
+		require.Equal(t, 1, calls, "the export stops at the first error")
+	})
+
+	t.Run("lease", func(t *testing.T) {
+		s := newStorage(t)
+
+		ok, err := s.AcquireLease(ctx, "reminders", "a", time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+		ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+		require.NoError(t, err)
+		require.False(t, ok, "the lease is held by another one")
+		ok, err = s.AcquireLease(ctx, "snapshots", "b", time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok, "leases are independent")
+		ok, err = s.AcquireLease(ctx, "reminders", "a", time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok, "the holder renews its lease")
+
+		require.NoError(t, s.ReleaseLease(ctx, "reminders", "b"))
+		ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+		require.NoError(t, err)
+		require.False(t, ok, "only the holder releases its lease")
+
+		require.NoError(t, s.ReleaseLease(ctx, "reminders", "a"))
+		ok, err = s.AcquireLease(ctx, "reminders", "b", time.Minute)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		s := newStorage(t)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				s.CreateEvent(ctx, event(strconv.Itoa(i), "user-1", i%10, 1))
+				s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+			}(i)
+		}
+		wg.Wait()
+
+		events, err := s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, events, 10)
+		for i := 1; i < len(events); i++ {
+			require.False(t, events[i-1].Overlaps(events[i]), "concurrent creates must not overlap")
+		}
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		s := newStorage(t)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, s.CreateEvent(ctx, event(strconv.Itoa(i), "user-1", 2*i, 1)))
+		}
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				s.UpdateEvent(ctx, event(strconv.Itoa(i), "user-1", 2*i+1, 1))
+			}(i)
+			go func(i int) {
+				defer wg.Done()
+				s.DeleteEvent(ctx, strconv.Itoa(i), day)
+			}(i)
+		}
+		wg.Wait()
+
+		events, err := s.ListEvents(ctx, "user-1", day, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Empty(t, events, "every event is deleted whatever the order")
+		deleted, err := s.ListDeletedEvents(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, deleted, 10)
+	})
+
+	t.Run("event limit", func(t *testing.T) {
+		s := newStorage(t)
+		limited := storage.ContextWithEventLimit(ctx, 2)
+
+		require.NoError(t, s.CreateEvent(limited, event("1", "user-1", 10, 1)))
+		require.NoError(t, s.CreateEvent(limited, event("2", "user-1", 12, 1)))
+		require.ErrorIs(t, s.CreateEvent(limited, event("3", "user-1", 14, 1)), storage.ErrTooManyEvents)
+		_, err := s.GetEvent(ctx, "3")
+		require.ErrorIs(t, err, storage.ErrEventNotFound)
+
+		create := storage.BatchOp{Kind: storage.BatchCreate, Event: event("3", "user-1", 14, 1)}
+		_, err = s.ApplyBatch(limited, []storage.BatchOp{create}, false)
+		require.ErrorIs(t, err, storage.ErrTooManyEvents)
+		_, err = s.GetEvent(ctx, "3")
+		require.ErrorIs(t, err, storage.ErrEventNotFound, "the batch has no effect")
+		deleted := event("1", "user-1", 10, 1)
+		deleted.DeletedAt = day
+		errs, err := s.ApplyBatch(limited, []storage.BatchOp{{Kind: storage.BatchDelete, Event: deleted}, create}, false)
+		require.NoError(t, err, "tombstones do not count")
+		require.Equal(t, []error{nil, nil}, errs)
+
+		require.ErrorIs(t, s.RestoreEvent(limited, "1"), storage.ErrTooManyEvents)
+		got, err := s.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		require.True(t, got.IsDeleted())
+		require.NoError(t, s.UpdateEvent(limited, event("2", "user-1", 16, 1)), "updates are not limited")
+		require.NoError(t, s.CreateEvent(ctx, event("4", "user-1", 18, 1)), "the limit is set per call")
+	})
+
+	t.Run("concurrent creates within the limit", func(t *testing.T) {
+		s := newStorage(t)
+		limited := storage.ContextWithEventLimit(ctx, 5)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				s.CreateEvent(limited, event(strconv.Itoa(i), "user-"+strconv.Itoa(i), 10, 1))
+			}(i)
+		}
+		wg.Wait()
+
+		stats, err := s.Stats(ctx, day)
+		require.NoError(t, err)
+		require.LessOrEqual(t, stats.Events, 5)
+	})
+}
+
+// RunTenants checks that the storages of two tenants created by newTenants share no data,
+// even when they use the same IDs. newTenants is called for every subtest and must return
+// the empty storages of two different tenants of the same backend.
+func RunTenants(t *testing.T, newTenants func(t *testing.T) (Storage, Storage)) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("events", func(t *testing.T) {
+		a, b := newTenants(t)
+
+		first, second := event("1", "user-1", 10, 1), event("1", "user-1", 10, 2)
+		first.NotifyBefore = time.Hour
+		require.NoError(t, a.CreateEvent(ctx, first))
+		require.NoError(t, b.CreateEvent(ctx, second), "the same ID and time are free in another tenant")
+
+		got, err := a.GetEvent(ctx, "1")
+		require.NoError(t, err)
+		first.Version = 1
+		require.Equal(t, first, got)
+		require.NoError(t, b.DeleteEvent(ctx, "1", day))
+		_, err = a.GetEvent(ctx, "1")
+		require.NoError(t, err)
+
+		events, err := b.ListEvents(ctx, "user-1", day, day.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, events)
+		busy, err := b.ListBusy(ctx, []string{"user-1"}, day, day.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, busy)
+		toNotify, err := b.ListEventsToNotify(ctx, day, day.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, toNotify)
+		deleted, err := a.ListDeletedEvents(ctx, "user-1")
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+
+		exported := 0
+		err = b.ExportEvents(ctx, "", day, day.Add(24*time.Hour), func(storage.Event) error {
+			exported++
+			return nil
+		})
+		require.NoError(t, err)
+		require.Zero(t, exported)
+
+		stats, err := a.Stats(ctx, day)
+		require.NoError(t, err)
+		require.Equal(t, storage.Stats{Events: 1, Reminders: 1}, stats)
+		stats, err = b.Stats(ctx, day)
+		require.NoError(t, err)
+		require.Equal(t, storage.Stats{DeletedEvents: 1}, stats)
+
+		purged, err := a.PurgeEvents(ctx, day.Add(48*time.Hour), day.Add(time.Hour))
+		require.NoError(t, err)
+		require.Len(t, purged, 1, "only the events of the tenant are purged")
+		deleted, err = b.ListDeletedEvents(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, deleted, 1)
+	})
+
+	t.Run("calendars and working hours", func(t *testing.T) {
+		a, b := newTenants(t)
+
+		calendar := storage.Calendar{ID: "team", Name: "Team", OwnerID: "user-1"}
+		require.NoError(t, a.CreateCalendar(ctx, calendar))
+		require.NoError(t, b.CreateCalendar(ctx, calendar))
+		require.NoError(t, b.DeleteCalendar(ctx, "team"))
+		_, err := a.GetCalendar(ctx, "team")
+		require.NoError(t, err)
+		calendars, err := b.ListCalendars(ctx, "user-1")
+		require.NoError(t, err)
+		require.Empty(t, calendars)
+
+		hours := storage.WorkingHours{UserID: "user-1", TimeZone: "UTC", Days: []time.Weekday{time.Monday}, End: time.Hour}
+		require.NoError(t, a.SetWorkingHours(ctx, hours))
+		_, err = b.GetWorkingHours(ctx, "user-1")
+		require.ErrorIs(t, err, storage.ErrWorkingHoursNotFound)
+
+		require.NoError(t, a.AddAuditRecord(ctx, storage.AuditRecord{EventID: "1", Action: storage.AuditCreated}))
+		records, err := b.ListAuditRecords(ctx, "1")
+		require.NoError(t, err)
+		require.Empty(t, records)
+	})
+}
+
+func ids(events []storage.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
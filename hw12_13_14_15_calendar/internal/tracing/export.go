@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Logger interface {
+	Info(msg string)
+}
+
+// LogExporter writes every span as a line to the logger.
+type LogExporter struct {
+	logger Logger
+}
+
+func NewLogExporter(logger Logger) *LogExporter {
+	return &LogExporter{logger: logger}
+}
+
+func (e *LogExporter) Export(span SpanData) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "span %s trace=%s span=%s", span.Name, span.TraceID, span.SpanID)
+	if span.ParentID != "" {
+		fmt.Fprintf(&b, " parent=%s", span.ParentID)
+	}
+	fmt.Fprintf(&b, " duration=%s", span.Duration)
+
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%q", key, span.Attributes[key])
+	}
+	if span.Err != "" {
+		fmt.Fprintf(&b, " error=%q", span.Err)
+	}
+	e.logger.Info(b.String())
+}
+
+// FileExporter appends every span to a file as a line of JSON.
+type FileExporter struct {
+	mu   sync.Mutex
+	w    io.WriteCloser
+	errs func(err error)
+}
+
+// NewFileExporter opens the file at path for appending. Write errors are passed to onError.
+func NewFileExporter(path string, onError func(err error)) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{w: file, errs: onError}, nil
+}
+
+func (e *FileExporter) Export(span SpanData) {
+	data, err := json.Marshal(span)
+	if err == nil {
+		e.mu.Lock()
+		_, err = e.w.Write(append(data, '\n'))
+		e.mu.Unlock()
+	}
+	if err != nil && e.errs != nil {
+		e.errs(fmt.Errorf("export span %s: %w", span.Name, err))
+	}
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.w.Close()
+}
@@ -0,0 +1,209 @@
+// Package tracing records spans of work done for a request across the calendar services
+// and propagates the trace between them in the W3C Trace Context "traceparent" format.
+//
+// Only entry points such as servers and background loops need a Tracer: they start root spans
+// or continue a trace extracted from the request. Code down the call chain starts child spans
+// with Start, which is a no-op unless ctx already carries a span.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled tells whether spans of the trace are exported.
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// SpanData is a finished span handed to the exporter.
+type SpanData struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string `json:",omitempty"`
+	Start      time.Time
+	Duration   time.Duration
+	Attributes map[string]string `json:",omitempty"`
+	Err        string            `json:",omitempty"`
+}
+
+// Exporter receives finished sampled spans.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, now: time.Now}
+}
+
+// Span is a named and timed piece of work. A nil *Span is a valid no-op span.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs map[string]string
+	err   string
+	ended bool
+}
+
+type ctxKey int
+
+const (
+	spanKey ctxKey = iota
+	remoteKey
+)
+
+// Start begins a span as a child of the span or the remote span context in ctx,
+// or as the root of a new trace. A nil Tracer starts no-op spans.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: t, name: name, start: t.now()}
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.sc = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+		span.parent = parent.SpanID
+	} else {
+		span.sc = SpanContext{TraceID: newTraceID(), Sampled: true}
+	}
+	span.sc.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Start begins a child span of the span in ctx using its tracer.
+// If ctx carries no span the work is not traced and a no-op span is returned.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name)
+}
+
+// SpanFromContext returns the current span of ctx or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the context of the current span of ctx
+// or, if there is none, the remote span context extracted into ctx.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.sc
+	}
+	sc, _ := ctx.Value(remoteKey).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx in which spans continue the trace of sc
+// received from another service instead of the current span of ctx.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(context.WithValue(ctx, spanKey, (*Span)(nil)), remoteKey, sc)
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attrs == nil {
+		s.attrs = make(map[string]string)
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks the span as failed with err; a nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err.Error()
+}
+
+// End finishes the span and exports it if the trace is sampled. Only the first call has effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		Name:       s.name,
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Start:      s.start,
+		Duration:   s.tracer.now().Sub(s.start),
+		Attributes: s.attrs,
+		Err:        s.err,
+	}
+	if s.parent != (SpanID{}) {
+		data.ParentID = s.parent.String()
+	}
+	s.mu.Unlock()
+
+	if s.sc.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+func newTraceID() (id TraceID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
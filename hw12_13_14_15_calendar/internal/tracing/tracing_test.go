@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(span SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, span)
+}
+
+func TestSpans(t *testing.T) {
+	t.Run("children share the trace", func(t *testing.T) {
+		rec := &recorder{}
+		ctx, root := New(rec).Start(context.Background(), "root")
+		childCtx, child := Start(ctx, "child")
+		_, grandchild := Start(childCtx, "grandchild")
+		grandchild.SetError(errors.New("boom"))
+		grandchild.End()
+		child.SetAttribute("key", "value")
+		child.End()
+		child.End()
+		root.End()
+
+		require.Len(t, rec.spans, 3)
+		gc, c, r := rec.spans[0], rec.spans[1], rec.spans[2]
+		require.Equal(t, r.TraceID, c.TraceID)
+		require.Equal(t, r.TraceID, gc.TraceID)
+		require.Empty(t, r.ParentID)
+		require.Equal(t, r.SpanID, c.ParentID)
+		require.Equal(t, c.SpanID, gc.ParentID)
+		require.Equal(t, "boom", gc.Err)
+		require.Equal(t, map[string]string{"key": "value"}, c.Attributes)
+	})
+
+	t.Run("untraced context", func(t *testing.T) {
+		ctx, span := Start(context.Background(), "child")
+		require.Nil(t, span)
+		require.False(t, SpanContextFromContext(ctx).IsValid())
+		span.SetAttribute("key", "value")
+		span.SetError(errors.New("boom"))
+		span.End()
+
+		var tracer *Tracer
+		_, span = tracer.Start(context.Background(), "root")
+		require.Nil(t, span)
+	})
+
+	t.Run("unsampled remote parent", func(t *testing.T) {
+		rec := &recorder{}
+		ctx := Extract(context.Background(), MapCarrier{
+			TraceParentHeader: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		})
+		_, span := New(rec).Start(ctx, "root")
+		span.End()
+
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context().TraceID.String())
+		require.Empty(t, rec.spans)
+	})
+}
+
+func TestPropagation(t *testing.T) {
+	ctx, span := New(nil).Start(context.Background(), "root")
+	header := http.Header{}
+	Inject(ctx, HeaderCarrier(header))
+
+	remote := SpanContextFromContext(Extract(context.Background(), HeaderCarrier(header)))
+	require.Equal(t, span.Context(), remote)
+
+	other, _ := New(nil).Start(context.Background(), "other")
+	remote = SpanContextFromContext(Extract(other, HeaderCarrier(header)))
+	require.Equal(t, span.Context(), remote, "the extracted trace replaces the current one")
+
+	empty := MapCarrier{}
+	Inject(context.Background(), empty)
+	require.Empty(t, empty)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.True(t, sc.Sampled)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, err := ParseTraceParent(value)
+		require.Error(t, err, value)
+	}
+}
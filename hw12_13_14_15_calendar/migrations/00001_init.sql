@@ -0,0 +1,57 @@
+-- +goose Up
+CREATE TABLE events (
+    id            TEXT PRIMARY KEY,
+    title         TEXT NOT NULL,
+    start_at      TIMESTAMPTZ NOT NULL,
+    end_at        TIMESTAMPTZ NOT NULL,
+    description   TEXT NOT NULL DEFAULT '',
+    user_id       TEXT NOT NULL,
+    -- Empty for events in the default calendar of the user.
+    calendar_id   TEXT NOT NULL DEFAULT '',
+    -- Nanoseconds before start_at to send a notification, 0 for none.
+    notify_before BIGINT NOT NULL DEFAULT 0,
+    -- Set for tombstones of deleted events.
+    deleted_at    TIMESTAMPTZ
+);
+CREATE INDEX events_user_start_at ON events (user_id, start_at) WHERE deleted_at IS NULL;
+CREATE INDEX events_user_deleted_at ON events (user_id, deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX events_end_at ON events (end_at);
+
+-- Reminder jobs written in the same transaction as the changes of events,
+-- at most one pending job per event. Jobs are removed once consumed.
+CREATE TABLE reminder_outbox (
+    event_id  TEXT PRIMARY KEY,
+    notify_at TIMESTAMPTZ NOT NULL,
+    -- State of the event to notify about as JSON.
+    event     JSONB NOT NULL
+);
+CREATE INDEX reminder_outbox_notify_at ON reminder_outbox (notify_at);
+
+CREATE TABLE calendars (
+    id       TEXT PRIMARY KEY,
+    name     TEXT NOT NULL,
+    owner_id TEXT NOT NULL
+);
+CREATE INDEX calendars_owner_id ON calendars (owner_id);
+
+CREATE TABLE calendar_shares (
+    calendar_id TEXT NOT NULL REFERENCES calendars (id) ON DELETE CASCADE,
+    user_id     TEXT NOT NULL,
+    role        TEXT NOT NULL,
+    PRIMARY KEY (calendar_id, user_id)
+);
+CREATE INDEX calendar_shares_user_id ON calendar_shares (user_id);
+
+CREATE TABLE audit_records (
+    id       BIGSERIAL PRIMARY KEY,
+    event_id TEXT NOT NULL,
+    record   JSONB NOT NULL
+);
+CREATE INDEX audit_records_event_id ON audit_records (event_id, id);
+
+-- +goose Down
+DROP TABLE audit_records;
+DROP TABLE calendar_shares;
+DROP TABLE calendars;
+DROP TABLE reminder_outbox;
+DROP TABLE events;
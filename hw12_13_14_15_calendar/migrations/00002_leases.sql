@@ -0,0 +1,10 @@
+-- +goose Up
+-- Leases elect the single replica running a background job, such as sending reminders.
+CREATE TABLE leases (
+    name       TEXT PRIMARY KEY,
+    holder     TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- +goose Down
+DROP TABLE leases;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Links and the metadata of attachments of an event as JSON arrays; contents are kept in the blob store.
+ALTER TABLE events ADD COLUMN links JSONB NOT NULL DEFAULT 'null';
+ALTER TABLE events ADD COLUMN attachments JSONB NOT NULL DEFAULT 'null';
+
+-- +goose Down
+ALTER TABLE events DROP COLUMN attachments;
+ALTER TABLE events DROP COLUMN links;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE working_hours (
+    user_id   TEXT PRIMARY KEY,
+    time_zone TEXT NOT NULL,
+    -- Working weekdays, 0 is Sunday.
+    days      SMALLINT[] NOT NULL,
+    -- Working time of day in microseconds since midnight.
+    start_at  BIGINT NOT NULL,
+    end_at    BIGINT NOT NULL,
+    -- Name of the holiday calendar the user follows, empty for none.
+    holidays  TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose Down
+DROP TABLE working_hours;
@@ -0,0 +1,88 @@
+-- +goose Up
+-- Every row belongs to the tenant in tenant_id and every query filters on it. Rows written
+-- before tenants existed belong to the default tenant ''. IDs only need to be unique within a tenant.
+ALTER TABLE calendar_shares DROP CONSTRAINT calendar_shares_calendar_id_fkey;
+
+ALTER TABLE events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE events DROP CONSTRAINT events_pkey, ADD PRIMARY KEY (tenant_id, id);
+DROP INDEX events_user_start_at;
+DROP INDEX events_user_deleted_at;
+DROP INDEX events_end_at;
+CREATE INDEX events_user_start_at ON events (tenant_id, user_id, start_at) WHERE deleted_at IS NULL;
+CREATE INDEX events_user_deleted_at ON events (tenant_id, user_id, deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX events_end_at ON events (tenant_id, end_at);
+
+ALTER TABLE reminder_outbox ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE reminder_outbox DROP CONSTRAINT reminder_outbox_pkey, ADD PRIMARY KEY (tenant_id, event_id);
+DROP INDEX reminder_outbox_notify_at;
+CREATE INDEX reminder_outbox_notify_at ON reminder_outbox (tenant_id, notify_at);
+
+ALTER TABLE calendars ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE calendars DROP CONSTRAINT calendars_pkey, ADD PRIMARY KEY (tenant_id, id);
+DROP INDEX calendars_owner_id;
+CREATE INDEX calendars_owner_id ON calendars (tenant_id, owner_id);
+
+ALTER TABLE calendar_shares ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE calendar_shares DROP CONSTRAINT calendar_shares_pkey, ADD PRIMARY KEY (tenant_id, calendar_id, user_id);
+ALTER TABLE calendar_shares ADD CONSTRAINT calendar_shares_calendar_id_fkey
+    FOREIGN KEY (tenant_id, calendar_id) REFERENCES calendars (tenant_id, id) ON DELETE CASCADE;
+DROP INDEX calendar_shares_user_id;
+CREATE INDEX calendar_shares_user_id ON calendar_shares (tenant_id, user_id);
+
+ALTER TABLE audit_records ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+DROP INDEX audit_records_event_id;
+CREATE INDEX audit_records_event_id ON audit_records (tenant_id, event_id, id);
+
+ALTER TABLE working_hours ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE working_hours DROP CONSTRAINT working_hours_pkey, ADD PRIMARY KEY (tenant_id, user_id);
+
+-- Without defaults a statement forgetting the tenant fails instead of writing to the default one.
+ALTER TABLE events ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE reminder_outbox ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE calendars ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE calendar_shares ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE audit_records ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE working_hours ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- +goose Down
+-- Only the default tenant is kept, the rows of the others are dropped.
+DELETE FROM working_hours WHERE tenant_id <> '';
+DELETE FROM audit_records WHERE tenant_id <> '';
+DELETE FROM calendar_shares WHERE tenant_id <> '';
+DELETE FROM calendars WHERE tenant_id <> '';
+DELETE FROM reminder_outbox WHERE tenant_id <> '';
+DELETE FROM events WHERE tenant_id <> '';
+
+ALTER TABLE working_hours DROP CONSTRAINT working_hours_pkey, ADD PRIMARY KEY (user_id);
+ALTER TABLE working_hours DROP COLUMN tenant_id;
+
+DROP INDEX audit_records_event_id;
+CREATE INDEX audit_records_event_id ON audit_records (event_id, id);
+ALTER TABLE audit_records DROP COLUMN tenant_id;
+
+ALTER TABLE calendar_shares DROP CONSTRAINT calendar_shares_calendar_id_fkey;
+DROP INDEX calendar_shares_user_id;
+CREATE INDEX calendar_shares_user_id ON calendar_shares (user_id);
+ALTER TABLE calendar_shares DROP CONSTRAINT calendar_shares_pkey, ADD PRIMARY KEY (calendar_id, user_id);
+ALTER TABLE calendar_shares DROP COLUMN tenant_id;
+
+DROP INDEX calendars_owner_id;
+CREATE INDEX calendars_owner_id ON calendars (owner_id);
+ALTER TABLE calendars DROP CONSTRAINT calendars_pkey, ADD PRIMARY KEY (id);
+ALTER TABLE calendars DROP COLUMN tenant_id;
+ALTER TABLE calendar_shares ADD CONSTRAINT calendar_shares_calendar_id_fkey
+    FOREIGN KEY (calendar_id) REFERENCES calendars (id) ON DELETE CASCADE;
+
+DROP INDEX reminder_outbox_notify_at;
+CREATE INDEX reminder_outbox_notify_at ON reminder_outbox (notify_at);
+ALTER TABLE reminder_outbox DROP CONSTRAINT reminder_outbox_pkey, ADD PRIMARY KEY (event_id);
+ALTER TABLE reminder_outbox DROP COLUMN tenant_id;
+
+DROP INDEX events_user_start_at;
+DROP INDEX events_user_deleted_at;
+DROP INDEX events_end_at;
+CREATE INDEX events_user_start_at ON events (user_id, start_at) WHERE deleted_at IS NULL;
+CREATE INDEX events_user_deleted_at ON events (user_id, deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX events_end_at ON events (end_at);
+ALTER TABLE events DROP CONSTRAINT events_pkey, ADD PRIMARY KEY (id);
+ALTER TABLE events DROP COLUMN tenant_id;
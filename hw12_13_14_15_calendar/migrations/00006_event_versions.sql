@@ -0,0 +1,6 @@
+-- +goose Up
+-- The number of changes of an event, which conditional updates compare with the version they read.
+ALTER TABLE events ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE events DROP COLUMN version;
@@ -0,0 +1,9 @@
+package eventpb
+
+import _ "embed" // for OpenAPI
+
+// OpenAPI is the OpenAPI (Swagger 2.0) description of the HTTP gateway to EventService,
+// generated from api/EventService.proto by protoc-gen-openapiv2.
+//
+//go:embed EventService.swagger.json
+var OpenAPI []byte